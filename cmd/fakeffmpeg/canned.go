@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// header returns the first bytes a real encode of format would write, enough
+// for content sniffing and for tests that check which container came out.
+func header(format string) []byte {
+	switch format {
+	case "3gp", "3g2":
+		return box("ftyp", []byte("3gp4\x00\x00\x02\x00isom3gp4"))
+	case "mp4", "mov", "ipod":
+		return box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2avc1mp41"))
+	case "webm", "matroska":
+		return []byte{0x1a, 0x45, 0xdf, 0xa3}
+	case "flv":
+		return []byte("FLV\x01\x05\x00\x00\x00\x09\x00\x00\x00\x00")
+	case "mp3":
+		return []byte("ID3\x04\x00\x00\x00\x00\x00\x00")
+	case "ogg":
+		return []byte("OggS")
+	case "amr":
+		return []byte("#!AMR\n")
+	case "mpjpeg":
+		return []byte("--ffmpeg\r\nContent-Type: image/jpeg\r\n\r\n\xff\xd8\xff\xd9\r\n")
+	}
+	return nil
+}
+
+// payload returns about n bytes of filler that keeps the stream well formed:
+// mdat boxes for ISO media, null packets for MPEG-TS, zeros otherwise.
+func payload(format string, n int) []byte {
+	switch {
+	case format == "mpegts" || strings.HasSuffix(format, "ts"):
+		packets := max(n/188, 1)
+		out := make([]byte, 0, packets*188)
+		for range packets {
+			pkt := make([]byte, 188)
+			pkt[0], pkt[1], pkt[2], pkt[3] = 0x47, 0x1f, 0xff, 0x10
+			out = append(out, pkt...)
+		}
+		return out
+	case isISOMedia(format):
+		return box("mdat", make([]byte, max(n-8, 0)))
+	}
+	return make([]byte, n)
+}
+
+func isISOMedia(format string) bool {
+	switch format {
+	case "3gp", "3g2", "mp4", "mov", "ipod":
+		return true
+	}
+	return false
+}
+
+func box(kind string, body []byte) []byte {
+	out := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(out, uint32(8+len(body)))
+	copy(out[4:], kind)
+	return append(out, body...)
+}
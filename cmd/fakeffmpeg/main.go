@@ -0,0 +1,300 @@
+// Command fakeffmpeg stands in for ffmpeg so the transcode and RTSP paths
+// can be exercised end to end on a machine without a real one. Point the
+// server at it with YTM_FFMPEG (or transcode.Service.WithCommand).
+//
+// It records its command line and, depending on the output it was asked
+// for, writes canned container bytes to stdout (pipe:1), publishes
+// synthetic RTP to an rtsp:// target, or writes an HLS playlist and
+// segments. Inputs are never opened, except that pipe:0 is drained so the
+// server's input pump does not block. Progress lines go to stderr in the
+// -progress format.
+//
+// Environment:
+//
+//	FAKEFFMPEG_LOG       append each command line to this file as a JSON array
+//	FAKEFFMPEG_DURATION  seconds of output to produce (default 10)
+//	FAKEFFMPEG_EXIT      exit status once the output is done (default 0)
+//	FAKEFFMPEG_FAIL      exit with this status before producing any output
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultDuration = 10 * time.Second
+	// tick is how often output and progress are produced.
+	tick = 100 * time.Millisecond
+)
+
+// invocation is the parsed part of the command line the fake cares about.
+type invocation struct {
+	args       []string
+	output     string
+	format     string
+	fps        int
+	videoCodec string
+	audioCodec string
+	sampleRate int
+	channels   int
+	noVideo    bool
+	noAudio    bool
+	stdinInput bool
+	transport  string
+	movflags   string
+	// segmentPattern is -hls_segment_filename.
+	segmentPattern string
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("fakeffmpeg: ")
+	args := os.Args[1:]
+	if err := record(args); err != nil {
+		log.Fatal(err)
+	}
+	if len(args) == 1 && (args[0] == "-version" || args[0] == "-h") {
+		fmt.Println("ffmpeg version fake Copyright (c) youtube-mini test double")
+		return
+	}
+	if code := envInt("FAKEFFMPEG_FAIL", 0); code != 0 {
+		fmt.Fprintln(os.Stderr, "fake failure requested")
+		os.Exit(code)
+	}
+
+	inv := parse(args)
+	if inv.output == "" {
+		log.Fatal("no output file given")
+	}
+	if inv.stdinInput {
+		go func() { _, _ = io.Copy(io.Discard, os.Stdin) }()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	duration := defaultDuration
+	if v := os.Getenv("FAKEFFMPEG_DURATION"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs >= 0 {
+			duration = time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	p := &progress{fps: inv.fps}
+	var err error
+	switch {
+	case inv.format == "rtsp" || strings.HasPrefix(inv.output, "rtsp://"):
+		err = publishRTSP(inv, duration, p, stop)
+	case inv.format == "hls":
+		err = writeHLS(inv, duration, p, stop)
+	case inv.output == "pipe:1" || inv.output == "-" || inv.output == "pipe:":
+		err = writePipe(os.Stdout, inv, duration, p, stop)
+	default:
+		err = writeFile(inv, duration, p, stop)
+	}
+	p.end()
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(envInt("FAKEFFMPEG_EXIT", 0))
+}
+
+// record appends args to FAKEFFMPEG_LOG as one JSON line.
+func record(args []string) error {
+	path := os.Getenv("FAKEFFMPEG_LOG")
+	if path == "" {
+		return nil
+	}
+	line, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func parse(args []string) invocation {
+	inv := invocation{fps: 15, sampleRate: 44100, channels: 2}
+	value := func(i int) string {
+		if i+1 < len(args) {
+			return args[i+1]
+		}
+		return ""
+	}
+	for i, arg := range args {
+		switch arg {
+		case "-i":
+			if v := value(i); v == "pipe:0" || v == "-" {
+				inv.stdinInput = true
+			}
+		case "-f":
+			inv.format = value(i)
+		case "-r":
+			if n, err := strconv.Atoi(value(i)); err == nil && n > 0 {
+				inv.fps = n
+			}
+		case "-c:v", "-vcodec":
+			inv.videoCodec = value(i)
+		case "-c:a", "-acodec":
+			inv.audioCodec = value(i)
+		case "-ar":
+			if n, err := strconv.Atoi(value(i)); err == nil && n > 0 {
+				inv.sampleRate = n
+			}
+		case "-ac":
+			if n, err := strconv.Atoi(value(i)); err == nil && n > 0 {
+				inv.channels = n
+			}
+		case "-vn":
+			inv.noVideo = true
+		case "-an":
+			inv.noAudio = true
+		case "-rtsp_transport":
+			inv.transport = value(i)
+		case "-movflags":
+			inv.movflags = value(i)
+		case "-hls_segment_filename":
+			inv.segmentPattern = value(i)
+		}
+	}
+	if n := len(args); n > 0 && !strings.HasPrefix(args[n-1], "-") {
+		inv.output = args[n-1]
+	}
+	inv.args = args
+	return inv
+}
+
+// progress reports the fake encode on stderr the way -progress pipe:2 does.
+type progress struct {
+	fps     int
+	frames  int64
+	elapsed time.Duration
+	bytes   int64
+}
+
+func (p *progress) advance(d time.Duration, n int) {
+	p.elapsed += d
+	p.bytes += int64(n)
+	p.frames = int64(p.elapsed.Seconds() * float64(p.fps))
+	p.report("continue")
+}
+
+func (p *progress) end() { p.report("end") }
+
+func (p *progress) report(state string) {
+	fmt.Fprintf(os.Stderr, "frame=%d\nfps=%d\nbitrate=N/A\ntotal_size=%d\nout_time_us=%d\nspeed=1x\nprogress=%s\n",
+		p.frames, p.fps, p.bytes, p.elapsed.Microseconds(), state)
+}
+
+// run calls step every tick until duration has passed or a signal arrives.
+func run(duration time.Duration, stop <-chan os.Signal, step func() error) error {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePipe writes the container header and then one chunk per tick. With
+// -movflags frag_* ISO media comes out as moov and then moof+mdat fragments.
+func writePipe(w io.Writer, inv invocation, duration time.Duration, p *progress, stop <-chan os.Signal) error {
+	head := header(inv.format)
+	chunk := payload(inv.format, 2048)
+	if isISOMedia(inv.format) && strings.Contains(inv.movflags, "frag_") {
+		head = append(head, box("moov", nil)...)
+		chunk = append(box("moof", make([]byte, 16)), chunk...)
+	}
+	if _, err := w.Write(head); err != nil {
+		return nil // the reader went away, as with a closed HTTP client
+	}
+	return run(duration, stop, func() error {
+		if _, err := w.Write(chunk); err != nil {
+			return nil
+		}
+		p.advance(tick, len(chunk))
+		return nil
+	})
+}
+
+func writeFile(inv invocation, duration time.Duration, p *progress, stop <-chan os.Signal) error {
+	f, err := os.Create(inv.output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writePipe(f, inv, duration, p, stop)
+}
+
+// writeHLS produces one segment per second of output and keeps the event
+// playlist current, ending it when the encode finishes.
+func writeHLS(inv invocation, duration time.Duration, p *progress, stop <-chan os.Signal) error {
+	pattern := inv.segmentPattern
+	if pattern == "" {
+		pattern = filepath.Join(filepath.Dir(inv.output), "seg_%05d.ts")
+	}
+	var segments []string
+	writePlaylist := func(ended bool) error {
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:EVENT\n")
+		for _, seg := range segments {
+			fmt.Fprintf(&b, "#EXTINF:1.000000,\n%s\n", filepath.Base(seg))
+		}
+		if ended {
+			b.WriteString("#EXT-X-ENDLIST\n")
+		}
+		tmp := inv.output + ".tmp"
+		if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, inv.output)
+	}
+
+	var sinceSegment time.Duration
+	err := run(duration, stop, func() error {
+		p.advance(tick, 0)
+		if sinceSegment += tick; sinceSegment < time.Second {
+			return nil
+		}
+		sinceSegment = 0
+		seg := fmt.Sprintf(pattern, len(segments))
+		if err := os.WriteFile(seg, payload("mpegts", 188*64), 0o644); err != nil {
+			return err
+		}
+		segments = append(segments, seg)
+		return writePlaylist(false)
+	})
+	if err != nil {
+		return err
+	}
+	return writePlaylist(true)
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+	return fallback
+}
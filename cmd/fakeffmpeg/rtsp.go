@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/pion/rtp"
+)
+
+// track is one synthetic media being published.
+type track struct {
+	media   *description.Media
+	clock   int
+	payload func() []byte
+	seq     uint16
+	ts      uint32
+	ssrc    uint32
+}
+
+// publishRTSP announces the streams the command line asks for and sends one
+// RTP packet per stream and tick, with timestamps advancing in real time.
+func publishRTSP(inv invocation, duration time.Duration, p *progress, stop <-chan os.Signal) error {
+	tracks, err := rtspTracks(inv)
+	if err != nil {
+		return err
+	}
+	desc := &description.Session{}
+	for _, t := range tracks {
+		desc.Medias = append(desc.Medias, t.media)
+	}
+
+	client := &gortsplib.Client{}
+	switch inv.transport {
+	case "tcp":
+		v := gortsplib.TransportTCP
+		client.Transport = &v
+	case "udp":
+		v := gortsplib.TransportUDP
+		client.Transport = &v
+	case "udp_multicast":
+		v := gortsplib.TransportUDPMulticast
+		client.Transport = &v
+	}
+	if err := client.StartRecording(inv.output, desc); err != nil {
+		return fmt.Errorf("publish %s: %w", inv.output, err)
+	}
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- client.Wait() }()
+	return run(duration, stop, func() error {
+		select {
+		case err := <-done:
+			// The server tore the session down; ffmpeg exits the same way.
+			return fmt.Errorf("publish %s: %w", inv.output, err)
+		default:
+		}
+		sent := 0
+		for _, t := range tracks {
+			pkt := &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					Marker:         true,
+					PayloadType:    t.media.Formats[0].PayloadType(),
+					SequenceNumber: t.seq,
+					Timestamp:      t.ts,
+					SSRC:           t.ssrc,
+				},
+				Payload: t.payload(),
+			}
+			if err := client.WritePacketRTP(t.media, pkt); err != nil {
+				return err
+			}
+			sent += len(pkt.Payload)
+			t.seq++
+			t.ts += uint32(t.clock * int(tick) / int(time.Second))
+		}
+		p.advance(tick, sent)
+		return nil
+	})
+}
+
+// rtspTracks picks an RTP format per requested codec. Payloads are filler of
+// the right shape, not decodable media.
+func rtspTracks(inv invocation) ([]*track, error) {
+	var tracks []*track
+	add := func(kind description.MediaType, f format.Format, payload func() []byte) error {
+		if g, ok := f.(*format.Generic); ok {
+			if err := g.Init(); err != nil {
+				return err
+			}
+		}
+		tracks = append(tracks, &track{
+			media:   &description.Media{Type: kind, Formats: []format.Format{f}},
+			clock:   f.ClockRate(),
+			payload: payload,
+			ssrc:    randomUint32(),
+		})
+		return nil
+	}
+	filler := func(n int) func() []byte {
+		return func() []byte { return make([]byte, n) }
+	}
+
+	if !inv.noVideo && inv.videoCodec != "" {
+		var f format.Format
+		switch inv.videoCodec {
+		case "h263", "h263p":
+			f = &format.Generic{PayloadTyp: 96, RTPMa: "H263-1998/90000"}
+		case "libx264", "h264":
+			f = &format.H264{
+				PayloadTyp:        96,
+				PacketizationMode: 1,
+				SPS:               []byte{0x67, 0x42, 0xc0, 0x1e, 0xd9, 0x00, 0xa0, 0x47, 0xfe, 0xc8},
+				PPS:               []byte{0x68, 0xce, 0x3c, 0x80},
+			}
+		default:
+			f = &format.MPEG4Video{PayloadTyp: 96, ProfileLevelID: 1}
+		}
+		if err := add(description.MediaTypeVideo, f, filler(1000)); err != nil {
+			return nil, err
+		}
+	}
+
+	if !inv.noAudio && inv.audioCodec != "" {
+		var f format.Format
+		payload := filler(160)
+		switch inv.audioCodec {
+		case "aac":
+			f = &format.Generic{
+				PayloadTyp: 97,
+				RTPMa:      fmt.Sprintf("MPEG4-GENERIC/%d/%d", inv.sampleRate, inv.channels),
+				FMT: map[string]string{
+					"profile-level-id": "1", "mode": "AAC-hbr", "sizelength": "13",
+					"indexlength": "3", "indexdeltalength": "3",
+					"config": aacConfig(inv.sampleRate, inv.channels),
+				},
+			}
+			payload = aacPayload
+		case "libopencore_amrnb":
+			f = &format.Generic{PayloadTyp: 97, RTPMa: "AMR/8000", FMT: map[string]string{"octet-align": "1"}}
+			payload = filler(21)
+		case "libmp3lame", "mp3":
+			f = &format.MPEG1Audio{}
+		default:
+			return nil, fmt.Errorf("audio codec %q cannot be faked over RTSP", inv.audioCodec)
+		}
+		if err := add(description.MediaTypeAudio, f, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(tracks) == 0 {
+		return nil, errors.New("nothing to publish: no -c:v or -c:a given")
+	}
+	return tracks, nil
+}
+
+// aacPayload is one empty access unit behind an RFC 3640 AU header.
+func aacPayload() []byte {
+	const size = 64
+	out := make([]byte, 4+size)
+	binary.BigEndian.PutUint16(out, 16) // AU-headers-length in bits
+	binary.BigEndian.PutUint16(out[2:], size<<3)
+	return out
+}
+
+// aacConfig is the hex AudioSpecificConfig for AAC-LC at rate and channels.
+func aacConfig(rate, channels int) string {
+	rates := []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+	index := 4
+	for i, r := range rates {
+		if r == rate {
+			index = i
+		}
+	}
+	config := uint16(2)<<11 | uint16(index)<<7 | uint16(channels&0xf)<<3
+	return fmt.Sprintf("%04x", config)
+}
+
+func randomUint32() uint32 {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
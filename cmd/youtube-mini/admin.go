@@ -2,16 +2,24 @@ package main
 
 import (
 	"fmt"
+	"html"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"strings"
 	"sync/atomic"
+	"time"
+
+	"youtube-mini/internal/transcode"
 )
 
 var running int32 = 1 // 1 = on, 0 = off
 var logBuffer = make([]string, 0, 1000)
 
+// adminCrossOrigin keeps other sites from posting admin actions.
+var adminCrossOrigin http.CrossOriginProtection
+
 func adminLog(s string) {
 	if len(logBuffer) > 1000 {
 		logBuffer = logBuffer[1:]
@@ -19,7 +27,7 @@ func adminLog(s string) {
 	logBuffer = append(logBuffer, s)
 }
 
-func startAdmin() {
+func startAdmin(svc *transcode.Service) {
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if !checkAuth(r) {
 			requireAuth(w)
@@ -70,6 +78,9 @@ func startAdmin() {
 		
 		.btn.green { background: #2ba640; }
 		.btn.gray { background: #555; }
+
+		form.inline { display: inline; margin: 0; }
+		button.btn { border: 0; font: inherit; cursor: pointer; }
 		
 		.log-box {
 			background: #111;
@@ -93,11 +104,17 @@ func startAdmin() {
 			<a class="btn green" href="/restart">Restart</a>
 			<a class="btn gray" href="/stop">Stop</a>
 			<a class="btn" href="/logs">Logs</a>
+			<form class="inline" action="/cache/purge" method="post"><button class="btn gray" type="submit">Purge transcode cache</button></form>
+
+			<a class="btn gray" href="/jobs.json">Jobs (JSON)</a>
+
+			<div class="status">Transcode cache: <b>%s</b></div>
+			%s
 		</div>
 		
 		</body>
 		</html>
-		`, status())
+		`, status(), cacheStatus(svc), jobsTable(svc))
 
 	})
 
@@ -114,6 +131,33 @@ func startAdmin() {
 		os.Exit(0)
 	})
 
+	http.HandleFunc("/cache/purge", func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(r) {
+			requireAuth(w)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := adminCrossOrigin.Check(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		n := svc.PurgeCache()
+		adminLog(fmt.Sprintf("Transcode cache purged (%d entries)", n))
+		fmt.Fprintf(w, "Purged %d cached transcodes", n)
+	})
+
+	http.HandleFunc("/jobs.json", func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(r) {
+			requireAuth(w)
+			return
+		}
+		svc.JobsHandler().ServeHTTP(w, r)
+	})
+
 	http.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
@@ -141,6 +185,36 @@ func status() string {
 	return "🔴 stopped"
 }
 
+func cacheStatus(svc *transcode.Service) string {
+	stats := svc.CacheStats()
+	if stats.Dir == "" {
+		return "disabled"
+	}
+	return fmt.Sprintf("%d entries, %d / %d MB", stats.Entries, stats.Bytes>>20, stats.MaxBytes>>20)
+}
+
+func jobsTable(svc *transcode.Service) string {
+	jobs := svc.Jobs()
+	if len(jobs) == 0 {
+		return `<div class="status">Transcode jobs: <b>none running</b></div>`
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<div class="status">Transcode jobs: <b>%d running</b></div>`, len(jobs))
+	b.WriteString(`<table border="1" cellpadding="4" cellspacing="0"><tr><th>#</th><th>Kind</th><th>Video</th><th>Profile</th><th>Client</th><th>Running</th><th>Position</th><th>Speed</th><th>Bitrate</th><th>Frames</th><th>Dropped</th></tr>`)
+	for _, job := range jobs {
+		state := ""
+		if job.Stalled {
+			state = " (stalled)"
+		}
+		fmt.Fprintf(&b, `<tr><td>%d</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%.0fs</td><td>%.2fx%s</td><td>%.0f kbit/s</td><td>%d</td><td>%d</td></tr>`,
+			job.ID, job.Kind, html.EscapeString(job.VideoID), html.EscapeString(string(job.Profile)), html.EscapeString(job.Client),
+			time.Since(job.Started).Round(time.Second), job.Offset+job.Progress.OutTime, job.Progress.Speed, state,
+			job.Progress.BitrateKbps, job.Progress.Frames, job.Progress.DropFrames)
+	}
+	b.WriteString(`</table>`)
+	return b.String()
+}
+
 func restartApp() {
 	cmd := exec.Command(os.Args[0])
 	err := cmd.Start()
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,25 +21,22 @@ const (
 )
 
 func main() {
-	startAdmin()
-
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		runWorker()
+		return
+	}
 	addr := getenv("YOUTUBE_MINI_ADDR", defaultAddr)
 	apiKey := getenv("YOUTUBE_API_KEY", defaultAPIKey)
 	rtspAddr := getenv("YTM_RTSP_ADDR", "")
 
 	yt := youtube.New(apiKey)
 	legacy := transcode.New()
-
-	retroFilterEnv := strings.TrimSpace(os.Getenv("YTM_RETRO_FILTER"))
-	switch strings.ToLower(retroFilterEnv) {
-	case "", "off", "false", "0", "disable":
-		// noop
-	case "default":
-		legacy.WithRetroFilter(transcode.DefaultRetroFilter)
-	default:
-		legacy.WithRetroFilter(retroFilterEnv)
+	if ffmpeg := strings.TrimSpace(os.Getenv("YTM_FFMPEG")); ffmpeg != "" {
+		legacy.WithCommand(ffmpeg)
 	}
 
+	encodingFromEnv(legacy)
+
 	if transport := strings.TrimSpace(os.Getenv("YTM_RTSP_TRANSPORT")); transport != "" {
 		legacy.WithRTSPTransport(transport)
 	}
@@ -48,19 +46,94 @@ func main() {
 		legacy.WithRTSPUDPPorts(rtpEnv, rtcpEnv)
 	}
 
-	legacy.WithStreamResolver(transcode.StreamResolverFunc(func(ctx context.Context, videoID string) (string, error) {
+	legacy.WithScheduler(schedulerConfigFromEnv())
+	if workers := remoteWorkersFromEnv(); workers != nil {
+		legacy.WithBackend(workers)
+	}
+	if dir := strings.TrimSpace(os.Getenv("YTM_TRANSCODE_CACHE_DIR")); dir != "" {
+		maxBytes := int64(getenvInt("YTM_TRANSCODE_CACHE_MB", 1024)) << 20
+		if err := legacy.EnableDiskCache(dir, maxBytes); err != nil {
+			log.Printf("[cache] disabled: %v", err)
+		}
+	}
+	legacy.WithHLSIdleTimeout(getenvDuration("YTM_HLS_IDLE", 0))
+	legacy.WithRTSPIdleTimeout(getenvDuration("YTM_RTSP_IDLE", 0))
+	if user := strings.TrimSpace(os.Getenv("YTM_RTSP_USER")); user != "" {
+		legacy.WithRTSPAuth(user, os.Getenv("YTM_RTSP_PASS"))
+	}
+	if secret := os.Getenv("YTM_RTSP_TOKEN_SECRET"); secret != "" {
+		legacy.WithRTSPURLSigning([]byte(secret), getenvDuration("YTM_RTSP_TOKEN_TTL", 0))
+	}
+	startAdmin(legacy)
+
+	legacy.WithStreamResolver(transcode.StreamResolverFunc(func(ctx context.Context, videoID string) ([]transcode.SourceFormat, error) {
+		video, err := yt.GetVideo(ctx, videoID)
+		if err != nil {
+			return nil, err
+		}
+		sources := transcode.VideoSources(video)
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("stream not available")
+		}
+		return sources, nil
+	}))
+	legacy.WithCaptionResolver(transcode.CaptionResolverFunc(func(ctx context.Context, videoID, lang, tlang string) (string, error) {
 		video, err := yt.GetVideo(ctx, videoID)
 		if err != nil {
 			return "", err
 		}
-		if video.Stream == "" {
-			return "", fmt.Errorf("stream not available")
+		trackURL, ok := video.CaptionURL(lang, tlang)
+		if !ok {
+			return "", fmt.Errorf("no %s captions", lang)
+		}
+		return trackURL, nil
+	}))
+	legacy.WithFeedResolver(transcode.FeedResolverFunc(func(ctx context.Context, channelID string) ([]transcode.FeedEntry, error) {
+		items, err := yt.ChannelFeed(ctx, channelID)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]transcode.FeedEntry, 0, len(items))
+		for _, item := range items {
+			// Live streams and premieres carry no length and are skipped.
+			seconds, _ := transcode.ParseTimeSpec(item.Duration)
+			entries = append(entries, transcode.FeedEntry{
+				VideoID:  item.ID,
+				Title:    item.Title,
+				Channel:  item.Channel,
+				Duration: seconds,
+			})
 		}
-		return video.Stream, nil
+		return entries, nil
 	}))
 	if err := legacy.EnableRTSP(rtspAddr); err != nil {
 		log.Fatalf("rtsp: %v", err)
 	}
+	if getenvBool("YTM_RTSP_HTTP_TUNNEL", false) {
+		if err := legacy.EnableRTSPTunnel(); err != nil {
+			log.Printf("[rtsp] tunnel disabled: %v", err)
+		}
+	}
+	if cert := strings.TrimSpace(os.Getenv("YTM_RTSPS_CERT")); cert != "" {
+		if err := legacy.EnableRTSPS(os.Getenv("YTM_RTSPS_ADDR"), cert, os.Getenv("YTM_RTSPS_KEY")); err != nil {
+			log.Printf("[rtsps] disabled: %v", err)
+		}
+	}
+	if dir := strings.TrimSpace(os.Getenv("YTM_DOWNLOADS_DIR")); dir != "" {
+		if err := legacy.EnableDownloads(transcode.DownloadConfig{
+			Dir:        dir,
+			Workers:    getenvInt("YTM_DOWNLOADS_WORKERS", 1),
+			QuotaBytes: int64(getenvInt("YTM_DOWNLOADS_QUOTA_MB", 10240)) << 20,
+			MaxPending: getenvInt("YTM_DOWNLOADS_MAX_PENDING", 20),
+		}); err != nil {
+			log.Printf("[downloads] disabled: %v", err)
+		} else if err := legacy.EnableSync(transcode.SyncConfig{
+			Hour:       getenvInt("YTM_SYNC_HOUR", 3),
+			QuotaBytes: int64(getenvInt("YTM_SYNC_QUOTA_MB", 0)) << 20,
+		}); err != nil {
+			log.Printf("[sync] disabled: %v", err)
+		}
+	}
 
 	server := app.New(yt, legacy)
 
@@ -77,9 +150,101 @@ func main() {
 	}
 }
 
+// encodingFromEnv applies YTM_RETRO_FILTER and YTM_PROFILES_FILE. Worker
+// nodes run jobs with their own profiles, so they read the same settings.
+func encodingFromEnv(svc *transcode.Service) {
+	retroFilterEnv := strings.TrimSpace(os.Getenv("YTM_RETRO_FILTER"))
+	switch strings.ToLower(retroFilterEnv) {
+	case "", "off", "false", "0", "disable":
+		// noop
+	case "default":
+		svc.WithRetroFilter(transcode.DefaultRetroFilter)
+	default:
+		svc.WithRetroFilter(retroFilterEnv)
+	}
+	if path := strings.TrimSpace(os.Getenv("YTM_PROFILES_FILE")); path != "" {
+		if err := svc.Profiles().LoadFile(path); err != nil {
+			log.Fatalf("profiles: %v", err)
+		}
+	}
+}
+
 func getenv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
 	return fallback
 }
+
+func schedulerConfigFromEnv() transcode.SchedulerConfig {
+	cfg := transcode.SchedulerConfig{
+		MaxConcurrent: getenvInt("YTM_TRANSCODE_MAX", 0),
+		MaxPerClient:  getenvInt("YTM_TRANSCODE_MAX_PER_CLIENT", 0),
+		QueueSize:     getenvInt("YTM_TRANSCODE_QUEUE", 0),
+		QueueTimeout:  getenvDuration("YTM_TRANSCODE_QUEUE_TIMEOUT", 0),
+		RetryAfter:    getenvDuration("YTM_TRANSCODE_RETRY_AFTER", 0),
+	}
+	// YTM_TRANSCODE_MAX_PER_PROFILE accepts either "2" or "retro=2,edge=3,android=1".
+	for _, part := range strings.Split(os.Getenv("YTM_TRANSCODE_MAX_PER_PROFILE"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, found := strings.Cut(part, "=")
+		if !found {
+			if n, err := strconv.Atoi(part); err == nil {
+				cfg.MaxPerProfile = n
+			}
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			log.Printf("[transcode] ignoring profile limit %q: %v", part, err)
+			continue
+		}
+		if cfg.ProfileLimits == nil {
+			cfg.ProfileLimits = make(map[transcode.Profile]int)
+		}
+		cfg.ProfileLimits[transcode.Profile(strings.ToLower(strings.TrimSpace(name)))] = n
+	}
+	return cfg
+}
+
+func getenvInt(key string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("invalid %s=%q: %v", key, raw, err)
+		return fallback
+	}
+	return n
+}
+
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("invalid %s=%q: %v", key, raw, err)
+		return fallback
+	}
+	return d
+}
+
+func getenvBool(key string, fallback bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("invalid %s=%q: %v", key, raw, err)
+		return fallback
+	}
+	return b
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"youtube-mini/internal/transcode"
+)
+
+const defaultWorkerAddr = ":8091"
+
+// runWorker serves transcodes for other youtube-mini nodes (listed in their
+// YTM_WORKERS) instead of the web UI: `youtube-mini worker`.
+func runWorker() {
+	addr := getenv("YTM_WORKER_ADDR", defaultWorkerAddr)
+	token := os.Getenv("YTM_WORKER_TOKEN")
+	if token == "" {
+		log.Fatal("worker: YTM_WORKER_TOKEN is required")
+	}
+	svc := transcode.New().WithScheduler(schedulerConfigFromEnv())
+	if ffmpeg := strings.TrimSpace(os.Getenv("YTM_FFMPEG")); ffmpeg != "" {
+		svc.WithCommand(ffmpeg)
+	}
+	encodingFromEnv(svc)
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      svc.WorkerHandler(token),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 0,
+	}
+	log.Printf("YouTube Mini transcode worker listening on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// remoteWorkersFromEnv returns the backend for YTM_WORKERS, a comma
+// separated list of worker base URLs, or nil when none are set.
+func remoteWorkersFromEnv() *transcode.RemoteBackend {
+	var urls []string
+	for _, part := range strings.Split(os.Getenv("YTM_WORKERS"), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			urls = append(urls, part)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	return transcode.NewRemoteBackend(urls, os.Getenv("YTM_WORKER_TOKEN"), getenvDuration("YTM_WORKER_HEALTH_INTERVAL", 0))
+}
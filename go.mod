@@ -5,6 +5,7 @@ go 1.25.1
 require (
 	github.com/bluenviron/gortsplib/v4 v4.16.2
 	github.com/pion/rtp v1.8.25
+	golang.org/x/image v0.36.0
 )
 
 require (
@@ -5,17 +5,25 @@ import (
 	"os"
 	"path/filepath"
 
+	"youtube-mini/internal/device"
 	"youtube-mini/internal/features/audio"
 	"youtube-mini/internal/features/channel"
+	"youtube-mini/internal/features/downloads"
 	"youtube-mini/internal/features/explore"
 	"youtube-mini/internal/features/featuremap"
 	"youtube-mini/internal/features/history"
+	"youtube-mini/internal/features/hls"
+	"youtube-mini/internal/features/imageseq"
 	"youtube-mini/internal/features/index"
+	"youtube-mini/internal/features/offline"
 	"youtube-mini/internal/features/playlist"
+	"youtube-mini/internal/features/podcast"
 	"youtube-mini/internal/features/proxy"
 	"youtube-mini/internal/features/queue"
+	"youtube-mini/internal/features/rtsptunnel"
 	"youtube-mini/internal/features/search"
 	"youtube-mini/internal/features/settings"
+	"youtube-mini/internal/features/storyboard"
 	"youtube-mini/internal/features/stream"
 	"youtube-mini/internal/features/style"
 	"youtube-mini/internal/features/subscriptions"
@@ -39,9 +47,13 @@ type App struct {
 // New constructs a fully wired application.
 func New(youtubeClient *youtube.Client, legacy *transcode.Service) *App {
 	registry := metrics.New()
+	devices := device.NewDetector(nil)
 	mux := http.NewServeMux()
 
 	mux.Handle("/metrics", registry.Handler())
+	if legacy != nil {
+		registerTranscodeMetrics(registry, legacy)
+	}
 	mux.Handle("/style.css", registry.Wrap("style", style.Handler()))
 
 	// Serve static assets (logo, icons). Prefer filesystem if available, otherwise embedded FS.
@@ -60,18 +72,27 @@ func New(youtubeClient *youtube.Client, legacy *transcode.Service) *App {
 	mux.Handle("/suggest", registry.Wrap("suggest", suggest.Handler(youtubeClient)))
 	mux.Handle("/theme", registry.Wrap("theme", theme.Handler()))
 	mux.Handle("/proxy", registry.Wrap("proxy", proxy.Handler(youtubeClient)))
+	mux.Handle("/storyboard/", registry.Wrap("storyboard", storyboard.Handler(youtubeClient)))
 
 	mux.Handle("/", registry.Wrap("home", index.Handler(youtubeClient)))
 	mux.Handle("/explore", registry.Wrap("explore", explore.Handler(youtubeClient)))
 	mux.Handle("/search", registry.Wrap("search", search.Handler(youtubeClient)))
-	mux.Handle("/watch", registry.Wrap("watch", watch.Handler(youtubeClient, legacy)))
+	mux.Handle("/watch", registry.Wrap("watch", watch.Handler(youtubeClient, legacy, devices)))
 	mux.Handle("/channel", registry.Wrap("channel", channel.Handler(youtubeClient)))
 	mux.Handle("/playlist", registry.Wrap("playlist", playlist.Handler()))
+	mux.Handle("/feed/podcast", registry.Wrap("podcast", podcast.Handler(youtubeClient, legacy)))
 	mux.Handle("/subscriptions", registry.Wrap("subscriptions", subscriptions.Handler(youtubeClient, watchlater.ReadSet)))
 
-	mux.Handle("/stream/ffmpeg/", registry.Wrap("stream_ffmpeg", transcoder.Handler(youtubeClient, legacy)))
-	mux.Handle("/stream/audio/", registry.Wrap("stream_audio", audio.Handler(youtubeClient)))
-	mux.Handle("/stream/", registry.Wrap("stream_direct", stream.Handler(youtubeClient)))
+	mux.Handle("/stream/ffmpeg/", registry.Wrap("stream_ffmpeg", transcoder.Handler(youtubeClient, legacy, devices)))
+	mux.Handle("/stream/hls/", registry.Wrap("stream_hls", hls.Handler(legacy)))
+	mux.Handle("/stream/audio/", registry.Wrap("stream_audio", audio.Handler(youtubeClient, legacy)))
+	if legacy != nil {
+		mux.Handle("/stream/mjpeg/", registry.Wrap("stream_mjpeg", imageseq.MJPEGHandler(legacy)))
+		mux.Handle("/stream/gif/", registry.Wrap("stream_gif", imageseq.GIFHandler(legacy)))
+		mux.Handle("/slides/", registry.Wrap("slides", imageseq.SlideshowHandler(legacy)))
+	}
+	mux.Handle("/stream/", registry.Wrap("stream_direct", stream.Handler(youtubeClient, legacy)))
+	mux.Handle("/rtsp/", registry.Wrap("rtsp_tunnel", rtsptunnel.Handler(legacy)))
 
 	mux.Handle("/queue/add", registry.Wrap("queue_add", queue.AddHandler()))
 	mux.Handle("/queue/remove", registry.Wrap("queue_remove", queue.RemoveHandler()))
@@ -88,6 +109,16 @@ func New(youtubeClient *youtube.Client, legacy *transcode.Service) *App {
 	mux.Handle("/subscriptions/add", registry.Wrap("subscriptions_add", subscriptions.AddHandler()))
 	mux.Handle("/subscriptions/remove", registry.Wrap("subscriptions_remove", subscriptions.RemoveHandler()))
 
+	mux.Handle("/downloads", registry.Wrap("downloads", downloads.Handler(legacy)))
+	mux.Handle("/downloads/add", registry.Wrap("downloads_add", downloads.AddHandler(youtubeClient, legacy)))
+	mux.Handle("/downloads/retry", registry.Wrap("downloads_retry", downloads.RetryHandler(legacy)))
+	mux.Handle("/downloads/delete", registry.Wrap("downloads_delete", downloads.DeleteHandler(legacy)))
+	mux.Handle("/downloads/file/", registry.Wrap("downloads_file", downloads.FileHandler(legacy)))
+	mux.Handle("/offline", registry.Wrap("offline", offline.Handler(youtubeClient, legacy)))
+	mux.Handle("/offline/rules/save", registry.Wrap("offline_rule_save", offline.SaveRuleHandler(youtubeClient, legacy)))
+	mux.Handle("/offline/rules/delete", registry.Wrap("offline_rule_delete", offline.DeleteRuleHandler(legacy)))
+	mux.Handle("/offline/sync", registry.Wrap("offline_sync", offline.SyncNowHandler(legacy)))
+
 	mux.Handle("/settings/autoplay", registry.Wrap("settings_autoplay", settings.AutoplayHandler()))
 
 	return &App{mux: mux, metrics: registry}
@@ -118,3 +149,21 @@ func staticPath() string {
 func (a *App) Handler() http.Handler {
 	return a.mux
 }
+
+func registerTranscodeMetrics(registry *metrics.Registry, svc *transcode.Service) {
+	gauges := map[string]func(transcode.JobStats) float64{
+		"transcode_jobs_active":   func(st transcode.JobStats) float64 { return float64(st.Active) },
+		"transcode_jobs_http":     func(st transcode.JobStats) float64 { return float64(st.HTTP) },
+		"transcode_jobs_rtsp":     func(st transcode.JobStats) float64 { return float64(st.RTSP) },
+		"transcode_jobs_hls":      func(st transcode.JobStats) float64 { return float64(st.HLS) },
+		"transcode_jobs_download": func(st transcode.JobStats) float64 { return float64(st.Download) },
+		"transcode_jobs_stalled":  func(st transcode.JobStats) float64 { return float64(st.Stalled) },
+		"transcode_jobs_started":  func(st transcode.JobStats) float64 { return float64(st.Started) },
+		"transcode_jobs_finished": func(st transcode.JobStats) float64 { return float64(st.Finished) },
+		"transcode_jobs_failed":   func(st transcode.JobStats) float64 { return float64(st.Failed) },
+		"transcode_speed_avg":     func(st transcode.JobStats) float64 { return st.Speed },
+	}
+	for name, pick := range gauges {
+		registry.Gauge(name, func() float64 { return pick(svc.JobStats()) })
+	}
+}
@@ -0,0 +1,273 @@
+package device
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"youtube-mini/internal/platform/cache"
+)
+
+// Codec families shared by UA rules, UAProf parsing and profile matching.
+const (
+	CodecH263  = "h263"
+	CodecMPEG4 = "mpeg4"
+	CodecH264  = "h264"
+	CodecAMR   = "amr"
+	CodecAAC   = "aac"
+	CodecMP3   = "mp3"
+)
+
+// Capabilities is what the server could work out about the requesting device.
+// Empty lists mean "unknown" and do not restrict profile selection.
+type Capabilities struct {
+	// Known is set once any signal identified a handset or media-capable client.
+	Known bool
+	// Legacy marks feature phones and WAP browsers that never run the watch page JS.
+	Legacy       bool
+	ScreenWidth  int
+	ScreenHeight int
+	// Types lists the audio/video MIME types the device claims to play.
+	Types       []string
+	VideoCodecs []string
+	AudioCodecs []string
+	RTSP        bool
+	HLS         bool
+	// Source names the signals that contributed, e.g. "user-agent+uaprof".
+	Source string
+}
+
+// PlaysType reports whether mime is acceptable. Without any video type
+// information every container is assumed to work.
+func (c Capabilities) PlaysType(mime string) bool {
+	mime = normalizeMIME(mime)
+	hasVideo := false
+	for _, t := range c.Types {
+		if t == mime {
+			return true
+		}
+		if strings.HasPrefix(t, "video/") {
+			hasVideo = true
+		}
+	}
+	return !hasVideo
+}
+
+func (c *Capabilities) addSource(source string) {
+	if c.Source == "" {
+		c.Source = source
+		return
+	}
+	if !strings.Contains(c.Source, source) {
+		c.Source += "+" + source
+	}
+}
+
+func (c *Capabilities) addTypes(types ...string) {
+	c.Types = appendUnique(c.Types, types...)
+}
+
+const (
+	uaprofTTL        = 24 * time.Hour
+	uaprofFailureTTL = time.Hour
+	// uaprofCacheEntries bounds the cached documents: the profile URL comes
+	// from the client, so every new one would otherwise stay forever.
+	uaprofCacheEntries = 512
+)
+
+// Detector derives Capabilities from request headers, fetching and caching
+// UAProf documents referenced by x-wap-profile/Profile headers.
+type Detector struct {
+	client   *http.Client
+	profiles *cache.LRU[*uaProfile]
+	// fetches lets concurrent requests for one profile URL share a fetch.
+	fetches cache.Flight[*uaProfile]
+}
+
+// NewDetector returns a Detector. A nil client gets a short-timeout client
+// that refuses to connect to loopback or private addresses.
+func NewDetector(client *http.Client) *Detector {
+	if client == nil {
+		client = newUAProfClient()
+	}
+	return &Detector{client: client, profiles: cache.NewLRU[*uaProfile](uaprofCacheEntries, nil)}
+}
+
+// Detect inspects r. A nil Detector still applies User-Agent and Accept rules.
+func (d *Detector) Detect(r *http.Request) Capabilities {
+	var caps Capabilities
+	ua := r.Header.Get("User-Agent")
+	// Opera Mini and similar proxies forward the handset's own UA separately.
+	for _, key := range []string{"X-OperaMini-Phone-UA", "Device-Stock-UA", "X-Device-User-Agent"} {
+		if phone := r.Header.Get(key); phone != "" {
+			ua = phone
+			break
+		}
+	}
+	applyUserAgent(&caps, ua)
+	applyScreenHeaders(&caps, r.Header)
+	applyAccept(&caps, r.Header.Get("Accept"))
+
+	if d != nil {
+		if url := uaprofURL(r.Header); url != "" {
+			if prof := d.uaprof(r, url); prof != nil {
+				prof.apply(&caps)
+			}
+		}
+	}
+	return caps
+}
+
+type uaRule struct {
+	match  []string
+	legacy bool
+	rtsp   bool
+	hls    bool
+	types  []string
+	video  []string
+	audio  []string
+	// width/height are typical screen sizes, used when nothing better is known.
+	width  int
+	height int
+}
+
+var (
+	types3GP = []string{"video/3gpp", "audio/amr"}
+	typesMP4 = []string{"video/3gpp", "video/mp4", "audio/amr", "audio/aac", "audio/mpeg"}
+
+	// uaRules are checked in order against the lower-cased User-Agent.
+	uaRules = []uaRule{
+		{match: []string{"iphone", "ipod", "ipad"}, hls: true,
+			types: []string{"video/mp4", "audio/aac", "audio/mpeg", "application/vnd.apple.mpegurl"},
+			video: []string{CodecH264, CodecMPEG4}, audio: []string{CodecAAC, CodecMP3}},
+		{match: []string{"android 1.", "android 2."}, legacy: true, rtsp: true,
+			types: typesMP4, video: []string{CodecH263, CodecMPEG4, CodecH264}, audio: []string{CodecAMR, CodecAAC, CodecMP3},
+			width: 320, height: 480},
+		{match: []string{"android"}, rtsp: true, hls: true,
+			types: append([]string{"application/vnd.apple.mpegurl"}, typesMP4...),
+			video: []string{CodecH263, CodecMPEG4, CodecH264}, audio: []string{CodecAMR, CodecAAC, CodecMP3}},
+		{match: []string{"series60", "s60", "symbianos/9"}, legacy: true, rtsp: true,
+			types: typesMP4, video: []string{CodecH263, CodecMPEG4, CodecH264}, audio: []string{CodecAMR, CodecAAC, CodecMP3},
+			width: 240, height: 320},
+		{match: []string{"series40", "nokia", "symbian"}, legacy: true, rtsp: true,
+			types: types3GP, video: []string{CodecH263}, audio: []string{CodecAMR},
+			width: 176, height: 208},
+		{match: []string{"blackberry", "iemobile", "windows ce", "palmos", "webos"}, legacy: true, rtsp: true,
+			types: typesMP4, video: []string{CodecH263, CodecMPEG4}, audio: []string{CodecAMR, CodecAAC},
+			width: 240, height: 320},
+		{match: []string{"sonyericsson", "samsung-sgh", "sec-sgh", "sgh-", "mot-", "lg-", "sie-", "sagem", "alcatel", "zte", "huawei"},
+			legacy: true, rtsp: true,
+			types: types3GP, video: []string{CodecH263, CodecMPEG4}, audio: []string{CodecAMR},
+			width: 176, height: 220},
+		{match: []string{"midp", "cldc", "j2me", "netfront", "obigo", "up.browser", "openwave", "teleca", "opera mini", "ucweb", "ucbrowser", "wap"},
+			legacy: true, rtsp: true,
+			types: types3GP, video: []string{CodecH263}, audio: []string{CodecAMR},
+			width: 128, height: 160},
+	}
+
+	screenInUA = regexp.MustCompile(`\b(\d{3,4})[x*](\d{3,4})\b`)
+)
+
+func applyUserAgent(caps *Capabilities, ua string) {
+	low := strings.ToLower(ua)
+	if low == "" {
+		return
+	}
+	for _, rule := range uaRules {
+		if !containsAny(low, rule.match) {
+			continue
+		}
+		caps.Known = true
+		caps.Legacy = rule.legacy
+		caps.RTSP = rule.rtsp
+		caps.HLS = rule.hls
+		caps.addTypes(rule.types...)
+		caps.VideoCodecs = appendUnique(caps.VideoCodecs, rule.video...)
+		caps.AudioCodecs = appendUnique(caps.AudioCodecs, rule.audio...)
+		caps.ScreenWidth, caps.ScreenHeight = rule.width, rule.height
+		caps.addSource("user-agent")
+		break
+	}
+	if m := screenInUA.FindStringSubmatch(low); m != nil {
+		setScreen(caps, m[1], m[2])
+	}
+}
+
+// applyScreenHeaders reads screen hints some gateways and browsers add.
+func applyScreenHeaders(caps *Capabilities, h http.Header) {
+	if v := h.Get("UA-Pixels"); v != "" {
+		if w, hgt, ok := strings.Cut(strings.ToLower(v), "x"); ok {
+			setScreen(caps, w, hgt)
+		}
+	}
+	if v := h.Get("X-UP-Devcap-Screenpixels"); v != "" {
+		if w, hgt, ok := strings.Cut(v, ","); ok {
+			setScreen(caps, w, hgt)
+		}
+	}
+}
+
+func setScreen(caps *Capabilities, width, height string) {
+	w, errW := strconv.Atoi(strings.TrimSpace(width))
+	h, errH := strconv.Atoi(strings.TrimSpace(height))
+	if errW != nil || errH != nil || w < 64 || h < 64 || w > 4096 || h > 4096 {
+		return
+	}
+	caps.ScreenWidth, caps.ScreenHeight = w, h
+}
+
+// applyAccept adds explicitly listed media types; wildcards carry no information.
+func applyAccept(caps *Capabilities, accept string) {
+	for _, part := range strings.Split(accept, ",") {
+		mime := normalizeMIME(part)
+		if strings.Contains(mime, "*") {
+			continue
+		}
+		if !strings.HasPrefix(mime, "video/") && !strings.HasPrefix(mime, "audio/") && mime != "application/vnd.apple.mpegurl" {
+			continue
+		}
+		caps.addTypes(mime)
+		caps.Known = true
+		caps.addSource("accept")
+		switch mime {
+		case "audio/amr":
+			caps.AudioCodecs = appendUnique(caps.AudioCodecs, CodecAMR)
+		case "application/vnd.apple.mpegurl":
+			caps.HLS = true
+		}
+	}
+}
+
+func normalizeMIME(v string) string {
+	if idx := strings.Index(v, ";"); idx >= 0 {
+		v = v[:idx]
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
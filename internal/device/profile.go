@@ -0,0 +1,107 @@
+package device
+
+import (
+	"strings"
+
+	"youtube-mini/internal/transcode"
+)
+
+// Screen assumed for legacy handsets that did not report one.
+const (
+	legacyScreenWidth  = 176
+	legacyScreenHeight = 144
+)
+
+// BestProfile picks the video profile that suits the device: among the
+// profiles whose container and codecs it can play, the largest picture that
+// fits its screen, or the smallest one when none fits. With rtsp set only
+// RTSP-capable profiles are considered, otherwise only HTTP ones. It returns
+// false when the device is unknown, leaving the registry default in place.
+func (c Capabilities) BestProfile(specs []transcode.ProfileSpec, rtsp bool) (transcode.ProfileSpec, bool) {
+	if !c.Known {
+		return transcode.ProfileSpec{}, false
+	}
+	width, height := c.ScreenWidth, c.ScreenHeight
+	if (width == 0 || height == 0) && c.Legacy {
+		width, height = legacyScreenWidth, legacyScreenHeight
+	}
+
+	var best transcode.ProfileSpec
+	bestScore := -1
+	for _, spec := range specs {
+		if spec.Hidden || spec.Video == nil {
+			continue
+		}
+		if rtsp {
+			if !spec.RTSP {
+				continue
+			}
+		} else if !spec.HTTP || !c.PlaysType(spec.ContentType) {
+			continue
+		}
+		if !supports(c.VideoCodecs, videoFamily(spec.Video.Codec)) {
+			continue
+		}
+		if spec.Audio != nil && !supports(c.AudioCodecs, audioFamily(spec.Audio.Codec)) {
+			continue
+		}
+
+		area := spec.Video.Width * spec.Video.Height
+		score := 1 << 24
+		switch {
+		case width == 0 || height == 0:
+			score += area
+		case fits(spec.Video.Width, spec.Video.Height, width, height):
+			score += 1<<23 + area
+		default:
+			score -= area
+		}
+		if score > bestScore {
+			best, bestScore = spec, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// fits allows either orientation since handsets rotate landscape video.
+func fits(w, h, screenW, screenH int) bool {
+	return (w <= screenW && h <= screenH) || (w <= screenH && h <= screenW)
+}
+
+func supports(known []string, family string) bool {
+	if len(known) == 0 || family == "" {
+		return true
+	}
+	for _, k := range known {
+		if k == family {
+			return true
+		}
+	}
+	return false
+}
+
+// videoFamily maps an ffmpeg encoder name to a codec family.
+func videoFamily(codec string) string {
+	switch codec = strings.ToLower(codec); {
+	case strings.HasPrefix(codec, "h263"):
+		return CodecH263
+	case codec == "mpeg4" || codec == "libxvid":
+		return CodecMPEG4
+	case strings.Contains(codec, "264"):
+		return CodecH264
+	}
+	return ""
+}
+
+// audioFamily maps an ffmpeg encoder name to a codec family.
+func audioFamily(codec string) string {
+	switch codec = strings.ToLower(codec); {
+	case strings.Contains(codec, "amr"):
+		return CodecAMR
+	case strings.Contains(codec, "aac"):
+		return CodecAAC
+	case strings.Contains(codec, "mp3") || strings.Contains(codec, "lame"):
+		return CodecMP3
+	}
+	return ""
+}
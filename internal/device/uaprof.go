@@ -0,0 +1,256 @@
+package device
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	uaprofTimeout  = 3 * time.Second
+	uaprofMaxBytes = 512 << 10
+)
+
+// uaProfile holds the parts of a UAProf (CC/PP RDF) document we use.
+type uaProfile struct {
+	screenWidth  int
+	screenHeight int
+	accept       []string
+	streaming    []string
+}
+
+// uaprofURL returns the first profile URL from x-wap-profile, Profile,
+// Wap-Profile or an OPT-namespaced "<nn>-Profile" header.
+func uaprofURL(h http.Header) string {
+	for key, values := range h {
+		if !isProfileHeader(key) {
+			continue
+		}
+		for _, value := range values {
+			for _, part := range strings.Split(value, ",") {
+				part = strings.Trim(strings.TrimSpace(part), `"'`)
+				if strings.HasPrefix(part, "http://") || strings.HasPrefix(part, "https://") {
+					return part
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func isProfileHeader(key string) bool {
+	switch key {
+	case "X-Wap-Profile", "Profile", "Wap-Profile":
+		return true
+	}
+	prefix, ok := strings.CutSuffix(key, "-Profile")
+	if !ok || prefix == "" {
+		return false
+	}
+	for _, r := range prefix {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// uaprof returns the parsed profile at rawURL, fetching it on first use;
+// concurrent requests for the same URL share one fetch. Failures are cached
+// too so a dead profile server costs one timeout per hour.
+func (d *Detector) uaprof(r *http.Request, rawURL string) *uaProfile {
+	if prof, ok := d.profiles.Get(rawURL); ok {
+		return prof
+	}
+	prof, _ := d.fetches.Do(rawURL, func() (*uaProfile, error) {
+		if prof, ok := d.profiles.Get(rawURL); ok {
+			return prof, nil
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), uaprofTimeout)
+		defer cancel()
+		prof, err := d.fetchUAProf(ctx, rawURL)
+		if err != nil {
+			log.Printf("[device] uaprof %s: %v", rawURL, err)
+			d.profiles.Set(rawURL, nil, uaprofFailureTTL)
+			return nil, nil
+		}
+		d.profiles.Set(rawURL, prof, uaprofTTL)
+		return prof, nil
+	})
+	return prof
+}
+
+func (d *Detector) fetchUAProf(ctx context.Context, rawURL string) (*uaProfile, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("invalid profile url")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdf+xml, application/xml, text/xml")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return parseUAProf(io.LimitReader(resp.Body, uaprofMaxBytes))
+}
+
+// parseUAProf walks the RDF loosely: vocabularies and namespaces differ
+// between vendors, so only local element names are matched.
+func parseUAProf(r io.Reader) (*uaProfile, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+
+	prof := &uaProfile{}
+	var stack []string
+	inside := func(name string) bool {
+		for _, el := range stack {
+			if el == name {
+				return true
+			}
+		}
+		return false
+	}
+	sawElement := false
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if !sawElement {
+				return nil, err
+			}
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			sawElement = true
+			stack = append(stack, t.Name.Local)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" || len(stack) == 0 {
+				continue
+			}
+			switch {
+			case stack[len(stack)-1] == "ScreenSize" && prof.screenWidth == 0:
+				if w, h, ok := strings.Cut(strings.ToLower(text), "x"); ok {
+					var caps Capabilities
+					setScreen(&caps, w, h)
+					prof.screenWidth, prof.screenHeight = caps.ScreenWidth, caps.ScreenHeight
+				}
+			case stack[len(stack)-1] != "li":
+				// Bags and sequences keep their values in rdf:li items.
+			case inside("StreamingAccept") || inside("StreamingAccept-Subset"):
+				prof.streaming = appendUnique(prof.streaming, normalizeMIME(text))
+			case inside("CcppAccept"):
+				prof.accept = appendUnique(prof.accept, normalizeMIME(text))
+			}
+		}
+	}
+	if !sawElement {
+		return nil, errors.New("empty profile")
+	}
+	return prof, nil
+}
+
+// apply merges the profile into caps; UAProf data wins over UA guesses.
+func (p *uaProfile) apply(caps *Capabilities) {
+	if !caps.Known {
+		// Only handsets publish UAProf; without a recognised UA assume an old one.
+		caps.Legacy = true
+	}
+	caps.Known = true
+	caps.addSource("uaprof")
+	if p.screenWidth > 0 {
+		caps.ScreenWidth, caps.ScreenHeight = p.screenWidth, p.screenHeight
+	}
+
+	var video, audio []string
+	for _, mime := range p.accept {
+		if strings.HasPrefix(mime, "video/") || strings.HasPrefix(mime, "audio/") {
+			caps.addTypes(mime)
+		}
+		switch mime {
+		case "audio/amr", "audio/3gpp":
+			audio = appendUnique(audio, CodecAMR)
+		case "audio/aac", "audio/mp4", "audio/x-aac", "audio/m4a":
+			audio = appendUnique(audio, CodecAAC)
+		case "audio/mpeg", "audio/mp3", "audio/x-mp3":
+			audio = appendUnique(audio, CodecMP3)
+		}
+	}
+	if len(p.streaming) > 0 {
+		caps.RTSP = true
+	}
+	for _, mime := range p.streaming {
+		// PSS lists RTP payload formats rather than file types.
+		switch mime {
+		case "video/h263-1998", "video/h263-2000", "video/h263":
+			video = appendUnique(video, CodecH263)
+		case "video/mp4v-es":
+			video = appendUnique(video, CodecMPEG4)
+		case "video/h264":
+			video = appendUnique(video, CodecH264)
+		case "audio/amr", "audio/amr-wb":
+			audio = appendUnique(audio, CodecAMR)
+		case "audio/mp4a-latm", "audio/mpeg4-generic":
+			audio = appendUnique(audio, CodecAAC)
+		case "audio/mpa":
+			audio = appendUnique(audio, CodecMP3)
+		}
+	}
+	if len(video) > 0 {
+		caps.VideoCodecs = video
+	}
+	if len(audio) > 0 {
+		caps.AudioCodecs = audio
+	}
+}
+
+// newUAProfClient fetches profiles from the public internet only; profile
+// URLs come straight from request headers.
+func newUAProfClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: uaprofTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return fmt.Errorf("refusing to fetch profile from %s", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: uaprofTimeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ResponseHeaderTimeout: uaprofTimeout,
+			MaxIdleConns:          4,
+		},
+	}
+}
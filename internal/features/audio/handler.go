@@ -1,95 +1,205 @@
 package audio
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
 
+	"youtube-mini/internal/transcode"
 	"youtube-mini/internal/youtube"
 )
 
-// Handler streams audio-only formats with range support.
-func Handler(client *youtube.Client) http.HandlerFunc {
+// Handler serves /stream/audio/<id>.<ext>. With a transcoder, ?fmt= (or the
+// extension) picks an audio-only profile such as mp3, aac, amr or ogg and
+// ?kbps= one of its bitrates, defaulting to mp3; ?fmt=orig proxies the best
+// YouTube audio format untouched, with range support and no CPU cost. HEAD
+// on a transcode is answered from the profile (or the disk cache) without
+// starting ffmpeg.
+func Handler(client *youtube.Client, transcoder *transcode.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet && r.Method != http.MethodHead {
 			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
 			return
 		}
 
-		id := strings.TrimPrefix(r.URL.Path, "/stream/audio/")
-		id = strings.TrimSuffix(id, ".mp3")
+		file := strings.TrimPrefix(r.URL.Path, "/stream/audio/")
+		ext := path.Ext(file)
+		id := strings.TrimSuffix(file, ext)
 		if id == "" {
 			http.Error(w, "missing video id", http.StatusBadRequest)
 			return
 		}
 
-		video, err := client.GetVideo(r.Context(), id)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		q := r.URL.Query()
+		format := strings.ToLower(strings.TrimSpace(q.Get("fmt")))
+		if format == "" {
+			format = strings.TrimPrefix(ext, ".")
 		}
-
-		format := selectBestAudio(video.Audio)
-		if format.URL == "" {
-			http.Error(w, "audio stream unavailable", http.StatusNotFound)
+		if format == "" && transcoder != nil {
+			format = "mp3"
+		}
+		if transcoder == nil || IsPassthrough(format) {
+			proxyAudio(w, r, client, id)
 			return
 		}
 
-		req, err := http.NewRequestWithContext(r.Context(), r.Method, format.URL, nil)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		profile, ok := resolveProfile(transcoder.Profiles(), format, q.Get("kbps"))
+		if !ok {
+			http.Error(w, "unknown audio format", http.StatusBadRequest)
 			return
 		}
-		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)")
-		req.Header.Set("Referer", "https://www.youtube.com/")
-		if rng := r.Header.Get("Range"); rng != "" {
-			req.Header.Set("Range", rng)
+		start := startFromQuery(r)
+		if start == 0 && transcoder.ServeCached(w, r, id, profile) {
+			return
 		}
-		if ifRange := r.Header.Get("If-Range"); ifRange != "" {
-			req.Header.Set("If-Range", ifRange)
+		if r.Method == http.MethodHead {
+			// Podcast clients size enclosures with HEAD; an encode here
+			// would hold a slot and have its body thrown away.
+			if spec, ok := transcoder.Profiles().Lookup(profile); ok {
+				w.Header().Set("Content-Type", spec.ContentType)
+			}
+			w.WriteHeader(http.StatusOK)
+			return
 		}
 
-		resp, err := client.HTTPClient().Do(req)
+		video, err := client.GetVideo(r.Context(), id)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-			w.WriteHeader(resp.StatusCode)
-			_, _ = io.Copy(w, resp.Body)
+		sources := transcode.VideoSources(video)
+		if len(sources) == 0 {
+			http.Error(w, "audio stream unavailable", http.StatusNotFound)
 			return
 		}
 
-		contentType := format.Mime
-		if idx := strings.Index(contentType, ";"); idx > 0 {
-			contentType = contentType[:idx]
-		}
-		if contentType == "" {
-			contentType = "audio/mpeg"
-		}
-
-		for _, key := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Cache-Control", "ETag", "Last-Modified", "Expires"} {
-			if values, ok := resp.Header[key]; ok {
-				w.Header()[key] = values
+		ctx := transcode.WithClient(r.Context(), r.RemoteAddr)
+		if err := transcoder.Stream(ctx, w, sources, id, profile, start); err != nil {
+			var busy *transcode.BusyError
+			if errors.As(err, &busy) {
+				w.Header().Set("Retry-After", busy.RetryAfterSeconds())
+				http.Error(w, "transcoder busy, try again shortly", http.StatusServiceUnavailable)
+				return
 			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		if w.Header().Get("Content-Type") == "" {
-			w.Header().Set("Content-Type", contentType)
-		}
-		if w.Header().Get("Accept-Ranges") == "" {
-			w.Header().Set("Accept-Ranges", "bytes")
-		}
+	}
+}
+
+// IsPassthrough reports whether format asks for the original YouTube audio.
+func IsPassthrough(format string) bool {
+	switch format {
+	case "orig", "original", "passthrough", "m4a", "webm":
+		return true
+	}
+	return false
+}
+
+// ProfileName maps a ?fmt= value to its audio-only profile.
+func ProfileName(format string) transcode.Profile {
+	return transcode.Profile("audio-" + format)
+}
 
+func resolveProfile(profiles *transcode.ProfileRegistry, format, kbpsRaw string) (transcode.Profile, bool) {
+	base := ProfileName(format)
+	spec, ok := profiles.Lookup(base)
+	if !ok || !spec.AudioOnly() || !spec.HTTP {
+		return "", false
+	}
+	kbps, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(kbpsRaw)), "k"), 64)
+	if err != nil || kbps <= 0 {
+		return base, true
+	}
+	variant, ok := spec.NearestAudioVariant(kbps)
+	if !ok {
+		return base, true
+	}
+	return transcode.AudioVariantProfile(base, variant.Bitrate), true
+}
+
+func startFromQuery(r *http.Request) float64 {
+	q := r.URL.Query()
+	raw := strings.TrimSpace(q.Get("start"))
+	if raw == "" {
+		raw = strings.TrimSpace(q.Get("t"))
+	}
+	if secs, ok := transcode.ParseTimeSpec(raw); ok {
+		return secs
+	}
+	return 0
+}
+
+// proxyAudio relays the best audio-only format from YouTube as-is.
+func proxyAudio(w http.ResponseWriter, r *http.Request, client *youtube.Client, id string) {
+	video, err := client.GetVideo(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := selectBestAudio(video.Audio)
+	if format.URL == "" {
+		http.Error(w, "audio stream unavailable", http.StatusNotFound)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, format.URL, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)")
+	req.Header.Set("Referer", "https://www.youtube.com/")
+	if rng := r.Header.Get("Range"); rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+
+	resp, err := client.HTTPClient().Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 		w.WriteHeader(resp.StatusCode)
-		if r.Method == http.MethodHead {
-			return
-		}
 		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+
+	contentType := format.Mime
+	if idx := strings.Index(contentType, ";"); idx > 0 {
+		contentType = contentType[:idx]
+	}
+	if contentType == "" {
+		contentType = "audio/mpeg"
+	}
+
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Cache-Control", "ETag", "Last-Modified", "Expires"} {
+		if values, ok := resp.Header[key]; ok {
+			w.Header()[key] = values
+		}
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if w.Header().Get("Accept-Ranges") == "" {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	if r.Method == http.MethodHead {
+		return
 	}
+	_, _ = io.Copy(w, resp.Body)
 }
 
 func selectBestAudio(formats []youtube.Format) youtube.Format {
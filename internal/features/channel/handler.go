@@ -106,6 +106,7 @@ func Handler(client *youtube.Client) http.HandlerFunc {
 			IsSubscribed:   subscribedSet[channelID],
 			SubscribeURL:   subscribeURL,
 			UnsubscribeURL: unsubscribeURL,
+			PodcastURL:     "/feed/podcast?channel=" + url.QueryEscape(channelID),
 			Tabs:           tabs,
 		}
 
@@ -0,0 +1,211 @@
+package downloads
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"youtube-mini/internal/features/theme"
+	"youtube-mini/internal/transcode"
+	"youtube-mini/internal/ui"
+	"youtube-mini/internal/youtube"
+)
+
+// refreshSeconds is how often the page reloads while downloads are active.
+const refreshSeconds = 5
+
+// crossOrigin turns away actions posted from other sites' pages.
+var crossOrigin http.CrossOriginProtection
+
+// Handler renders the download library.
+func Handler(transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := ui.DownloadsPageData{
+			Theme:       theme.FromRequest(r),
+			CurrentPath: r.URL.RequestURI(),
+		}
+		if m := library(transcoder); m == nil {
+			data.Disabled = "Downloads are turned off on this server (set YTM_DOWNLOADS_DIR)."
+		} else {
+			for _, d := range m.List() {
+				if d.Status == transcode.DownloadQueued || d.Status == transcode.DownloadRunning {
+					data.Refresh = refreshSeconds
+				}
+				data.Entries = append(data.Entries, entry(d, transcoder.Profiles()))
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte(ui.RenderDownloadsPage(data)))
+	}
+}
+
+// AddHandler queues v in itag or profile and shows the library. Like the
+// other actions it only accepts a POST.
+func AddHandler(client *youtube.Client, transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := library(transcoder)
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !PostOnly(w, r) {
+			return
+		}
+		req := transcode.DownloadRequest{
+			VideoID: strings.TrimSpace(r.FormValue("v")),
+			Itag:    strings.TrimSpace(r.FormValue("itag")),
+			Profile: transcode.Profile(strings.TrimSpace(r.FormValue("profile"))),
+		}
+		req.Title = req.VideoID
+		if video, err := client.GetVideo(r.Context(), req.VideoID); err == nil {
+			req.Title = video.Title
+			req.Duration, _ = strconv.ParseFloat(video.LengthSeconds, 64)
+		}
+		if _, err := m.Add(req); err != nil {
+			refuse(w, err)
+			return
+		}
+		http.Redirect(w, r, "/downloads", http.StatusFound)
+	}
+}
+
+// RetryHandler requeues the failed download id.
+func RetryHandler(transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := library(transcoder)
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !PostOnly(w, r) {
+			return
+		}
+		if err := m.Retry(r.FormValue("id")); err != nil {
+			refuse(w, err)
+			return
+		}
+		http.Redirect(w, r, "/downloads", http.StatusFound)
+	}
+}
+
+// DeleteHandler removes the download id and its file.
+func DeleteHandler(transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := library(transcoder)
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !PostOnly(w, r) {
+			return
+		}
+		if err := m.Delete(r.FormValue("id")); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/downloads", http.StatusFound)
+	}
+}
+
+// FileHandler serves finished downloads below /downloads/file/.
+func FileHandler(transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := library(transcoder)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/downloads/file/")
+		if m == nil || !m.Serve(w, r, id) {
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// PostOnly admits same-site POSTs; the actions change the library, so a
+// link or a form on another site must not trigger them. The offline sync
+// actions share it.
+func PostOnly(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := crossOrigin.Check(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// refuse reports why an add or retry was turned down.
+func refuse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, transcode.ErrDownloadQuota):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case errors.Is(err, transcode.ErrDownloadQueueFull):
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func library(transcoder *transcode.Service) *transcode.DownloadManager {
+	if transcoder == nil {
+		return nil
+	}
+	return transcoder.Downloads()
+}
+
+func entry(d transcode.Download, profiles *transcode.ProfileRegistry) ui.DownloadEntry {
+	e := ui.DownloadEntry{
+		Title:     d.Title,
+		WatchURL:  "/watch?v=" + url.QueryEscape(d.VideoID),
+		Status:    string(d.Status),
+		Error:     d.Error,
+		DeleteURL: "/downloads/delete?id=" + url.QueryEscape(d.ID),
+	}
+	switch spec, ok := profiles.Lookup(d.Profile); {
+	case d.Itag != "":
+		e.Format = "Original format " + d.Itag
+	case ok:
+		e.Format = spec.DisplayLabel(false)
+	default:
+		e.Format = string(d.Profile)
+	}
+
+	var parts []string
+	if pct := d.Percent(); pct >= 0 && d.Status != transcode.DownloadDone {
+		parts = append(parts, fmt.Sprintf("%d%%", pct))
+	}
+	if d.Bytes > 0 {
+		parts = append(parts, FormatBytes(d.Bytes))
+	}
+	e.Progress = strings.Join(parts, ", ")
+
+	switch d.Status {
+	case transcode.DownloadDone:
+		e.FileURL = "/downloads/file/" + url.PathEscape(d.ID)
+	case transcode.DownloadFailed:
+		e.RetryURL = "/downloads/retry?id=" + url.QueryEscape(d.ID)
+	}
+	return e
+}
+
+// FormatBytes renders a file size for people, e.g. "3.1 MB".
+func FormatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.0f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
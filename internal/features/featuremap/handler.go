@@ -34,6 +34,7 @@ var catalogue = []category{
 		Features: []feature{
 			{"Video playback", "Done", "Retro watch page with MP4 proxy and 3GP transcoding."},
 			{"Audio-only mode", "Planned", "Low bandwidth audio streaming profile."},
+			{"Podcast feeds", "Done", "RSS with audio enclosures for channels and playlists."},
 			{"Captions and subtitles", "Planned", "Toggleable CC tracks."},
 			{"Related videos", "Planned", "Up-next queue on the watch page."},
 			{"Autoplay toggle", "Planned", "Device-level autoplay preference."},
@@ -57,7 +58,8 @@ var catalogue = []category{
 			{"Watch history", "Planned", "Local-first activity log."},
 			{"Watch later", "Planned", "Pocket queue saved across devices."},
 			{"Playlist management", "Deferred", "Write operations require authentication."},
-			{"Downloads", "Planned", "Offline caching with quota guardrails."},
+			{"Downloads", "Done", "Background downloads to a local library with resume."},
+			{"Offline subscription sync", "Done", "Nightly downloads of new uploads with retention rules."},
 			{"Subscriptions highlights", "Planned", "Recent uploads from followed channels."},
 		},
 	},
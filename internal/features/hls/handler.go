@@ -0,0 +1,27 @@
+package hls
+
+import (
+	"net/http"
+	"strings"
+
+	"youtube-mini/internal/transcode"
+)
+
+// Handler serves HLS playlists and MPEG-TS segments below /stream/hls/<id>/.
+func Handler(svc *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/stream/hls/")
+		id, rest, _ := strings.Cut(path, "/")
+		id = strings.TrimSpace(id)
+		if id == "" {
+			http.Error(w, "missing video id", http.StatusBadRequest)
+			return
+		}
+		svc.ServeHLS(w, r, id, rest)
+	}
+}
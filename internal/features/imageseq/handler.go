@@ -0,0 +1,131 @@
+package imageseq
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"youtube-mini/internal/transcode"
+	"youtube-mini/internal/ui"
+)
+
+// MJPEGHandler serves /stream/mjpeg/<id> as a multipart/x-mixed-replace stream.
+func MJPEGHandler(svc *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := videoID(w, r, "/stream/mjpeg/", ".mjpg")
+		if !ok {
+			return
+		}
+		svc.ServeMJPEG(w, r, id, svc.ImageRequestFromQuery(transcode.ImageMJPEG, r.URL.Query()))
+	}
+}
+
+// GIFHandler serves /stream/gif/<id>.gif as a short animated GIF.
+func GIFHandler(svc *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := videoID(w, r, "/stream/gif/", ".gif")
+		if !ok {
+			return
+		}
+		svc.ServeGIF(w, r, id, svc.ImageRequestFromQuery(transcode.ImageGIF, r.URL.Query()))
+	}
+}
+
+// SlideshowHandler serves the paged XHTML slideshow at /slides/<id>?page=N and
+// its frames at /slides/<id>/<n>.jpg.
+func SlideshowHandler(svc *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+			return
+		}
+		rest := strings.TrimPrefix(r.URL.Path, "/slides/")
+		id, frame, _ := strings.Cut(rest, "/")
+		id = strings.TrimSpace(id)
+		if id == "" {
+			http.Error(w, "missing video id", http.StatusBadRequest)
+			return
+		}
+		req := svc.ImageRequestFromQuery(transcode.ImageSlideshow, r.URL.Query())
+
+		if frame != "" {
+			n, err := strconv.Atoi(strings.TrimSuffix(frame, ".jpg"))
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			svc.ServeSlideFrame(w, r, id, req, n)
+			return
+		}
+
+		show, err := svc.Slideshow(transcode.WithClient(r.Context(), r.RemoteAddr), id, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		page = max(1, min(page, show.Frames))
+		auto := r.URL.Query().Get("auto") == "1"
+
+		base := req.Query()
+		pageURL := func(n int, auto bool) string {
+			q := url.Values{}
+			for k, v := range base {
+				q[k] = v
+			}
+			q.Set("page", strconv.Itoa(n))
+			if auto {
+				q.Set("auto", "1")
+			}
+			return "/slides/" + url.PathEscape(id) + "?" + q.Encode()
+		}
+
+		seconds := int(show.FrameTime(page))
+		data := ui.SlideshowPageData{
+			Title:    "Slideshow",
+			FrameURL: fmt.Sprintf("/slides/%s/%d.jpg?%s", url.PathEscape(id), page, base.Encode()),
+			Width:    req.Width,
+			Page:     page,
+			Pages:    show.Frames,
+			Seconds:  seconds,
+			AutoURL:  pageURL(page, true),
+			StopURL:  pageURL(page, false),
+			WatchURL: fmt.Sprintf("/watch?v=%s&t=%d", url.QueryEscape(id), seconds),
+			AudioLinks: []ui.Link{
+				{Label: "AMR", URL: fmt.Sprintf("/stream/audio/%s.amr?start=%d", url.PathEscape(id), seconds)},
+				{Label: "MP3", URL: fmt.Sprintf("/stream/audio/%s.mp3?kbps=32&start=%d", url.PathEscape(id), seconds)},
+			},
+		}
+		if page > 1 {
+			data.PrevURL = pageURL(page-1, false)
+		}
+		if page < show.Frames {
+			data.NextURL = pageURL(page+1, auto)
+		}
+		if auto {
+			data.Refresh = max(1, int(req.Interval()))
+		}
+
+		contentType := "application/xhtml+xml"
+		if strings.Contains(r.Header.Get("Accept"), "application/vnd.wap.xhtml+xml") {
+			contentType = "application/vnd.wap.xhtml+xml"
+		}
+		w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+		_, _ = w.Write([]byte(ui.RenderSlideshowPage(data)))
+	}
+}
+
+func videoID(w http.ResponseWriter, r *http.Request, prefix, ext string) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), ext)
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "missing video id", http.StatusBadRequest)
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,238 @@
+package offline
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"youtube-mini/internal/features/downloads"
+	"youtube-mini/internal/features/subscriptions"
+	"youtube-mini/internal/features/theme"
+	"youtube-mini/internal/transcode"
+	"youtube-mini/internal/ui"
+	"youtube-mini/internal/youtube"
+)
+
+// Handler renders the synced videos and the auto-download rules.
+func Handler(client *youtube.Client, transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := ui.OfflinePageData{
+			Theme:       theme.FromRequest(r),
+			CurrentPath: r.URL.RequestURI(),
+		}
+		if y := syncer(transcoder); y == nil {
+			data.Disabled = "Offline sync is turned off on this server (set YTM_DOWNLOADS_DIR)."
+		} else {
+			rules := y.Rules()
+			names := make(map[string]string, len(rules))
+			for _, rule := range rules {
+				names[rule.ChannelID] = ruleChannel(rule)
+				data.Rules = append(data.Rules, ruleRow(rule, transcoder.Profiles()))
+			}
+			data.Links = make(map[string]string)
+			for _, d := range y.Offline() {
+				data.Items = append(data.Items, item(d, names[d.ChannelID], transcoder.Profiles()))
+				data.Links[d.VideoID] = "/downloads/file/" + url.PathEscape(d.ID) + "?play=1"
+			}
+			data.Status = status(y.Status())
+			data.SyncNowURL = "/offline/sync"
+			data.Profiles = profileOptions(transcoder.Profiles())
+			for _, id := range subscriptions.Read(r) {
+				label := id
+				if info, _, err := client.Channel(r.Context(), id); err == nil && info.Title != "" {
+					label = info.Title
+				}
+				data.Channels = append(data.Channels, ui.SyncOption{Value: id, Label: label})
+			}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte(ui.RenderOfflinePage(data)))
+	}
+}
+
+// SaveRuleHandler adds or replaces the rule for channel from the form posted
+// by the offline page. max is in minutes; empty limits mean none.
+func SaveRuleHandler(client *youtube.Client, transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		y := syncer(transcoder)
+		if y == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !downloads.PostOnly(w, r) {
+			return
+		}
+		rule := transcode.SyncRule{
+			ChannelID: strings.TrimSpace(r.FormValue("channel")),
+			Profile:   transcode.Profile(strings.TrimSpace(r.FormValue("profile"))),
+		}
+		maxMinutes, err1 := formInt(r.FormValue("max"))
+		keepLast, err2 := formInt(r.FormValue("keep"))
+		keepDays, err3 := formInt(r.FormValue("days"))
+		if err1 != nil || err2 != nil || err3 != nil {
+			http.Error(w, "limits must be whole numbers", http.StatusBadRequest)
+			return
+		}
+		rule.MaxDuration = float64(maxMinutes * 60)
+		rule.KeepLast, rule.KeepDays = keepLast, keepDays
+		if info, _, err := client.Channel(r.Context(), rule.ChannelID); err == nil {
+			rule.Channel = info.Title
+		}
+		if err := y.SetRule(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/offline", http.StatusFound)
+	}
+}
+
+// DeleteRuleHandler stops syncing the posted channel.
+func DeleteRuleHandler(transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		y := syncer(transcoder)
+		if y == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !downloads.PostOnly(w, r) {
+			return
+		}
+		y.RemoveRule(r.FormValue("channel"))
+		http.Redirect(w, r, "/offline", http.StatusFound)
+	}
+}
+
+// SyncNowHandler starts a sync run in the background.
+func SyncNowHandler(transcoder *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		y := syncer(transcoder)
+		if y == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !downloads.PostOnly(w, r) {
+			return
+		}
+		y.RunNow()
+		http.Redirect(w, r, "/downloads", http.StatusFound)
+	}
+}
+
+func syncer(transcoder *transcode.Service) *transcode.Syncer {
+	if transcoder == nil {
+		return nil
+	}
+	return transcoder.Sync()
+}
+
+func formInt(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(raw)
+}
+
+func ruleChannel(rule transcode.SyncRule) string {
+	if rule.Channel != "" {
+		return rule.Channel
+	}
+	return rule.ChannelID
+}
+
+func ruleRow(rule transcode.SyncRule, profiles *transcode.ProfileRegistry) ui.SyncRuleRow {
+	parts := []string{profileLabel(rule.Profile, profiles)}
+	if rule.MaxDuration > 0 {
+		parts = append(parts, fmt.Sprintf("up to %d min", int(rule.MaxDuration/60)))
+	}
+	if rule.KeepLast > 0 {
+		parts = append(parts, fmt.Sprintf("keep last %d", rule.KeepLast))
+	}
+	if rule.KeepDays > 0 {
+		parts = append(parts, fmt.Sprintf("keep %d days", rule.KeepDays))
+	}
+	return ui.SyncRuleRow{
+		Channel:    ruleChannel(rule),
+		ChannelURL: "/channel?id=" + url.QueryEscape(rule.ChannelID),
+		Summary:    strings.Join(parts, ", "),
+		DeleteURL:  "/offline/rules/delete?channel=" + url.QueryEscape(rule.ChannelID),
+	}
+}
+
+func item(d transcode.Download, channel string, profiles *transcode.ProfileRegistry) youtube.FeedItem {
+	meta := []string{profileLabel(d.Profile, profiles), downloads.FormatBytes(d.Bytes)}
+	meta = append(meta, "synced "+d.Created.Format("02 Jan"))
+	return youtube.FeedItem{
+		ID:        d.VideoID,
+		Title:     d.Title,
+		Channel:   channel,
+		ChannelID: d.ChannelID,
+		Thumbnail: fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", d.VideoID),
+		Duration:  clock(d.Duration),
+		Meta:      strings.Join(meta, " · "),
+	}
+}
+
+func profileLabel(name transcode.Profile, profiles *transcode.ProfileRegistry) string {
+	if spec, ok := profiles.Lookup(name); ok {
+		return spec.DisplayLabel(false)
+	}
+	return string(name)
+}
+
+// profileOptions offers the retro profile first, then the audio-only ones
+// and the remaining HTTP profiles.
+func profileOptions(profiles *transcode.ProfileRegistry) []ui.SyncOption {
+	var retro, audio, other []ui.SyncOption
+	for _, spec := range profiles.All() {
+		if !spec.HTTP {
+			continue
+		}
+		opt := ui.SyncOption{Value: string(spec.Name), Label: spec.DisplayLabel(false)}
+		switch {
+		case spec.Name == transcode.ProfileRetro:
+			retro = append(retro, opt)
+		case spec.AudioOnly():
+			opt.Label = "Audio " + opt.Label
+			audio = append(audio, opt)
+		default:
+			other = append(other, opt)
+		}
+	}
+	return append(append(retro, audio...), other...)
+}
+
+func status(st transcode.SyncStatus) string {
+	var parts []string
+	if st.LastRun.IsZero() {
+		parts = append(parts, "Not synced yet")
+	} else {
+		parts = append(parts, fmt.Sprintf("Last sync %s (%d new)", st.LastRun.Format("02 Jan 15:04"), st.Queued))
+	}
+	parts = append(parts, "next "+st.NextRun.Format("02 Jan 15:04"))
+	used := downloads.FormatBytes(st.Bytes) + " used"
+	if st.QuotaBytes > 0 {
+		used = downloads.FormatBytes(st.Bytes) + " of " + downloads.FormatBytes(st.QuotaBytes) + " used"
+	}
+	parts = append(parts, used)
+	if st.LastError != "" {
+		parts = append(parts, "errors: "+st.LastError)
+	}
+	return strings.Join(parts, " · ")
+}
+
+func clock(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	d := time.Duration(seconds) * time.Second
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
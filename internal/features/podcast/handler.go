@@ -0,0 +1,326 @@
+package podcast
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"youtube-mini/internal/features/audio"
+	"youtube-mini/internal/platform/cache"
+	"youtube-mini/internal/transcode"
+	"youtube-mini/internal/ui"
+	"youtube-mini/internal/youtube"
+)
+
+const (
+	// feedTTL is how long a built feed is served before it is rebuilt.
+	feedTTL = 30 * time.Minute
+	// maxFeeds bounds the cached feeds; channel and list ids come from clients.
+	maxFeeds = 256
+	// buildTimeout bounds one feed build, which no single request owns.
+	buildTimeout = time.Minute
+	// maxEpisodes bounds the GetVideo calls behind one feed.
+	maxEpisodes = 20
+	// origKbps estimates the size of untouched YouTube audio.
+	origKbps = 128
+)
+
+// feed is a built show with instance-relative URLs, shared by every host
+// name and audio format it is served with.
+type feed struct {
+	show  channel
+	built time.Time
+}
+
+// enclosureFormat is the audio the episodes point at.
+type enclosureFormat struct {
+	ext         string
+	contentType string
+	kbps        float64
+	// query is appended to the /stream/audio/ URL, e.g. "?kbps=32".
+	query string
+}
+
+// Handler serves /feed/podcast?channel=<id> or ?list=<id> as RSS 2.0 with
+// iTunes tags. Episodes enclose /stream/audio/ in ?fmt= (mp3 by default,
+// orig for the untouched YouTube audio) at ?kbps=. Feeds are cached per
+// channel or playlist, concurrent builds of one feed are collapsed, and
+// conditional GETs are answered with 304.
+func Handler(client *youtube.Client, transcoder *transcode.Service) http.HandlerFunc {
+	feeds := cache.NewLRU[feed](maxFeeds, nil)
+	var builds cache.Flight[feed]
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		channelID := strings.TrimSpace(q.Get("channel"))
+		listID := strings.TrimSpace(q.Get("list"))
+		if (channelID == "") == (listID == "") || !validID(channelID+listID) {
+			http.Error(w, "pass either channel or list", http.StatusBadRequest)
+			return
+		}
+		format, err := chooseFormat(transcoder, q.Get("fmt"), q.Get("kbps"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key := "channel:" + channelID
+		if listID != "" {
+			key = "list:" + listID
+		}
+		f, ok := feeds.Get(key)
+		if !ok {
+			f, err = builds.Do(key, func() (feed, error) {
+				if f, ok := feeds.Get(key); ok {
+					return f, nil
+				}
+				// The build outlives a client that hangs up; others may wait on it.
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), buildTimeout)
+				defer cancel()
+				f, err := build(ctx, client, channelID, listID)
+				if err == nil {
+					feeds.Set(key, f, feedTTL)
+				}
+				return f, err
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+
+		body, err := render(f, format, baseURL(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedTTL.Seconds())))
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha1.Sum(body)))
+		http.ServeContent(w, r, "", f.built, bytes.NewReader(body))
+	}
+}
+
+// validID accepts channel and playlist ids: letters, digits, '-' and '_'.
+func validID(id string) bool {
+	if len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func chooseFormat(transcoder *transcode.Service, rawFormat, rawKbps string) (enclosureFormat, error) {
+	format := strings.ToLower(strings.TrimSpace(rawFormat))
+	if format == "" {
+		format = "mp3"
+	}
+	if transcoder == nil || audio.IsPassthrough(format) {
+		return enclosureFormat{ext: "m4a", contentType: "audio/mp4", kbps: origKbps}, nil
+	}
+	spec, ok := transcoder.Profiles().Lookup(audio.ProfileName(format))
+	if !ok || !spec.AudioOnly() || !spec.HTTP {
+		return enclosureFormat{}, errors.New("unknown audio format")
+	}
+	out := enclosureFormat{
+		ext:         spec.Format().Extension,
+		contentType: spec.ContentType,
+		kbps:        parseKbps(spec.Audio.Bitrate),
+	}
+	if kbps := parseKbps(rawKbps); kbps > 0 {
+		if variant, ok := spec.NearestAudioVariant(kbps); ok {
+			out.kbps = parseKbps(variant.Bitrate)
+			out.query = "?kbps=" + strconv.FormatFloat(out.kbps, 'f', -1, 64)
+		}
+	}
+	return out, nil
+}
+
+// parseKbps reads "64k" or "64".
+func parseKbps(raw string) float64 {
+	kbps, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(raw)), "k"), 64)
+	if err != nil || kbps < 0 {
+		return 0
+	}
+	return kbps
+}
+
+// baseURL is the scheme and host podcast apps reach this instance at; the
+// feed needs absolute URLs.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// build fetches the show and its episodes. URLs are relative to the
+// instance and the enclosures are left to render.
+func build(ctx context.Context, client *youtube.Client, channelID, listID string) (feed, error) {
+	now := time.Now()
+	show := channel{
+		Language:      "en",
+		LastBuildDate: now.Format(time.RFC1123Z),
+		Explicit:      "false",
+	}
+	var items []youtube.FeedItem
+	var artwork string
+	if channelID != "" {
+		uploads, err := client.ChannelFeed(ctx, channelID)
+		if err != nil {
+			return feed{}, err
+		}
+		items = uploads
+		show.Title, show.Link = channelID, "/channel?id="+url.QueryEscape(channelID)
+		if info, _, err := client.Channel(ctx, channelID); err == nil {
+			show.Title = firstNonEmpty(info.Title, channelID)
+			show.Author = info.Title
+			show.Description = info.Description
+			artwork = info.AvatarURL
+		}
+	} else {
+		info, videos, err := client.Playlist(ctx, listID)
+		if err != nil {
+			return feed{}, err
+		}
+		items = videos
+		show.Title = firstNonEmpty(info.Title, "Playlist "+listID)
+		show.Link = "/playlist?list=" + url.QueryEscape(listID)
+		show.Author = info.Author
+		show.Description = info.Description
+		artwork = info.ThumbnailURL
+	}
+	if len(items) > maxEpisodes {
+		items = items[:maxEpisodes]
+	}
+	if artwork == "" && len(items) > 0 {
+		artwork = items[0].Thumbnail
+	}
+	show.Description = firstNonEmpty(show.Description, show.Title)
+	show.Summary = show.Description
+	if artwork != "" {
+		art := ui.ProxiedImageSized(artwork, 300, 300)
+		show.Image = &image{URL: art, Title: show.Title, Link: show.Link}
+		show.ITunesImage = &itunesHref{Href: art}
+	}
+
+	for i, it := range items {
+		episode, ok := episodeFor(ctx, client, it)
+		if !ok {
+			continue
+		}
+		if episode.PubDate == "" {
+			// Without an upload date keep the feed order: newest first.
+			episode.PubDate = now.Add(-time.Duration(i) * time.Hour).Format(time.RFC1123Z)
+		}
+		show.Author = firstNonEmpty(show.Author, episode.Author)
+		show.Items = append(show.Items, episode)
+	}
+
+	return feed{show: show, built: now}, nil
+}
+
+// render writes f as RSS with absolute URLs below base and enclosures in
+// format.
+func render(f feed, format enclosureFormat, base string) ([]byte, error) {
+	show := f.show
+	show.Link = base + show.Link
+	if show.Image != nil {
+		show.Image = &image{URL: base + show.Image.URL, Title: show.Image.Title, Link: base + show.Image.Link}
+		show.ITunesImage = &itunesHref{Href: base + show.ITunesImage.Href}
+	}
+	show.Items = make([]item, len(f.show.Items))
+	for i, episode := range f.show.Items {
+		episode.Link = base + episode.Link
+		episode.Enclosure = enclosure{
+			URL:    base + "/stream/audio/" + url.PathEscape(episode.GUID.Value) + "." + format.ext + format.query,
+			Length: int64(episode.seconds * format.kbps * 1000 / 8),
+			Type:   format.contentType,
+		}
+		if episode.ITunesImage != nil {
+			episode.ITunesImage = &itunesHref{Href: base + episode.ITunesImage.Href}
+		}
+		show.Items[i] = episode
+	}
+	body, err := xml.MarshalIndent(rss{Version: "2.0", ITunes: itunesNS, Channel: show}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// episodeFor turns one upload into an item; live streams, which have no
+// length, are left out.
+func episodeFor(ctx context.Context, client *youtube.Client, it youtube.FeedItem) (item, bool) {
+	seconds, _ := transcode.ParseTimeSpec(it.Duration)
+	episode := item{
+		Title:  it.Title,
+		Link:   "/watch?v=" + url.QueryEscape(it.ID),
+		GUID:   guid{Value: it.ID},
+		Author: it.Channel,
+	}
+	thumb := it.Thumbnail
+	if video, err := client.GetVideo(ctx, it.ID); err == nil {
+		episode.Title = firstNonEmpty(video.Title, episode.Title)
+		episode.Author = firstNonEmpty(video.Author, episode.Author)
+		episode.Description = video.Description
+		thumb = firstNonEmpty(video.ThumbURL, thumb)
+		if secs, err := strconv.Atoi(video.LengthSeconds); err == nil {
+			seconds = float64(secs)
+		}
+		if published, ok := parseDate(video.Published); ok {
+			episode.PubDate = published.Format(time.RFC1123Z)
+		}
+	}
+	if seconds <= 0 {
+		return item{}, false
+	}
+	episode.Description = firstNonEmpty(episode.Description, episode.Title)
+	episode.Summary = episode.Description
+	episode.Duration = clock(seconds)
+	episode.seconds = seconds
+	if thumb != "" {
+		episode.ITunesImage = &itunesHref{Href: ui.ProxiedImage(thumb)}
+	}
+	return episode, true
+}
+
+// parseDate reads YouTube's publishDate, a bare date or a full timestamp.
+func parseDate(raw string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, strings.TrimSpace(raw)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func clock(seconds float64) string {
+	total := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, total%3600/60, total%60)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package podcast
+
+import "encoding/xml"
+
+const itunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+// rss is an RSS 2.0 document with the iTunes podcast extensions that
+// podcast apps rely on for artwork, durations and show notes.
+type rss struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	ITunes  string   `xml:"xmlns:itunes,attr"`
+	Channel channel  `xml:"channel"`
+}
+
+type channel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	Language      string      `xml:"language"`
+	LastBuildDate string      `xml:"lastBuildDate"`
+	Image         *image      `xml:"image,omitempty"`
+	Author        string      `xml:"itunes:author,omitempty"`
+	Summary       string      `xml:"itunes:summary,omitempty"`
+	ITunesImage   *itunesHref `xml:"itunes:image,omitempty"`
+	Explicit      string      `xml:"itunes:explicit"`
+	Items         []item      `xml:"item"`
+}
+
+type image struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type itunesHref struct {
+	Href string `xml:"href,attr"`
+}
+
+type item struct {
+	Title       string      `xml:"title"`
+	Link        string      `xml:"link"`
+	Description string      `xml:"description"`
+	GUID        guid        `xml:"guid"`
+	PubDate     string      `xml:"pubDate,omitempty"`
+	Enclosure   enclosure   `xml:"enclosure"`
+	Author      string      `xml:"itunes:author,omitempty"`
+	Duration    string      `xml:"itunes:duration,omitempty"`
+	Summary     string      `xml:"itunes:summary,omitempty"`
+	ITunesImage *itunesHref `xml:"itunes:image,omitempty"`
+
+	// seconds sizes the enclosure, which depends on the requested format.
+	seconds float64
+}
+
+type guid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type enclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
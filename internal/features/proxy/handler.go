@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
@@ -11,7 +12,13 @@ import (
 	"youtube-mini/internal/youtube"
 )
 
-const maxResourceSize = 5 << 20 // 5MB
+const (
+	maxResourceSize = 5 << 20 // 5MB
+	resourceTTL     = 10 * time.Minute
+	// imageCacheBytes bounds the recompressed images, whose keys carry the
+	// client's choice of options.
+	imageCacheBytes = 16 << 20
+)
 
 var allowedHosts = []string{
 	"ytimg.com",
@@ -28,9 +35,13 @@ type cachedResource struct {
 }
 
 // Handler returns an HTTP handler that proxies approved media through the backend.
+// Images can be resized and recompressed with w, h, q, fmt=jpeg|gif|png|wbmp,
+// gray=1 and dither=1; without those parameters bytes pass through unchanged.
 func Handler(client *youtube.Client) http.HandlerFunc {
 	httpClient := client.HTTPClient()
 	resCache := cache.New[cachedResource]()
+	images := cache.NewLRU[cachedResource](imageCacheBytes, func(e cachedResource) int64 { return int64(len(e.Data)) })
+	var transforms cache.Flight[cachedResource]
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -56,8 +67,19 @@ func Handler(client *youtube.Client) http.HandlerFunc {
 		}
 
 		cacheKey := target.String()
-		if entry, ok := resCache.Get(cacheKey); ok {
-			writeCached(w, entry)
+		opts, resize := parseImageOptions(r.URL.Query())
+		outKey := cacheKey + "#" + opts.key()
+		if resize {
+			if entry, ok := images.Get(outKey); ok {
+				writeCached(w, entry)
+				return
+			}
+		}
+		if raw, ok := resCache.Get(cacheKey); ok {
+			if resize {
+				raw = transformed(images, &transforms, outKey, raw, opts)
+			}
+			writeCached(w, raw)
 			return
 		}
 
@@ -96,12 +118,38 @@ func Handler(client *youtube.Client) http.HandlerFunc {
 			ContentType: contentType,
 			Status:      http.StatusOK,
 		}
-		resCache.Set(cacheKey, entry, 10*time.Minute)
+		resCache.Set(cacheKey, entry, resourceTTL)
+		if resize {
+			entry = transformed(images, &transforms, outKey, entry, opts)
+		}
 
 		writeCached(w, entry)
 	}
 }
 
+// transformed recompresses raw per opts and caches the result under key;
+// concurrent requests for the same key share one decode. Non-image resources
+// and images that fail to decode are served as they are.
+func transformed(images *cache.LRU[cachedResource], flight *cache.Flight[cachedResource], key string, raw cachedResource, opts imageOptions) cachedResource {
+	if !strings.HasPrefix(raw.ContentType, "image/") {
+		return raw
+	}
+	entry, _ := flight.Do(key, func() (cachedResource, error) {
+		if entry, ok := images.Get(key); ok {
+			return entry, nil
+		}
+		data, contentType, err := transformImage(raw.Data, opts)
+		if err != nil {
+			log.Printf("[proxy] transform: %v", err)
+			return raw, nil
+		}
+		entry := cachedResource{Data: data, ContentType: contentType, Status: http.StatusOK}
+		images.Set(key, entry, resourceTTL)
+		return entry, nil
+	})
+	return entry
+}
+
 func writeCached(w http.ResponseWriter, entry cachedResource) {
 	w.Header().Set("Content-Type", entry.ContentType)
 	w.Header().Set("Cache-Control", "public, max-age=300")
@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // ytimg serves WebP thumbnails
+)
+
+const (
+	maxImageSide       = 1280
+	maxSourcePixels    = 4096 * 4096
+	defaultJPEGQuality = 60
+)
+
+// imageSides are the widths and heights /proxy scales to: the sizes the UI
+// asks for plus a ladder for hand-written URLs. Other values round up to the
+// next step, so each thumbnail has a small, fixed set of variants.
+var imageSides = []int{32, 48, 54, 64, 94, 96, 128, 168, 176, 240, 300, 320, 480, 640, 800, 1024, maxImageSide}
+
+// imageQualities are the JPEG qualities q= rounds up to.
+var imageQualities = []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+// imageOptions are the resize/recompress parameters accepted by /proxy.
+type imageOptions struct {
+	width   int
+	height  int
+	quality int
+	format  string
+	gray    bool
+	dither  bool
+}
+
+// parseImageOptions reads w, h, q, fmt, gray and dither. The second result
+// is false when none is set and the resource should pass through untouched.
+func parseImageOptions(q url.Values) (imageOptions, bool) {
+	opts := imageOptions{quality: defaultJPEGQuality}
+	active := false
+	if v, err := strconv.Atoi(q.Get("w")); err == nil && v > 0 {
+		opts.width = snap(v, imageSides)
+		active = true
+	}
+	if v, err := strconv.Atoi(q.Get("h")); err == nil && v > 0 {
+		opts.height = snap(v, imageSides)
+		active = true
+	}
+	if v, err := strconv.Atoi(q.Get("q")); err == nil && v > 0 {
+		opts.quality = snap(v, imageQualities)
+		active = true
+	}
+	switch f := strings.ToLower(q.Get("fmt")); f {
+	case "jpeg", "jpg":
+		opts.format = "jpeg"
+		active = true
+	case "gif", "png", "wbmp":
+		opts.format = f
+		active = true
+	}
+	opts.gray = isTrue(q.Get("gray"))
+	opts.dither = isTrue(q.Get("dither"))
+	if opts.gray || opts.dither {
+		active = true
+	}
+	if opts.format == "" {
+		// Dithered 1-bit pictures compress far better losslessly.
+		opts.format = "jpeg"
+		if opts.dither {
+			opts.format = "gif"
+		}
+	}
+	return opts, active
+}
+
+// snap rounds v up to the next of steps, or down to the largest.
+func snap(v int, steps []int) int {
+	for _, step := range steps {
+		if v <= step {
+			return step
+		}
+	}
+	return steps[len(steps)-1]
+}
+
+func isTrue(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+func (o imageOptions) key() string {
+	return fmt.Sprintf("w=%d&h=%d&q=%d&fmt=%s&gray=%t&dither=%t", o.width, o.height, o.quality, o.format, o.gray, o.dither)
+}
+
+// transformImage decodes data (JPEG, PNG, GIF or WebP), scales and converts
+// it, and returns the encoded result with its content type.
+func transformImage(data []byte, opts imageOptions) ([]byte, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, "", errors.New("image too large")
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	img := resize(src, opts.width, opts.height)
+	if opts.gray || opts.dither || opts.format == "wbmp" {
+		gray := image.NewGray(img.Bounds())
+		draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+		img = gray
+	}
+	if opts.dither || opts.format == "wbmp" {
+		img = monochrome(img, opts.dither)
+	}
+
+	var buf bytes.Buffer
+	switch opts.format {
+	case "gif":
+		gifOpts := &gif.Options{NumColors: 256}
+		if _, ok := img.(*image.Gray); ok {
+			gifOpts.Quantizer = grayQuantizer{}
+		}
+		err = gif.Encode(&buf, img, gifOpts)
+		return buf.Bytes(), "image/gif", err
+	case "png":
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		err = enc.Encode(&buf, img)
+		return buf.Bytes(), "image/png", err
+	case "wbmp":
+		err = encodeWBMP(&buf, img)
+		return buf.Bytes(), "image/vnd.wap.wbmp", err
+	default:
+		if p, ok := img.(*image.Paletted); ok {
+			// JPEG cannot carry a palette; expand the 1-bit image to gray.
+			gray := image.NewGray(p.Bounds())
+			draw.Draw(gray, gray.Bounds(), p, p.Bounds().Min, draw.Src)
+			img = gray
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.quality})
+		return buf.Bytes(), "image/jpeg", err
+	}
+}
+
+// resize scales src to width x height. With both set the picture is
+// center-cropped to the target aspect first (this also trims the black bars
+// of letterboxed thumbnails); with one set the other follows the aspect.
+// Images are never enlarged.
+func resize(src image.Image, width, height int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 || (width == 0 && height == 0) {
+		return src
+	}
+	crop := b
+	switch {
+	case width > 0 && height > 0:
+		if sw*height > sh*width {
+			cw := sh * width / height
+			crop = image.Rect(b.Min.X+(sw-cw)/2, b.Min.Y, b.Min.X+(sw-cw)/2+cw, b.Max.Y)
+		} else {
+			ch := sw * height / width
+			crop = image.Rect(b.Min.X, b.Min.Y+(sh-ch)/2, b.Max.X, b.Min.Y+(sh-ch)/2+ch)
+		}
+	case width > 0:
+		height = max(1, sh*width/sw)
+	default:
+		width = max(1, sw*height/sh)
+	}
+	if width >= crop.Dx() && height >= crop.Dy() {
+		width, height = crop.Dx(), crop.Dy()
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.BiLinear.Scale(dst, dst.Bounds(), src, crop, xdraw.Src, nil)
+	return dst
+}
+
+var blackWhite = color.Palette{color.Black, color.White}
+
+// monochrome reduces img to black and white, error-diffused when dither is
+// set and thresholded otherwise.
+func monochrome(img image.Image, dither bool) *image.Paletted {
+	out := image.NewPaletted(img.Bounds(), blackWhite)
+	if dither {
+		draw.FloydSteinberg.Draw(out, out.Bounds(), img, img.Bounds().Min)
+	} else {
+		draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	return out
+}
+
+// grayQuantizer gives grayscale GIFs a 16-level gray palette.
+type grayQuantizer struct{}
+
+func (grayQuantizer) Quantize(p color.Palette, _ image.Image) color.Palette {
+	for i := 0; i < 16; i++ {
+		p = append(p, color.Gray{Y: uint8(i * 17)})
+	}
+	return p
+}
+
+// encodeWBMP writes a type 0 (uncompressed 1-bit) WAP bitmap. img is
+// expected to be black and white; any pixel brighter than mid-gray is white.
+func encodeWBMP(w io.Writer, img image.Image) error {
+	bw := bufio.NewWriter(w)
+	b := img.Bounds()
+	bw.WriteByte(0) // TypeField
+	bw.WriteByte(0) // FixHeaderField
+	writeUintvar(bw, b.Dx())
+	writeUintvar(bw, b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var cur byte
+		bit := 7
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y >= 128 {
+				cur |= 1 << bit
+			}
+			if bit == 0 {
+				bw.WriteByte(cur)
+				cur, bit = 0, 7
+				continue
+			}
+			bit--
+		}
+		if bit != 7 {
+			bw.WriteByte(cur)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeUintvar writes v as a WAP multi-byte integer (7 bits per byte, MSB first).
+func writeUintvar(w *bufio.Writer, v int) {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7f) | 0x80
+	}
+	w.Write(tmp[i:])
+}
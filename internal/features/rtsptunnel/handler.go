@@ -0,0 +1,18 @@
+package rtsptunnel
+
+import (
+	"net/http"
+
+	"youtube-mini/internal/transcode"
+)
+
+// Handler bridges RTSP-over-HTTP tunnels below /rtsp/ to the RTSP server.
+func Handler(svc *transcode.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if svc == nil {
+			http.NotFound(w, r)
+			return
+		}
+		svc.ServeRTSPTunnel(w, r)
+	}
+}
@@ -0,0 +1,148 @@
+package storyboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "golang.org/x/image/webp" // some storyboard sheets are WebP
+
+	"youtube-mini/internal/platform/cache"
+	"youtube-mini/internal/transcode"
+	"youtube-mini/internal/youtube"
+)
+
+const (
+	defaultWidth = 80
+	maxSheetSize = 2 << 20
+	sheetTTL     = 30 * time.Minute
+	frameTTL     = 6 * time.Hour
+	frameQuality = 75
+	// sheetCacheBytes and frameCacheBytes bound the caches; sheets are
+	// big and only worth keeping while a viewer scrubs through one video.
+	sheetCacheBytes = 32 << 20
+	frameCacheBytes = 16 << 20
+)
+
+type subImager interface {
+	SubImage(image.Rectangle) image.Image
+}
+
+// Handler serves /storyboard/<id>/<t>.jpg: the seek-preview frame nearest to
+// t (seconds or hh:mm:ss), cut out of YouTube's storyboard sprite sheets.
+// ?w= picks the smallest storyboard level at least that wide.
+func Handler(client *youtube.Client) http.HandlerFunc {
+	httpClient := client.HTTPClient()
+	size := func(b []byte) int64 { return int64(len(b)) }
+	sheets := cache.NewLRU[[]byte](sheetCacheBytes, size)
+	frames := cache.NewLRU[[]byte](frameCacheBytes, size)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+			return
+		}
+		rest := strings.TrimPrefix(r.URL.Path, "/storyboard/")
+		id, at, ok := strings.Cut(rest, "/")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			http.Error(w, "usage: /storyboard/<id>/<seconds>.jpg", http.StatusBadRequest)
+			return
+		}
+		seconds, ok := transcode.ParseTimeSpec(strings.TrimSuffix(at, ".jpg"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		width, err := strconv.Atoi(r.URL.Query().Get("w"))
+		if err != nil || width <= 0 {
+			width = defaultWidth
+		}
+
+		video, err := client.GetVideo(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		sb, ok := video.StoryboardFor(width)
+		if !ok {
+			http.Error(w, "no storyboard for this video", http.StatusNotFound)
+			return
+		}
+		idx := sb.FrameIndex(seconds)
+		key := fmt.Sprintf("%s/%d/%d", id, sb.Level, idx)
+		if data, ok := frames.Get(key); ok {
+			writeFrame(w, data)
+			return
+		}
+
+		sheetURL, rect := sb.Frame(idx)
+		sheet, ok := sheets.Get(sheetURL)
+		if !ok {
+			sheet, err = fetchSheet(r, httpClient, sheetURL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			sheets.Set(sheetURL, sheet, sheetTTL)
+		}
+
+		data, err := cropFrame(sheet, rect)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		frames.Set(key, data, frameTTL)
+		writeFrame(w, data)
+	}
+}
+
+func fetchSheet(r *http.Request, client *http.Client, sheetURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, sheetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "YouTubeMiniProxy/1.0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storyboard sheet: upstream status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxSheetSize))
+}
+
+// cropFrame cuts rect out of the sheet. The last sheet of a level is often
+// shorter than the grid, so rect is clipped to the decoded bounds.
+func cropFrame(sheet []byte, rect image.Rectangle) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(sheet))
+	if err != nil {
+		return nil, err
+	}
+	rect = rect.Intersect(img.Bounds())
+	sub, ok := img.(subImager)
+	if rect.Empty() || !ok {
+		return nil, errors.New("storyboard frame outside sheet")
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sub.SubImage(rect), &jpeg.Options{Quality: frameQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeFrame(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, _ = w.Write(data)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"youtube-mini/internal/transcode"
 	"youtube-mini/internal/youtube"
 )
 
@@ -20,7 +21,9 @@ var passthroughHeaders = []string{
 }
 
 // Handler proxies the first available MP4 stream directly, including range support for seeking.
-func Handler(client *youtube.Client) http.HandlerFunc {
+// With ?itag= it instead remuxes that adaptive video rendition and the best
+// matching audio track into one fragmented MP4 (needs the transcoder).
+func Handler(client *youtube.Client, transcoder *transcode.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet && r.Method != http.MethodHead {
 			http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
@@ -39,6 +42,20 @@ func Handler(client *youtube.Client) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if itag := strings.TrimSpace(r.URL.Query().Get("itag")); itag != "" {
+			if transcoder == nil {
+				http.Error(w, "remuxing not available", http.StatusNotFound)
+				return
+			}
+			videoFormat, audioFormat, ok := video.RemuxPair(itag)
+			if !ok {
+				http.Error(w, "unknown itag", http.StatusNotFound)
+				return
+			}
+			src := transcode.Source{URL: videoFormat.URL, AudioURL: audioFormat.URL}
+			transcoder.ServeRemux(w, r, id, src, startFromQuery(r))
+			return
+		}
 		if video.Stream == "" {
 			http.Error(w, "stream not available", http.StatusNotFound)
 			return
@@ -91,3 +108,18 @@ func Handler(client *youtube.Client) http.HandlerFunc {
 		_, _ = io.Copy(w, resp.Body)
 	}
 }
+
+func startFromQuery(r *http.Request) float64 {
+	q := r.URL.Query()
+	raw := strings.TrimSpace(q.Get("start"))
+	if raw == "" {
+		raw = strings.TrimSpace(q.Get("t"))
+	}
+	if raw == "" {
+		return 0
+	}
+	if secs, ok := transcode.ParseTimeSpec(raw); ok {
+		return secs
+	}
+	return 0
+}
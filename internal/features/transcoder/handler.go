@@ -1,15 +1,22 @@
 package transcoder
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"youtube-mini/internal/device"
 	"youtube-mini/internal/transcode"
 	"youtube-mini/internal/youtube"
 )
 
-// Handler wraps the transcode service into an HTTP endpoint.
-func Handler(client *youtube.Client, svc *transcode.Service) http.HandlerFunc {
+// Handler wraps the transcode service into an HTTP endpoint. Requests that do
+// not name a profile get the best one for the detected device; ?subs=<lang>
+// adds a caption track (see transcode.SubtitlesFromQuery). Seeking works by
+// time: ?t=, TimeSeekRange.dlna.org or a byte Range mapped through the
+// profile bitrate (see transcode.TimeSeek).
+func Handler(client *youtube.Client, svc *transcode.Service, devices *device.Detector) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		path := strings.TrimPrefix(r.URL.Path, "/stream/ffmpeg/")
 		id := strings.TrimSuffix(path, ".mp4")
@@ -18,36 +25,64 @@ func Handler(client *youtube.Client, svc *transcode.Service) http.HandlerFunc {
 			return
 		}
 
+		profile, ok := svc.Profiles().Requested(r.URL.Query())
+		if !ok {
+			profile = svc.Profiles().Default()
+			w.Header().Set("Vary", "User-Agent, Accept, X-Wap-Profile, Profile")
+			if spec, ok := devices.Detect(r).BestProfile(svc.Profiles().All(), false); ok {
+				profile = spec.Name
+			}
+		}
+		start := startFromQuery(r)
+		subs, hasSubs := transcode.SubtitlesFromQuery(r.URL.Query())
+		// Finished transcodes answer byte Ranges exactly; time seeks need ffmpeg.
+		timeSeek := r.Header.Get("TimeSeekRange.dlna.org") != ""
+		if start == 0 && !hasSubs && !timeSeek && svc.ServeCached(w, r, id, profile) {
+			return
+		}
+
 		video, err := client.GetVideo(r.Context(), id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if video.Stream == "" {
+		sources := transcode.VideoSources(video)
+		if len(sources) == 0 {
 			http.Error(w, "stream not available", http.StatusNotFound)
 			return
 		}
 
-		profile := profileFromQuery(r)
-		start := startFromQuery(r)
-		if err := svc.Stream(r.Context(), w, video.Stream, id, profile, start); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		duration, _ := strconv.ParseFloat(video.LengthSeconds, 64)
+		seek, err := svc.TimeSeekFor(r, profile, start, duration)
+		if errors.Is(err, transcode.ErrUnsatisfiableRange) {
+			http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if r.Method == http.MethodHead {
+			if spec, ok := svc.Profiles().Lookup(profile); ok {
+				w.Header().Set("Content-Type", spec.ContentType)
+			}
+			w.WriteHeader(seek.SetHeaders(w.Header()))
 			return
 		}
-	}
-}
 
-func profileFromQuery(r *http.Request) transcode.Profile {
-	q := r.URL.Query()
-	switch {
-	case len(q["aac"]) > 0:
-		return transcode.ProfileAAC
-	case len(q["mp3"]) > 0:
-		return transcode.ProfileMP3
-	case len(q["edge"]) > 0:
-		return transcode.ProfileEdge
-	default:
-		return transcode.ProfileRetro
+		ctx := transcode.WithClient(r.Context(), r.RemoteAddr)
+		if hasSubs {
+			ctx = transcode.WithSubtitles(ctx, subs)
+		}
+		if err := svc.Stream(ctx, seek.Writer(w), sources, id, profile, seek.Start); err != nil {
+			if transcode.IsRangeServed(err) {
+				return
+			}
+			var busy *transcode.BusyError
+			if errors.As(err, &busy) {
+				w.Header().Set("Retry-After", busy.RetryAfterSeconds())
+				http.Error(w, "transcoder busy, try again shortly", http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
@@ -5,8 +5,10 @@ import (
 	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
+	"youtube-mini/internal/device"
 	"youtube-mini/internal/features/history"
 	"youtube-mini/internal/features/queue"
 	"youtube-mini/internal/features/settings"
@@ -19,7 +21,7 @@ import (
 )
 
 // Handler renders the watch page with related videos, queue controls, watch later, and autoplay.
-func Handler(client *youtube.Client, transcoder *transcode.Service) http.HandlerFunc {
+func Handler(client *youtube.Client, transcoder *transcode.Service, devices *device.Detector) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := strings.TrimSpace(r.URL.Query().Get("v"))
 		if id == "" {
@@ -92,13 +94,13 @@ func Handler(client *youtube.Client, transcoder *transcode.Service) http.Handler
 		}
 		startSuffix := ""
 		startSuffixFirst := ""
-		rtspStartSuffix := ""
+		rtspStart := ""
 		if secs, ok := transcode.ParseTimeSpec(startRaw); ok && secs > 0 {
 			startInt := int(math.Round(secs))
 			if startInt > 0 {
 				startSuffix = fmt.Sprintf("&start=%d", startInt)
 				startSuffixFirst = fmt.Sprintf("?start=%d", startInt)
-				rtspStartSuffix = fmt.Sprintf("?start=%d", startInt)
+				rtspStart = fmt.Sprintf("start=%d", startInt)
 			}
 		}
 		if startSuffixFirst != "" {
@@ -106,35 +108,118 @@ func Handler(client *youtube.Client, transcoder *transcode.Service) http.Handler
 			audioURL += startSuffixFirst
 		}
 
-		transcodeLinks := []ui.Link{
-			{Label: "MP4 240p (AAC)", URL: fmt.Sprintf("/stream/ffmpeg/%s.mp4?aac%s", video.ID, startSuffix)},
+		profiles := transcode.DefaultProfiles()
+		rtspEnabled := false
+		if transcoder != nil {
+			profiles = transcoder.Profiles()
+			rtspEnabled = transcoder.RTSPEnabled()
 		}
-		if transcoder != nil && transcoder.RTSPEnabled() {
-			if retro := transcoder.RTSPURL(r.Host, transcode.ProfileRetro, video.ID); retro != "" {
-				if rtspStartSuffix != "" {
-					retro += rtspStartSuffix
-				}
-				transcodeLinks = append(transcodeLinks, ui.Link{Label: "3GP 144p (Retro RTSP)", URL: retro})
+		transcodeLinks := make([]ui.Link, 0, 4)
+		for _, spec := range profiles.All() {
+			if spec.Hidden {
+				continue
 			}
-			if edge := transcoder.RTSPURL(r.Host, transcode.ProfileEdge, video.ID); edge != "" {
-				if rtspStartSuffix != "" {
-					edge += rtspStartSuffix
+			if rtspEnabled && spec.RTSP {
+				if rtspURL := transcoder.RTSPURL(r.Host, spec.Name, video.ID); rtspURL != "" {
+					transcodeLinks = append(transcodeLinks, ui.Link{Label: spec.DisplayLabel(true), URL: withQuery(rtspURL, rtspStart)})
 				}
-				transcodeLinks = append(transcodeLinks, ui.Link{Label: "3GP 96p (Edge RTSP)", URL: edge})
+				continue
 			}
-			if android := transcoder.RTSPURL(r.Host, transcode.ProfileAndroid, video.ID); android != "" {
-				if rtspStartSuffix != "" {
-					android += rtspStartSuffix
-				}
-				transcodeLinks = append(transcodeLinks, ui.Link{Label: "RTSP 240p (Android MPEG-4)", URL: android})
+			if spec.HTTP {
+				transcodeLinks = append(transcodeLinks, ui.Link{
+					Label: spec.DisplayLabel(false),
+					URL:   fmt.Sprintf("/stream/ffmpeg/%s.mp4?%s%s", video.ID, url.QueryEscape(string(spec.Name)), startSuffix),
+				})
 			}
-		} else {
+		}
+
+		if transcoder != nil {
 			transcodeLinks = append(transcodeLinks,
-				ui.Link{Label: "3GP 144p (Retro)", URL: fmt.Sprintf("/stream/ffmpeg/%s.mp4?retro%s", video.ID, startSuffix)},
-				ui.Link{Label: "3GP 96p (Edge)", URL: fmt.Sprintf("/stream/ffmpeg/%s.mp4?edge%s", video.ID, startSuffix)},
+				ui.Link{Label: "HLS (iPhone / Android)", URL: transcoder.HLSURL(video.ID) + startSuffixFirst},
+				ui.Link{Label: "MJPEG (no player)", URL: fmt.Sprintf("/stream/mjpeg/%s%s", video.ID, startSuffixFirst)},
+				ui.Link{Label: "Animated GIF", URL: fmt.Sprintf("/stream/gif/%s.gif%s", video.ID, startSuffixFirst)},
+				ui.Link{Label: "Slideshow (WAP)", URL: fmt.Sprintf("/slides/%s%s", video.ID, startSuffixFirst)},
 			)
 		}
 
+		// WAP browsers never run the player JS, so recommend a profile server-side.
+		var deviceLink ui.Link
+		if transcoder != nil {
+			caps := devices.Detect(r)
+			if rtspEnabled && caps.RTSP && caps.Legacy {
+				if spec, ok := caps.BestProfile(profiles.All(), true); ok {
+					if rtspURL := transcoder.RTSPURL(r.Host, spec.Name, video.ID); rtspURL != "" {
+						deviceLink = ui.Link{Label: spec.DisplayLabel(true), URL: withQuery(rtspURL, rtspStart)}
+					}
+				}
+			}
+			if deviceLink.URL == "" {
+				if spec, ok := caps.BestProfile(profiles.All(), false); ok {
+					deviceLink = ui.Link{
+						Label: spec.DisplayLabel(false),
+						URL:   fmt.Sprintf("/stream/ffmpeg/%s.mp4?%s%s", video.ID, url.QueryEscape(string(spec.Name)), startSuffix),
+					}
+				}
+			}
+		}
+
+		var audioLinks []ui.Link
+		if transcoder != nil {
+			for _, spec := range profiles.All() {
+				format, ok := strings.CutPrefix(string(spec.Name), "audio-")
+				if !ok || !spec.AudioOnly() || !spec.HTTP {
+					continue
+				}
+				base := fmt.Sprintf("/stream/audio/%s.%s?fmt=%s", video.ID, spec.Format().Extension, url.QueryEscape(format))
+				if len(spec.AudioVariants) == 0 {
+					audioLinks = append(audioLinks, ui.Link{Label: spec.DisplayLabel(false), URL: base + startSuffix})
+					continue
+				}
+				for _, v := range spec.AudioVariants {
+					audioLinks = append(audioLinks, ui.Link{
+						Label: spec.DisplayLabel(false) + " " + v.Bitrate,
+						URL:   base + "&kbps=" + strconv.FormatFloat(v.Kbps(), 'f', -1, 64) + startSuffix,
+					})
+				}
+			}
+			if rtspEnabled {
+				for _, spec := range profiles.All() {
+					if !spec.AudioOnly() || !spec.RTSP {
+						continue
+					}
+					if rtspURL := transcoder.RTSPURL(r.Host, spec.Name, video.ID); rtspURL != "" {
+						audioLinks = append(audioLinks, ui.Link{Label: spec.DisplayLabel(true), URL: withQuery(rtspURL, rtspStart)})
+					}
+				}
+			}
+			audioLinks = append(audioLinks, ui.Link{
+				Label: "Original (no transcode)",
+				URL:   fmt.Sprintf("/stream/audio/%s.m4a?fmt=orig", video.ID),
+			})
+		}
+
+		var subtitleLinks []ui.Link
+		if transcoder != nil {
+			subtitleLinks = buildSubtitleLinks(video, transcoder, r.Host, rtspEnabled, startSuffix)
+		}
+
+		var remuxLinks []ui.Link
+		if transcoder != nil {
+			for _, f := range video.RemuxQualities() {
+				remuxLinks = append(remuxLinks, ui.Link{
+					Label: f.Quality,
+					URL:   fmt.Sprintf("/stream/%s.mp4?itag=%s%s", video.ID, url.QueryEscape(f.Itag), startSuffix),
+				})
+			}
+		}
+
+		var downloadLinks []ui.Link
+		if transcoder != nil && transcoder.Downloads() != nil {
+			downloadLinks = buildDownloadLinks(video, profiles)
+		}
+
+		jumps := buildJumps(video)
+
 		data := ui.WatchPageData{
 			Theme:             theme.FromRequest(r),
 			CurrentPath:       returnPath,
@@ -142,6 +227,12 @@ func Handler(client *youtube.Client, transcoder *transcode.Service) http.Handler
 			StreamURL:         streamURL,
 			AudioURL:          audioURL,
 			TranscodeLinks:    transcodeLinks,
+			AudioLinks:        audioLinks,
+			SubtitleLinks:     subtitleLinks,
+			RemuxLinks:        remuxLinks,
+			DownloadLinks:     downloadLinks,
+			DeviceLink:        deviceLink,
+			Jumps:             jumps,
 			Captions:          video.Captions,
 			AutoplayEnabled:   autoplayEnabled,
 			AutoplayToggleURL: "/settings/autoplay?return=" + url.QueryEscape(returnPath),
@@ -162,6 +253,118 @@ func Handler(client *youtube.Client, transcoder *transcode.Service) http.Handler
 	}
 }
 
+const maxSubtitleTracks = 4
+
+// buildDownloadLinks offers the muxed and audio-only upstream formats as
+// they are, and every visible HTTP profile as a transcode. The page posts
+// to them.
+func buildDownloadLinks(video youtube.Video, profiles *transcode.ProfileRegistry) []ui.Link {
+	var links []ui.Link
+	add := func(label, query string) {
+		links = append(links, ui.Link{Label: label, URL: "/downloads/add?v=" + url.QueryEscape(video.ID) + "&" + query})
+	}
+	for _, f := range video.Formats {
+		src := transcode.SourceFormat{Mime: f.Mime}
+		if f.URL == "" || f.Itag == "" || !src.HasVideo() || !src.HasAudio() {
+			continue
+		}
+		add(strings.TrimSpace(f.Quality+" "+mimeSubtype(f.Mime)), "itag="+url.QueryEscape(f.Itag))
+	}
+	for _, f := range video.Audio {
+		if f.URL == "" || f.Itag == "" {
+			continue
+		}
+		label := "Audio " + mimeSubtype(f.Mime)
+		if kbps, err := strconv.Atoi(f.Bitrate); err == nil && kbps > 0 {
+			label += fmt.Sprintf(" %dk", kbps/1000)
+		}
+		add(label, "itag="+url.QueryEscape(f.Itag))
+	}
+	for _, spec := range profiles.All() {
+		if spec.Hidden || !spec.HTTP {
+			continue
+		}
+		add(spec.DisplayLabel(false), "profile="+url.QueryEscape(string(spec.Name)))
+	}
+	return links
+}
+
+// mimeSubtype turns `video/mp4; codecs="..."` into "MP4".
+func mimeSubtype(mime string) string {
+	base, _, _ := strings.Cut(mime, ";")
+	_, sub, _ := strings.Cut(strings.TrimSpace(base), "/")
+	return strings.ToUpper(sub)
+}
+
+// buildSubtitleLinks offers the retro profile with each caption track burned
+// in, over HTTP and (when enabled) RTSP.
+func buildSubtitleLinks(video youtube.Video, transcoder *transcode.Service, host string, rtspEnabled bool, startSuffix string) []ui.Link {
+	spec, ok := transcoder.Profiles().Lookup(transcode.ProfileRetro)
+	if !ok {
+		return nil
+	}
+	var links []ui.Link
+	seen := make(map[string]bool)
+	for _, track := range video.Captions {
+		if track.Code == "" || seen[track.Code] || len(seen) >= maxSubtitleTracks {
+			continue
+		}
+		seen[track.Code] = true
+		subs := "subs=" + url.QueryEscape(track.Code)
+		if spec.HTTP {
+			links = append(links, ui.Link{
+				Label: track.Language + " - " + spec.DisplayLabel(false),
+				URL:   fmt.Sprintf("/stream/ffmpeg/%s.mp4?%s&%s%s", video.ID, url.QueryEscape(string(spec.Name)), subs, startSuffix),
+			})
+		}
+		if rtspEnabled && spec.RTSP {
+			if rtspURL := transcoder.RTSPURL(host, spec.Name, video.ID); rtspURL != "" {
+				links = append(links, ui.Link{Label: track.Language + " - " + spec.DisplayLabel(true), URL: withQuery(rtspURL, subs+startSuffix)})
+			}
+		}
+	}
+	return links
+}
+
+// withQuery appends query to rawURL, which may already carry parameters
+// (RTSP URLs do when they are signed).
+func withQuery(rawURL, query string) string {
+	switch {
+	case query == "":
+		return rawURL
+	case strings.Contains(rawURL, "?"):
+		return rawURL + "&" + query
+	}
+	return rawURL + "?" + query
+}
+
+const (
+	jumpCount = 12
+	jumpWidth = 80
+)
+
+// buildJumps spreads jumpCount storyboard frames over the video; each links
+// back to the watch page with ?t= so the stream links start there.
+func buildJumps(video youtube.Video) []ui.JumpEntry {
+	length, _ := strconv.Atoi(video.LengthSeconds)
+	sb, ok := video.StoryboardFor(jumpWidth)
+	if !ok || length < jumpCount {
+		return nil
+	}
+	jumps := make([]ui.JumpEntry, 0, jumpCount)
+	for i := 0; i < jumpCount; i++ {
+		secs := i * length / jumpCount
+		jumps = append(jumps, ui.JumpEntry{
+			Seconds:  secs,
+			URL:      fmt.Sprintf("/watch?v=%s&t=%d", url.QueryEscape(video.ID), secs),
+			ThumbURL: fmt.Sprintf("/storyboard/%s/%d.jpg?w=%d", url.PathEscape(video.ID), secs, jumpWidth),
+			Width:    sb.Width,
+			Height:   sb.Height,
+		})
+	}
+	return jumps
+}
+
 func removeID(list []string, id string) []string {
 	filtered := make([]string, 0, len(list))
 	for _, it := range list {
@@ -0,0 +1,41 @@
+package cache
+
+import "sync"
+
+// Flight collapses concurrent loads of the same key: callers arriving while
+// a load runs wait for it and share its result instead of starting their own.
+type Flight[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// Do runs load for key unless one is already running, and returns its result.
+func (f *Flight[T]) Do(key string, load func() (T, error)) (T, error) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*flightCall[T])
+	}
+	if call, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &flightCall[T]{done: make(chan struct{})}
+	f.calls[key] = call
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = load()
+	return call.value, call.err
+}
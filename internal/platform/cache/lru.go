@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a bounded TTL cache for keys that clients control. Once the total
+// cost of its entries exceeds the limit the least recently used ones are
+// evicted; expired entries are dropped when they are read.
+type LRU[T any] struct {
+	mu    sync.Mutex
+	limit int64
+	cost  func(T) int64
+	used  int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry[T any] struct {
+	key   string
+	value T
+	cost  int64
+	exp   time.Time
+}
+
+// NewLRU returns a cache holding entries up to limit. cost weighs an entry,
+// e.g. by its size in bytes; nil counts every entry as 1.
+func NewLRU[T any](limit int64, cost func(T) int64) *LRU[T] {
+	if cost == nil {
+		cost = func(T) int64 { return 1 }
+	}
+	return &LRU[T]{limit: limit, cost: cost, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get returns the cached value or false if absent/expired.
+func (c *LRU[T]) Get(key string) (T, bool) {
+	var zero T
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*lruEntry[T])
+	if time.Now().After(e.exp) {
+		c.removeLocked(el)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores a value with the provided TTL. A value costing more than the
+// whole limit is not stored.
+func (c *LRU[T]) Set(key string, value T, ttl time.Duration) {
+	cost := c.cost(value)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeLocked(el)
+	}
+	if cost > c.limit {
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[T]{key: key, value: value, cost: cost, exp: time.Now().Add(ttl)})
+	c.used += cost
+	for c.used > c.limit {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+// Len reports how many entries are held.
+func (c *LRU[T]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+func (c *LRU[T]) removeLocked(el *list.Element) {
+	e := c.order.Remove(el).(*lruEntry[T])
+	delete(c.items, e.key)
+	c.used -= e.cost
+}
@@ -7,19 +7,29 @@ import (
 	"sync"
 )
 
-// Registry is a minimal in-memory counter store.
+// Registry is a minimal in-memory counter store. Gauges are read from
+// callbacks whenever the registry is scraped.
 type Registry struct {
 	mu     sync.RWMutex
 	counts map[string]uint64
+	gauges map[string]func() float64
 }
 
 // New creates an empty registry.
 func New() *Registry {
 	return &Registry{
 		counts: make(map[string]uint64),
+		gauges: make(map[string]func() float64),
 	}
 }
 
+// Gauge registers fn as the source of a named value.
+func (r *Registry) Gauge(name string, fn func() float64) {
+	r.mu.Lock()
+	r.gauges[name] = fn
+	r.mu.Unlock()
+}
+
 // Wrap adds counting middleware around a handler.
 func (r *Registry) Wrap(name string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -40,12 +50,19 @@ func (r *Registry) Handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		r.mu.RLock()
-		keys := make([]string, 0, len(r.counts))
+		keys := make([]string, 0, len(r.counts)+len(r.gauges))
 		for k := range r.counts {
 			keys = append(keys, k)
 		}
+		for k := range r.gauges {
+			keys = append(keys, k)
+		}
 		sort.Strings(keys)
 		for _, k := range keys {
+			if fn, ok := r.gauges[k]; ok {
+				fmt.Fprintf(w, "%s %g\n", k, fn())
+				continue
+			}
 			fmt.Fprintf(w, "%s %d\n", k, r.counts[k])
 		}
 		r.mu.RUnlock()
@@ -0,0 +1,165 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// Backend runs ffmpeg processes for the Service. LocalBackend execs the
+// binary on this machine; RemoteBackend hands jobs to worker nodes.
+type Backend interface {
+	// Start launches c. Returning ErrRunLocally makes the Service run it
+	// on its own ffmpeg instead.
+	Start(ctx context.Context, c Command) (Process, error)
+}
+
+// ErrRunLocally is returned by backends that cannot take a command.
+var ErrRunLocally = errors.New("transcode: run locally")
+
+// Command is one ffmpeg run.
+type Command struct {
+	// Args is the full ffmpeg command line for a local run.
+	Args []string
+	// Stdin asks for a writer feeding ffmpeg's standard input.
+	Stdin bool
+	// Stdout, when set, receives the output directly; otherwise it is read
+	// from Process.Stdout.
+	Stdout io.Writer
+	// Spec describes the job without local paths so a worker node can run
+	// it against its own copy of the source. Jobs without one (RTSP
+	// publishers, HLS segmenters, caption burn-in) always run locally.
+	Spec *TranscodeSpec
+}
+
+// TranscodeSpec is the portable form of an HTTP transcode job. It names
+// the profile rather than carrying its codec arguments: the worker resolves
+// it against its own registry and retro filter, so a job can never smuggle
+// ffmpeg options onto the worker's command line.
+type TranscodeSpec struct {
+	VideoID string  `json:"video_id"`
+	Source  Source  `json:"source"`
+	Profile Profile `json:"profile"`
+	Start   float64 `json:"start"`
+}
+
+// transcodeSpec describes an HTTP transcode of profile for a worker node.
+func (s *Service) transcodeSpec(videoID string, src Source, profile Profile, start float64) *TranscodeSpec {
+	if _, ok := s.profiles.Lookup(profile); !ok {
+		return nil
+	}
+	return &TranscodeSpec{VideoID: videoID, Source: src, Profile: profile, Start: start}
+}
+
+// Process is a started ffmpeg run.
+type Process interface {
+	// Stdin is nil unless the Command asked for it and the process reads
+	// the input from there.
+	Stdin() io.WriteCloser
+	// Stdout is nil when the Command set its own Stdout.
+	Stdout() io.Reader
+	Stderr() io.Reader
+	// Wait blocks until the run ends, after Stdout has been read to EOF.
+	Wait() error
+	Kill() error
+}
+
+// LocalBackend runs ffmpeg on this machine.
+type LocalBackend struct {
+	// Command is the ffmpeg binary; empty means "ffmpeg" from PATH.
+	Command string
+}
+
+// Start implements Backend.
+func (b LocalBackend) Start(ctx context.Context, c Command) (Process, error) {
+	command := b.Command
+	if command == "" {
+		command = "ffmpeg"
+	}
+	cmd := exec.CommandContext(ctx, command, c.Args...)
+	p := &localProcess{cmd: cmd}
+	var err error
+	if c.Stdin {
+		if p.stdin, err = cmd.StdinPipe(); err != nil {
+			return nil, fmt.Errorf("stdin pipe: %w", err)
+		}
+	}
+	if c.Stdout != nil {
+		cmd.Stdout = c.Stdout
+	} else if p.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+	if p.stderr, err = cmd.StderrPipe(); err != nil {
+		return nil, fmt.Errorf("stderr pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		if p.stdin != nil {
+			_ = p.stdin.Close()
+		}
+		return nil, fmt.Errorf("ffmpeg start: %w", err)
+	}
+	return p, nil
+}
+
+type localProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.Reader
+	stderr io.Reader
+}
+
+func (p *localProcess) Stdin() io.WriteCloser { return p.stdin }
+func (p *localProcess) Stdout() io.Reader     { return p.stdout }
+func (p *localProcess) Stderr() io.Reader     { return p.stderr }
+func (p *localProcess) Wait() error           { return p.cmd.Wait() }
+
+func (p *localProcess) Kill() error {
+	if p.cmd.Process == nil {
+		return nil
+	}
+	return p.cmd.Process.Kill()
+}
+
+// WithBackend replaces the process backend (LocalBackend by default).
+func (s *Service) WithBackend(b Backend) *Service {
+	s.backend = b
+	return s
+}
+
+// start runs c on the configured backend, or locally when the backend
+// declines it.
+func (s *Service) start(ctx context.Context, c Command) (Process, error) {
+	if s.backend != nil {
+		p, err := s.backend.Start(ctx, c)
+		if !errors.Is(err, ErrRunLocally) {
+			return p, err
+		}
+	}
+	return LocalBackend{Command: s.command}.Start(ctx, c)
+}
+
+// startScheduled is start for jobs that count against the scheduler. The
+// local slot for profile is taken only when the job runs here: a worker
+// node accounts for its own jobs and answers 503 when full, which sends
+// the job back to this machine. queueCtx bounds the wait for a slot, ctx
+// the process. The returned release frees the slot once the job is done.
+func (s *Service) startScheduled(queueCtx, ctx context.Context, c Command, profile Profile, client string) (Process, func(), error) {
+	if s.backend != nil {
+		p, err := s.backend.Start(ctx, c)
+		if !errors.Is(err, ErrRunLocally) {
+			return p, func() {}, err
+		}
+	}
+	release, err := s.scheduler.Acquire(queueCtx, profile, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := LocalBackend{Command: s.command}.Start(ctx, c)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return p, release, nil
+}
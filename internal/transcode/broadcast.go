@@ -0,0 +1,312 @@
+package transcode
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sync"
+)
+
+const (
+	broadcastChunkSize   = 32 << 10
+	broadcastReaderQueue = 512
+	broadcastMaxHeader   = 4 << 20
+)
+
+var errSlowReader = errors.New("transcode: reader fell behind the shared encode")
+
+// broadcastHub shares one running encode between HTTP clients asking for the
+// same video, profile and start offset.
+type broadcastHub struct {
+	mu       sync.Mutex
+	sessions map[string]*broadcast
+}
+
+func newBroadcastHub() *broadcastHub {
+	return &broadcastHub{sessions: make(map[string]*broadcast)}
+}
+
+func broadcastKey(videoID string, profile Profile, start float64) string {
+	return fmt.Sprintf("%s|%s|%d", videoID, profile, int64(math.Round(start)))
+}
+
+// attach joins a running encode for key or registers a new one. When created is
+// true the caller is responsible for starting the encode or finishing it with an error.
+func (h *broadcastHub) attach(key string) (b *broadcast, reader *broadcastReader, created bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if existing, ok := h.sessions[key]; ok {
+		if reader := existing.addReader(); reader != nil {
+			return existing, reader, false
+		}
+	}
+	b = &broadcast{
+		hub:     h,
+		key:     key,
+		started: make(chan struct{}),
+		readers: make(map[*broadcastReader]struct{}),
+	}
+	h.sessions[key] = b
+	return b, b.addReader(), true
+}
+
+func (h *broadcastHub) remove(b *broadcast) {
+	h.mu.Lock()
+	if current, ok := h.sessions[b.key]; ok && current == b {
+		delete(h.sessions, b.key)
+	}
+	h.mu.Unlock()
+}
+
+// broadcast fans out the stdout of one ffmpeg process to every attached reader.
+// For fragmented ISO BMFF output (see outputFormat.Fragmented) the bytes before
+// the first moof box are kept so late joiners receive the container header and
+// then continue from the next fragment; other output only accepts joiners until
+// the first byte is sent.
+type broadcast struct {
+	hub     *broadcastHub
+	key     string
+	started chan struct{}
+	sink    io.Writer
+
+	mu         sync.Mutex
+	format     outputFormat
+	fragmented bool
+	header     []byte
+	headerDone bool
+	headerLost bool
+	written    int64
+	readers    map[*broadcastReader]struct{}
+	cancel     context.CancelFunc
+	done       bool
+	err        error
+}
+
+type broadcastReader struct {
+	ch      chan []byte
+	waiting bool
+	err     error
+}
+
+// addReader must be called with the hub lock held; it returns nil if the
+// encode can no longer be joined.
+func (b *broadcast) addReader() *broadcastReader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return nil
+	}
+	if b.written > 0 && (!b.fragmented || b.headerLost) {
+		// Mid-stream joins need a container header we can replay.
+		return nil
+	}
+	reader := &broadcastReader{ch: make(chan []byte, broadcastReaderQueue)}
+	if len(b.header) > 0 {
+		reader.ch <- b.header
+		reader.waiting = b.headerDone
+	}
+	b.readers[reader] = struct{}{}
+	return reader
+}
+
+// begin records the output format once ffmpeg is running and wakes waiting readers.
+func (b *broadcast) begin(format outputFormat, cancel context.CancelFunc) {
+	b.mu.Lock()
+	b.format = format
+	b.fragmented = format.Fragmented
+	b.cancel = cancel
+	b.mu.Unlock()
+	safeClose(b.started)
+}
+
+// waitStarted blocks until the encode is running and returns its output format.
+func (b *broadcast) waitStarted(ctx context.Context) (outputFormat, error) {
+	select {
+	case <-b.started:
+	case <-ctx.Done():
+		return outputFormat{}, ctx.Err()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil && b.written == 0 {
+		return outputFormat{}, b.err
+	}
+	return b.format, nil
+}
+
+// emit tees chunk into the optional sink before fanning it out. It is only
+// called from the pump goroutine, so the sink needs no locking.
+func (b *broadcast) emit(chunk []byte, boxType string) {
+	if b.sink != nil {
+		_, _ = b.sink.Write(chunk)
+	}
+	b.publish(chunk, boxType)
+}
+
+func (b *broadcast) publish(chunk []byte, boxType string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return
+	}
+	b.written += int64(len(chunk))
+	if b.fragmented && !b.headerDone {
+		if boxType == "moof" {
+			b.headerDone = true
+		} else if len(b.header)+len(chunk) <= broadcastMaxHeader {
+			b.header = append(b.header, chunk...)
+		} else {
+			b.headerLost = true
+		}
+	}
+	for reader := range b.readers {
+		if reader.waiting {
+			if boxType != "moof" {
+				continue
+			}
+			reader.waiting = false
+		}
+		select {
+		case reader.ch <- chunk:
+		default:
+			reader.err = errSlowReader
+			delete(b.readers, reader)
+			close(reader.ch)
+		}
+	}
+}
+
+// finish ends the broadcast, closing every reader with err (nil on success).
+func (b *broadcast) finish(err error) {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+	b.done = true
+	b.err = err
+	for reader := range b.readers {
+		reader.err = err
+		close(reader.ch)
+	}
+	b.readers = nil
+	b.mu.Unlock()
+
+	safeClose(b.started)
+	b.hub.remove(b)
+}
+
+// leave detaches reader and tears the encode down once nobody is listening.
+func (b *broadcast) leave(reader *broadcastReader) {
+	b.mu.Lock()
+	if _, ok := b.readers[reader]; ok {
+		delete(b.readers, reader)
+		close(reader.ch)
+	}
+	idle := len(b.readers) == 0 && !b.done
+	cancel := b.cancel
+	b.mu.Unlock()
+
+	if !idle {
+		return
+	}
+	b.hub.remove(b)
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// next returns the following chunk, or io.EOF/the encode error once the stream ends.
+func (r *broadcastReader) next(ctx context.Context) ([]byte, error) {
+	select {
+	case chunk, ok := <-r.ch:
+		if ok {
+			return chunk, nil
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, io.EOF
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// pump copies src into the broadcast. Fragmented output is split on top-level
+// ISO BMFF box boundaries so each box start is tagged with its type.
+func (b *broadcast) pump(src io.Reader) error {
+	b.mu.Lock()
+	fragmented := b.fragmented
+	b.mu.Unlock()
+	if !fragmented {
+		return copyChunks(src, -1, func(chunk []byte) { b.emit(chunk, "") })
+	}
+
+	head := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(src, head); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(head[:4]))
+		boxType := string(head[4:8])
+		boxHead := append([]byte(nil), head...)
+		switch size {
+		case 0:
+			// Box runs to the end of the stream.
+			b.emit(boxHead, boxType)
+			return copyChunks(src, -1, func(chunk []byte) { b.emit(chunk, "") })
+		case 1:
+			ext := make([]byte, 8)
+			if _, err := io.ReadFull(src, ext); err != nil {
+				return err
+			}
+			boxHead = append(boxHead, ext...)
+			size = int64(binary.BigEndian.Uint64(ext))
+		}
+		if size < int64(len(boxHead)) {
+			log.Printf("[broadcast] malformed box %q (size %d), passing through raw", boxType, size)
+			b.emit(boxHead, "")
+			return copyChunks(src, -1, func(chunk []byte) { b.emit(chunk, "") })
+		}
+		b.emit(boxHead, boxType)
+		if err := copyChunks(src, size-int64(len(boxHead)), func(chunk []byte) { b.emit(chunk, "") }); err != nil {
+			return err
+		}
+	}
+}
+
+// copyChunks forwards n bytes (or everything when n < 0) in freshly allocated
+// chunks, since readers hold on to them after emit returns.
+func copyChunks(src io.Reader, n int64, emit func([]byte)) error {
+	for n != 0 {
+		size := int64(broadcastChunkSize)
+		if n > 0 && n < size {
+			size = n
+		}
+		buf := make([]byte, size)
+		read, err := src.Read(buf)
+		if read > 0 {
+			emit(buf[:read])
+			if n > 0 {
+				n -= int64(read)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if n > 0 {
+					return io.ErrUnexpectedEOF
+				}
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+package transcode
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"youtube-mini/internal/ui"
+)
+
+const defaultCacheBytes = 1 << 30
+
+// DiskCache keeps finished transcodes on disk so replays skip ffmpeg and can
+// be served with Content-Length and byte ranges. Entries are evicted in
+// least-recently-used order once the size budget is exceeded.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cacheMeta struct {
+	Key         string    `json:"key"`
+	VideoID     string    `json:"video_id"`
+	Profile     Profile   `json:"profile"`
+	ContentType string    `json:"content_type"`
+	FileName    string    `json:"file_name"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+}
+
+type cacheItem struct {
+	meta     cacheMeta
+	path     string
+	lastUsed time.Time
+}
+
+// CacheStats summarises disk cache usage.
+type CacheStats struct {
+	Dir      string
+	Entries  int
+	Bytes    int64
+	MaxBytes int64
+}
+
+// NewDiskCache opens (or creates) dir and indexes any entries already present.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cache dir: %w", err)
+	}
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	c.load()
+	return c, nil
+}
+
+func (c *DiskCache) load() {
+	metas, _ := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	items := make([]*cacheItem, 0, len(metas))
+	for _, metaPath := range metas {
+		raw, err := os.ReadFile(metaPath)
+		if err != nil {
+			continue
+		}
+		var meta cacheMeta
+		if err := json.Unmarshal(raw, &meta); err != nil || meta.Key == "" {
+			continue
+		}
+		dataPath := strings.TrimSuffix(metaPath, ".json") + ".bin"
+		fi, err := os.Stat(dataPath)
+		if err != nil || fi.Size() != meta.Size {
+			_ = os.Remove(metaPath)
+			_ = os.Remove(dataPath)
+			continue
+		}
+		items = append(items, &cacheItem{meta: meta, path: dataPath, lastUsed: fi.ModTime()})
+	}
+	// Leftovers from interrupted encodes.
+	parts, _ := filepath.Glob(filepath.Join(c.dir, "*.part"))
+	for _, p := range parts {
+		_ = os.Remove(p)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].lastUsed.After(items[j].lastUsed) })
+	c.mu.Lock()
+	for _, item := range items {
+		c.entries[item.meta.Key] = c.lru.PushBack(item)
+		c.size += item.meta.Size
+	}
+	c.evictLocked()
+	c.mu.Unlock()
+	if len(items) > 0 {
+		log.Printf("[cache] indexed %d transcodes (%d bytes) in %s", len(items), c.size, c.dir)
+	}
+}
+
+func cacheKey(videoID string, profile Profile, filter string) string {
+	sum := sha1.Sum([]byte(videoID + "|" + string(profile) + "|" + filter))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *DiskCache) basePath(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Serve writes a cached transcode via http.ServeContent and reports whether one was found.
+func (c *DiskCache) Serve(w http.ResponseWriter, r *http.Request, key string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	c.lru.MoveToFront(el)
+	item := el.Value.(*cacheItem)
+	item.lastUsed = time.Now()
+	meta := item.meta
+	path := item.path
+	c.mu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		c.remove(key)
+		return false
+	}
+	defer f.Close()
+	// Persist recency across restarts.
+	_ = os.Chtimes(path, time.Now(), time.Now())
+
+	w.Header().Set("Content-Type", meta.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, ui.Escape(meta.FileName)))
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, key[:16], meta.Size))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, meta.FileName, meta.Created, f)
+	return true
+}
+
+// Purge removes every cached transcode and returns the number of entries dropped.
+func (c *DiskCache) Purge() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	for key, el := range c.entries {
+		c.deleteLocked(key, el)
+	}
+	return n
+}
+
+// Stats reports current usage.
+func (c *DiskCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Dir: c.dir, Entries: len(c.entries), Bytes: c.size, MaxBytes: c.maxBytes}
+}
+
+func (c *DiskCache) remove(key string) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.deleteLocked(key, el)
+	}
+	c.mu.Unlock()
+}
+
+func (c *DiskCache) deleteLocked(key string, el *list.Element) {
+	item := el.Value.(*cacheItem)
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	c.size -= item.meta.Size
+	_ = os.Remove(item.path)
+	_ = os.Remove(c.basePath(key) + ".json")
+}
+
+func (c *DiskCache) evictLocked() {
+	for c.size > c.maxBytes {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+		item := el.Value.(*cacheItem)
+		log.Printf("[cache] evicting %s profile=%s (%d bytes)", item.meta.VideoID, item.meta.Profile, item.meta.Size)
+		c.deleteLocked(item.meta.Key, el)
+	}
+}
+
+// cacheWriter tees a running encode into a temporary file that is only
+// promoted into the cache once ffmpeg exits cleanly.
+type cacheWriter struct {
+	cache *DiskCache
+	meta  cacheMeta
+	file  *os.File
+	err   error
+}
+
+func (c *DiskCache) newWriter(key, videoID string, profile Profile) *cacheWriter {
+	if c == nil {
+		return nil
+	}
+	f, err := os.CreateTemp(c.dir, key+"-*.part")
+	if err != nil {
+		log.Printf("[cache] %v", err)
+		return nil
+	}
+	return &cacheWriter{
+		cache: c,
+		file:  f,
+		meta:  cacheMeta{Key: key, VideoID: videoID, Profile: profile},
+	}
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return len(p), nil
+	}
+	n, err := cw.file.Write(p)
+	cw.meta.Size += int64(n)
+	if err != nil {
+		// A full disk must not break the live stream.
+		cw.err = err
+		log.Printf("[cache] write %s: %v", cw.meta.VideoID, err)
+	}
+	return len(p), nil
+}
+
+func (cw *cacheWriter) abort() {
+	name := cw.file.Name()
+	_ = cw.file.Close()
+	_ = os.Remove(name)
+}
+
+func (cw *cacheWriter) commit(format outputFormat) {
+	if cw.err != nil || cw.meta.Size == 0 || cw.meta.Size > cw.cache.maxBytes {
+		cw.abort()
+		return
+	}
+	name := cw.file.Name()
+	if err := cw.file.Close(); err != nil {
+		_ = os.Remove(name)
+		return
+	}
+	cw.meta.ContentType = format.ContentType
+	cw.meta.FileName = format.FileName(cw.meta.VideoID)
+	cw.meta.Created = time.Now()
+
+	c := cw.cache
+	base := c.basePath(cw.meta.Key)
+	raw, err := json.Marshal(cw.meta)
+	if err != nil {
+		_ = os.Remove(name)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[cw.meta.Key]; ok {
+		c.deleteLocked(cw.meta.Key, el)
+	}
+	if err := os.Rename(name, base+".bin"); err != nil {
+		log.Printf("[cache] %v", err)
+		_ = os.Remove(name)
+		return
+	}
+	if err := os.WriteFile(base+".json", raw, 0o644); err != nil {
+		log.Printf("[cache] %v", err)
+		_ = os.Remove(base + ".bin")
+		return
+	}
+	item := &cacheItem{meta: cw.meta, path: base + ".bin", lastUsed: time.Now()}
+	c.entries[cw.meta.Key] = c.lru.PushFront(item)
+	c.size += cw.meta.Size
+	c.evictLocked()
+	log.Printf("[cache] stored %s profile=%s (%d bytes)", cw.meta.VideoID, cw.meta.Profile, cw.meta.Size)
+}
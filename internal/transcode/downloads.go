@@ -0,0 +1,780 @@
+package transcode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"youtube-mini/internal/ui"
+)
+
+// DownloadStatus is where a download is in its life cycle.
+type DownloadStatus string
+
+const (
+	DownloadQueued  DownloadStatus = "queued"
+	DownloadRunning DownloadStatus = "running"
+	DownloadDone    DownloadStatus = "done"
+	DownloadFailed  DownloadStatus = "failed"
+)
+
+const (
+	// downloadClient is the scheduler client downloads are accounted to, so
+	// they queue behind each other instead of crowding out live viewers.
+	downloadClient = "downloads"
+	// downloadSaveEvery bounds how stale the byte count on disk may get.
+	downloadSaveEvery = 5 * time.Second
+)
+
+var (
+	// ErrDownloadQuota is returned when the library holds its byte quota.
+	ErrDownloadQuota = errors.New("download library is full")
+	// ErrDownloadQueueFull is returned when too many downloads are pending.
+	ErrDownloadQueueFull = errors.New("too many downloads queued")
+)
+
+// DownloadConfig configures EnableDownloads.
+type DownloadConfig struct {
+	// Dir holds the library.
+	Dir string
+	// Workers is how many downloads run at once (at least one).
+	Workers int
+	// QuotaBytes bounds the library on disk, partial files included; a
+	// download that would cross it fails. 0 is unlimited.
+	QuotaBytes int64
+	// MaxPending bounds the queued and running downloads; 0 is unlimited.
+	MaxPending int
+}
+
+// DownloadRequest asks for one video in a direct upstream format (Itag) or
+// transcoded with a profile (Profile).
+type DownloadRequest struct {
+	VideoID string
+	Title   string
+	Itag    string
+	Profile Profile
+	// Duration is the video length in seconds, used for transcode progress.
+	Duration float64
+	// ChannelID and Auto mark videos queued by subscription sync.
+	ChannelID string
+	Auto      bool
+}
+
+// Download is a snapshot of one library entry.
+type Download struct {
+	ID       string  `json:"id"`
+	VideoID  string  `json:"video_id"`
+	Title    string  `json:"title"`
+	Itag     string  `json:"itag,omitempty"`
+	Profile  Profile `json:"profile,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	// ChannelID and Auto are set on videos queued by subscription sync,
+	// which prunes them by its retention rules.
+	ChannelID string         `json:"channel_id,omitempty"`
+	Auto      bool           `json:"auto,omitempty"`
+	Status    DownloadStatus `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	Attempts  int            `json:"attempts"`
+	// Bytes is what has been written so far; Total is the expected size of
+	// direct downloads, 0 when unknown.
+	Bytes int64 `json:"bytes"`
+	Total int64 `json:"total,omitempty"`
+	// Encoded is how many seconds of video a running transcode has produced.
+	Encoded     float64   `json:"encoded,omitempty"`
+	FileName    string    `json:"file_name,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Percent estimates the progress of d, or -1 when it cannot be told.
+func (d Download) Percent() int {
+	switch {
+	case d.Status == DownloadDone:
+		return 100
+	case d.Total > 0:
+		return int(d.Bytes * 100 / d.Total)
+	case d.Profile != "" && d.Duration > 0 && d.Encoded > 0:
+		return min(int(d.Encoded*100/d.Duration), 99)
+	}
+	return -1
+}
+
+// DownloadManager fetches videos to disk in the background. Direct formats
+// resume with a Range request where the last attempt stopped; transcodes
+// run ffmpeg as fast as it can go and start over when retried. Entries
+// survive restarts, and ones that were in flight are picked up again.
+type DownloadManager struct {
+	svc *Service
+	dir string
+	cfg DownloadConfig
+
+	mu      sync.Mutex
+	entries map[string]*downloadEntry
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+type downloadEntry struct {
+	info   Download
+	cancel context.CancelFunc
+	job    *job
+	// removed is set by Delete while the entry is running; the runner then
+	// deletes its files once it stops.
+	removed bool
+	saved   time.Time
+}
+
+// EnableDownloads keeps a download library under cfg.Dir and starts the
+// background workers.
+func (s *Service) EnableDownloads(cfg DownloadConfig) error {
+	if s.resolver == nil {
+		return errors.New("downloads: stream resolver not configured")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return fmt.Errorf("downloads dir: %w", err)
+	}
+	m := &DownloadManager{
+		svc:     s,
+		dir:     cfg.Dir,
+		cfg:     cfg,
+		entries: make(map[string]*downloadEntry),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	m.load()
+	for range max(cfg.Workers, 1) {
+		go m.work()
+	}
+	m.notify()
+	s.downloads = m
+	return nil
+}
+
+// Downloads returns the download library, nil when disabled.
+func (s *Service) Downloads() *DownloadManager {
+	return s.downloads
+}
+
+func (m *DownloadManager) load() {
+	metas, _ := filepath.Glob(filepath.Join(m.dir, "*.json"))
+	for _, metaPath := range metas {
+		raw, err := os.ReadFile(metaPath)
+		if err != nil {
+			continue
+		}
+		var info Download
+		if err := json.Unmarshal(raw, &info); err != nil || info.ID == "" {
+			continue
+		}
+		switch info.Status {
+		case DownloadDone:
+			if fi, err := os.Stat(m.path(info.ID, ".bin")); err != nil || fi.Size() != info.Bytes {
+				info.Status, info.Error = DownloadFailed, "file missing"
+			}
+		case DownloadRunning:
+			info.Status = DownloadQueued
+		}
+		if info.Status != DownloadDone {
+			info.Bytes = 0
+			if fi, err := os.Stat(m.path(info.ID, ".part")); err == nil {
+				info.Bytes = fi.Size()
+			}
+		}
+		m.entries[info.ID] = &downloadEntry{info: info}
+	}
+	if len(m.entries) > 0 {
+		log.Printf("[downloads] indexed %d downloads in %s", len(m.entries), m.dir)
+	}
+}
+
+// Close stops the workers; running downloads are interrupted and resume on
+// the next start.
+func (m *DownloadManager) Close() {
+	if m == nil {
+		return
+	}
+	safeClose(m.done)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.cancel != nil {
+			e.cancel()
+		}
+	}
+}
+
+// Add queues req. Asking again for an entry that exists returns it, and
+// requeues it when it had failed. New and requeued downloads must fit the
+// pending limit and the library quota.
+func (m *DownloadManager) Add(req DownloadRequest) (Download, error) {
+	if !validVideoID(req.VideoID) {
+		return Download{}, fmt.Errorf("invalid video id %q", req.VideoID)
+	}
+	var id string
+	switch {
+	case req.Itag != "" && req.Profile == "":
+		if _, err := strconv.Atoi(req.Itag); err != nil {
+			return Download{}, fmt.Errorf("invalid itag %q", req.Itag)
+		}
+		id = req.VideoID + "_" + req.Itag
+	case req.Profile != "" && req.Itag == "":
+		spec, ok := m.svc.profiles.Lookup(req.Profile)
+		if !ok || !spec.HTTP {
+			return Download{}, fmt.Errorf("unknown profile %q", req.Profile)
+		}
+		id = req.VideoID + "_" + string(req.Profile)
+	default:
+		return Download{}, errors.New("choose either a format or a profile")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.entries[id]; ok {
+		if e.info.Status == DownloadFailed {
+			if err := m.admitLocked(); err != nil {
+				return Download{}, err
+			}
+			m.requeueLocked(e)
+		}
+		return e.info, nil
+	}
+	if err := m.admitLocked(); err != nil {
+		return Download{}, err
+	}
+	now := time.Now()
+	e := &downloadEntry{info: Download{
+		ID:        id,
+		VideoID:   req.VideoID,
+		Title:     req.Title,
+		Itag:      req.Itag,
+		Profile:   req.Profile,
+		Duration:  req.Duration,
+		ChannelID: req.ChannelID,
+		Auto:      req.Auto,
+		Status:    DownloadQueued,
+		Created:   now,
+		Updated:   now,
+	}}
+	m.entries[id] = e
+	m.saveLocked(e)
+	m.notify()
+	return e.info, nil
+}
+
+// List returns every download, newest first.
+func (m *DownloadManager) List() []Download {
+	m.mu.Lock()
+	list := make([]*downloadEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		list = append(list, e)
+	}
+	m.mu.Unlock()
+
+	now := time.Now()
+	out := make([]Download, 0, len(list))
+	for _, e := range list {
+		out = append(out, m.snapshot(e, now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out
+}
+
+// Get returns one download.
+func (m *DownloadManager) Get(id string) (Download, bool) {
+	m.mu.Lock()
+	e, ok := m.entries[id]
+	m.mu.Unlock()
+	if !ok {
+		return Download{}, false
+	}
+	return m.snapshot(e, time.Now()), true
+}
+
+func (m *DownloadManager) snapshot(e *downloadEntry, now time.Time) Download {
+	m.mu.Lock()
+	info, j := e.info, e.job
+	m.mu.Unlock()
+	if j != nil {
+		info.Encoded = j.snapshot(now).Progress.OutTime
+	}
+	return info
+}
+
+// Retry requeues a failed download.
+func (m *DownloadManager) Retry(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if e.info.Status != DownloadFailed {
+		return fmt.Errorf("download is %s", e.info.Status)
+	}
+	if err := m.admitLocked(); err != nil {
+		return err
+	}
+	m.requeueLocked(e)
+	return nil
+}
+
+// admitLocked checks that one more download fits the configured limits.
+func (m *DownloadManager) admitLocked() error {
+	if m.cfg.MaxPending > 0 {
+		pending := 0
+		for _, e := range m.entries {
+			if e.info.Status == DownloadQueued || e.info.Status == DownloadRunning {
+				pending++
+			}
+		}
+		if pending >= m.cfg.MaxPending {
+			return fmt.Errorf("%w: %d waiting, wait for some to finish", ErrDownloadQueueFull, pending)
+		}
+	}
+	if m.overQuotaLocked(0) {
+		return fmt.Errorf("%w: %d MB quota reached, delete something first", ErrDownloadQuota, m.cfg.QuotaBytes>>20)
+	}
+	return nil
+}
+
+// overQuotaLocked reports whether the library plus extra bytes reaches
+// the quota.
+func (m *DownloadManager) overQuotaLocked(extra int64) bool {
+	if m.cfg.QuotaBytes <= 0 {
+		return false
+	}
+	used := extra
+	for _, e := range m.entries {
+		used += e.info.Bytes
+	}
+	return used >= m.cfg.QuotaBytes
+}
+
+func (m *DownloadManager) requeueLocked(e *downloadEntry) {
+	e.info.Status = DownloadQueued
+	e.info.Error = ""
+	e.info.Updated = time.Now()
+	m.saveLocked(e)
+	m.notify()
+}
+
+// Delete stops a download if it is running and removes it with its files.
+func (m *DownloadManager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(m.entries, id)
+	if e.cancel != nil {
+		e.removed = true
+		e.cancel()
+		return nil
+	}
+	m.removeFiles(id)
+	return nil
+}
+
+func (m *DownloadManager) removeFiles(id string) {
+	for _, ext := range []string{".json", ".part", ".bin"} {
+		_ = os.Remove(m.path(id, ext))
+	}
+}
+
+// Serve writes a finished download with Content-Length and byte range
+// support, and reports whether one was found. ?play=1 serves it inline.
+func (m *DownloadManager) Serve(w http.ResponseWriter, r *http.Request, id string) bool {
+	info, ok := m.Get(id)
+	if !ok || info.Status != DownloadDone {
+		return false
+	}
+	f, err := os.Open(m.path(id, ".bin"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", info.ContentType)
+	disposition := "attachment"
+	if r.URL.Query().Get("play") == "1" {
+		// Hand the file to the phone's player instead of saving it.
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`%s; filename="%s"`, disposition, ui.Escape(info.FileName)))
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, id, info.Bytes))
+	http.ServeContent(w, r, info.FileName, info.Updated, f)
+	return true
+}
+
+func (m *DownloadManager) path(id, ext string) string {
+	return filepath.Join(m.dir, id+ext)
+}
+
+func (m *DownloadManager) closed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *DownloadManager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// saveLocked persists e's metadata; the caller holds m.mu.
+func (m *DownloadManager) saveLocked(e *downloadEntry) {
+	raw, err := json.Marshal(e.info)
+	if err != nil {
+		return
+	}
+	tmp := m.path(e.info.ID, ".json.tmp")
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		log.Printf("[downloads] %v", err)
+		return
+	}
+	if err := os.Rename(tmp, m.path(e.info.ID, ".json")); err != nil {
+		log.Printf("[downloads] %v", err)
+	}
+	e.saved = time.Now()
+}
+
+// work runs queued downloads one at a time, oldest first.
+func (m *DownloadManager) work() {
+	for {
+		e, ctx := m.next()
+		if e == nil {
+			select {
+			case <-m.done:
+				return
+			case <-m.wake:
+			}
+			continue
+		}
+		// Let the other workers look for more.
+		m.notify()
+		err := m.run(ctx, e)
+		if wait, busy := m.finish(e, err); busy {
+			select {
+			case <-m.done:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// next claims the oldest queued entry.
+func (m *DownloadManager) next() (*downloadEntry, context.Context) {
+	if m.closed() {
+		return nil, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pick *downloadEntry
+	for _, e := range m.entries {
+		if e.info.Status == DownloadQueued && (pick == nil || e.info.Created.Before(pick.info.Created)) {
+			pick = e
+		}
+	}
+	if pick == nil {
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(WithClient(context.Background(), downloadClient))
+	pick.cancel = cancel
+	pick.info.Status = DownloadRunning
+	pick.info.Attempts++
+	pick.info.Updated = time.Now()
+	m.saveLocked(pick)
+	return pick, ctx
+}
+
+// finish records the outcome of run. A busy scheduler puts the entry back
+// in the queue and tells the worker how long to wait.
+func (m *DownloadManager) finish(e *downloadEntry, err error) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.cancel()
+	e.cancel = nil
+	e.job = nil
+	if e.removed {
+		m.removeFiles(e.info.ID)
+		return 0, false
+	}
+	e.info.Updated = time.Now()
+	var busy *BusyError
+	switch {
+	case errors.As(err, &busy):
+		e.info.Status = DownloadQueued
+		e.info.Attempts--
+		m.saveLocked(e)
+		return busy.RetryAfter, true
+	case err != nil && m.closed():
+		// Interrupted by Close: resume on the next start.
+		e.info.Status = DownloadQueued
+	case err != nil:
+		e.info.Status = DownloadFailed
+		e.info.Error = err.Error()
+		log.Printf("[downloads] %s failed: %v", e.info.ID, err)
+	default:
+		e.info.Status = DownloadDone
+		e.info.Error = ""
+		log.Printf("[downloads] %s done (%d bytes)", e.info.ID, e.info.Bytes)
+	}
+	m.saveLocked(e)
+	return 0, false
+}
+
+func (m *DownloadManager) run(ctx context.Context, e *downloadEntry) error {
+	m.mu.Lock()
+	info := e.info
+	m.mu.Unlock()
+
+	sources, err := m.svc.resolver.ResolveSources(ctx, info.VideoID)
+	if err != nil {
+		return fmt.Errorf("resolve: %w", err)
+	}
+	var format outputFormat
+	if info.Itag != "" {
+		format, err = m.fetch(ctx, e, sources)
+	} else {
+		format, err = m.transcode(ctx, e, sources)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(m.path(info.ID, ".part"), m.path(info.ID, ".bin")); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	e.info.FileName = format.FileName(info.VideoID)
+	e.info.ContentType = format.ContentType
+	m.mu.Unlock()
+	return nil
+}
+
+// fetch copies a direct upstream format, continuing a partial file.
+func (m *DownloadManager) fetch(ctx context.Context, e *downloadEntry, sources []SourceFormat) (outputFormat, error) {
+	var src SourceFormat
+	for _, f := range sources {
+		if f.Itag == e.info.Itag {
+			src = f
+		}
+	}
+	if src.URL == "" {
+		return outputFormat{}, fmt.Errorf("format %s not available", e.info.Itag)
+	}
+
+	f, err := os.OpenFile(m.path(e.info.ID, ".part"), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return outputFormat{}, err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return outputFormat{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return outputFormat{}, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Referer", defaultReferer)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := m.svc.client.Do(req)
+	if err != nil {
+		return outputFormat{}, err
+	}
+	defer resp.Body.Close()
+
+	var total int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		total = contentRangeTotal(resp.Header.Get("Content-Range"))
+	case http.StatusOK:
+		// No resume upstream: start over.
+		if err := f.Truncate(0); err != nil {
+			return outputFormat{}, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return outputFormat{}, err
+		}
+		offset = 0
+		if resp.ContentLength > 0 {
+			total = resp.ContentLength
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		if total = contentRangeTotal(resp.Header.Get("Content-Range")); offset > 0 && total == offset {
+			return directFormat(src), nil
+		}
+		return outputFormat{}, fmt.Errorf("upstream: %s", resp.Status)
+	default:
+		return outputFormat{}, fmt.Errorf("upstream: %s", resp.Status)
+	}
+
+	m.mu.Lock()
+	e.info.Bytes, e.info.Total = offset, total
+	full := total > 0 && m.overQuotaLocked(total-offset)
+	m.mu.Unlock()
+	if full {
+		return outputFormat{}, ErrDownloadQuota
+	}
+	if _, err := io.Copy(&downloadWriter{m: m, e: e, w: f}, resp.Body); err != nil {
+		return outputFormat{}, err
+	}
+	m.mu.Lock()
+	written := e.info.Bytes
+	m.mu.Unlock()
+	if total > 0 && written != total {
+		return outputFormat{}, fmt.Errorf("short download: %d of %d bytes", written, total)
+	}
+	return directFormat(src), nil
+}
+
+// transcode encodes the whole video with the entry's profile.
+func (m *DownloadManager) transcode(ctx context.Context, e *downloadEntry, sources []SourceFormat) (outputFormat, error) {
+	s := m.svc
+	spec, ok := s.profiles.Lookup(e.info.Profile)
+	if !ok || !spec.HTTP {
+		return outputFormat{}, fmt.Errorf("unknown profile %q", e.info.Profile)
+	}
+	src, err := pickSource(sources, spec.sourceNeeds())
+	if err != nil {
+		return outputFormat{}, err
+	}
+	input, cleanup, err := s.buildInputPaced(src, 0, false)
+	if err != nil {
+		return outputFormat{}, err
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+	f, err := os.Create(m.path(e.info.ID, ".part"))
+	if err != nil {
+		return outputFormat{}, err
+	}
+	defer f.Close()
+	m.mu.Lock()
+	e.info.Bytes, e.info.Total = 0, 0
+	m.mu.Unlock()
+
+	args := httpArgs(spec, input, s.retroFilter)
+	out := &downloadWriter{m: m, e: e, w: f}
+	proc, release, err := s.startScheduled(ctx, ctx, Command{Args: args, Stdin: input.pipe, Stdout: out}, spec.Name, downloadClient)
+	if err != nil {
+		return outputFormat{}, err
+	}
+	defer release()
+	if stdin := proc.Stdin(); stdin != nil {
+		s.startInputPump(ctx, stdin, input.srcURL)
+	}
+	j := s.jobs.add(JobDownload, e.info.VideoID, spec.Name, downloadClient, 0)
+	m.mu.Lock()
+	e.job = j
+	m.mu.Unlock()
+	go logFFmpeg(proc.Stderr(), "[ffmpeg download]", j)
+
+	err = proc.Wait()
+	s.jobs.done(j, err != nil && ctx.Err() == nil)
+	if ctx.Err() != nil {
+		return outputFormat{}, ctx.Err()
+	}
+	if errors.Is(out.err, ErrDownloadQuota) {
+		return outputFormat{}, ErrDownloadQuota
+	}
+	if err != nil {
+		return outputFormat{}, fmt.Errorf("ffmpeg: %w", err)
+	}
+	return spec.Format(), nil
+}
+
+// downloadWriter counts bytes into the entry as they reach the file, and
+// stops the download once the library reaches its quota.
+type downloadWriter struct {
+	m *DownloadManager
+	e *downloadEntry
+	w io.Writer
+	// err is the first write error, kept for ffmpeg runs that only report
+	// a broken pipe.
+	err error
+}
+
+func (d *downloadWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.m.mu.Lock()
+	d.e.info.Bytes += int64(n)
+	if err == nil && d.m.overQuotaLocked(0) {
+		err = ErrDownloadQuota
+	}
+	if time.Since(d.e.saved) > downloadSaveEvery {
+		d.e.info.Updated = time.Now()
+		d.m.saveLocked(d.e)
+	}
+	d.m.mu.Unlock()
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+	return n, err
+}
+
+// directFormat names an upstream rendition after its MIME type.
+func directFormat(f SourceFormat) outputFormat {
+	contentType, _, _ := strings.Cut(f.Mime, ";")
+	contentType = strings.TrimSpace(contentType)
+	ext := ""
+	switch contentType {
+	case "":
+		contentType, ext = "video/mp4", "mp4"
+	case "video/3gpp":
+		ext = "3gp"
+	case "audio/mp4":
+		ext = "m4a"
+	default:
+		_, ext, _ = strings.Cut(contentType, "/")
+	}
+	return outputFormat{ContentType: contentType, Extension: ext, Suffix: "_" + f.Itag}
+}
+
+// contentRangeTotal parses the size from "bytes 0-99/1234" or "bytes */1234".
+func contentRangeTotal(header string) int64 {
+	_, size, ok := strings.Cut(header, "/")
+	if !ok {
+		return 0
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return total
+}
+
+func validVideoID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,550 @@
+package transcode
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/headers"
+	"github.com/pion/rtp"
+)
+
+const (
+	testVideoID  = "dQw4w9WgXcQ"
+	testUpstream = "http://upstream.invalid/videoplayback?itag=18"
+)
+
+var testSources = []SourceFormat{{URL: testUpstream, Itag: "18", Width: 640, Height: 360}}
+
+// fakeFFmpeg builds cmd/fakeffmpeg and points FAKEFFMPEG_LOG at a file in
+// the same temporary directory.
+func fakeFFmpeg(t *testing.T) (bin, logPath string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds cmd/fakeffmpeg")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not in PATH")
+	}
+	dir := t.TempDir()
+	bin = filepath.Join(dir, "ffmpeg")
+	out, err := exec.Command(goBin, "build", "-o", bin, "youtube-mini/cmd/fakeffmpeg").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build ./cmd/fakeffmpeg: %v\n%s", err, out)
+	}
+	logPath = filepath.Join(dir, "ffmpeg.log")
+	t.Setenv("FAKEFFMPEG_LOG", logPath)
+	t.Setenv("FAKEFFMPEG_DURATION", "0.3")
+	t.Setenv("FAKEFFMPEG_EXIT", "")
+	t.Setenv("FAKEFFMPEG_FAIL", "")
+	return bin, logPath
+}
+
+// invocations returns the command lines the fake has logged so far.
+func invocations(t *testing.T, logPath string) [][]string {
+	t.Helper()
+	f, err := os.Open(logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var args []string
+		if err := json.Unmarshal(scanner.Bytes(), &args); err != nil {
+			t.Fatalf("log line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, args)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+// streamServer serves svc.Stream for ?profile= and ?start= over testSources.
+func streamServer(t *testing.T, svc *Service) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseFloat(r.URL.Query().Get("start"), 64)
+		profile := Profile(r.URL.Query().Get("profile"))
+		if err := svc.Stream(r.Context(), w, testSources, testVideoID, profile, start); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFakeFFmpegHTTPArgs(t *testing.T) {
+	const filter = "eq=contrast=1.2"
+	bin, logPath := fakeFFmpeg(t)
+	svc := New().WithCommand(bin).WithRetroFilter(filter)
+	srv := streamServer(t, svc)
+
+	type request struct {
+		profile Profile
+		start   float64
+	}
+	var requests []request
+	for _, spec := range svc.Profiles().All() {
+		if spec.HTTP {
+			requests = append(requests, request{profile: spec.Name})
+		}
+	}
+	requests = append(requests, request{ProfileRetro, 12.5}, request{ProfileAudioMP3, 90})
+
+	for _, req := range requests {
+		t.Run(string(req.profile)+"@"+formatSeek(req.start), func(t *testing.T) {
+			before := len(invocations(t, logPath))
+			resp, err := http.Get(srv.URL + "/?profile=" + string(req.profile) + "&start=" + formatSeek(req.start))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec, _ := svc.Profiles().Lookup(req.profile)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d: %s", resp.StatusCode, body)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != spec.ContentType {
+				t.Errorf("Content-Type = %q, want %q", ct, spec.ContentType)
+			}
+			if len(body) == 0 {
+				t.Error("empty body")
+			}
+
+			lines := invocations(t, logPath)
+			if len(lines) != before+1 {
+				t.Fatalf("%d ffmpeg runs, want 1", len(lines)-before)
+			}
+			got := lines[len(lines)-1]
+			input, _, err := svc.buildInput(Source{URL: testUpstream}, req.start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _, err := svc.profileArgs(req.profile, input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("argv:\n got %q\nwant %q", got, want)
+			}
+			if got[len(got)-1] != "pipe:1" {
+				t.Errorf("output %q, want pipe:1", got[len(got)-1])
+			}
+
+			// A seek goes on the input, ahead of -i, so ffmpeg skips
+			// demuxing the part before it.
+			seek := slices.Index(got, "-ss")
+			if req.start == 0 {
+				if seek >= 0 {
+					t.Errorf("-ss without a start offset: %q", got)
+				}
+				return
+			}
+			if seek < 0 || seek+1 >= len(got) || got[seek+1] != formatSeek(req.start) {
+				t.Fatalf("-ss %s missing: %q", formatSeek(req.start), got)
+			}
+			if in := slices.Index(got, "-i"); in < seek {
+				t.Errorf("-ss after -i: %q", got)
+			}
+		})
+	}
+}
+
+// TestFakeFFmpegSharedStream joins a second reader to a running fragmented
+// encode: it must get the container header and then pick up at a fragment
+// boundary, without a second ffmpeg.
+func TestFakeFFmpegSharedStream(t *testing.T) {
+	bin, logPath := fakeFFmpeg(t)
+	t.Setenv("FAKEFFMPEG_DURATION", "1.5")
+	svc := New().WithCommand(bin)
+	srv := streamServer(t, svc)
+	url := srv.URL + "/?profile=" + string(ProfileRetro)
+
+	first, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Body.Close()
+	// Read past the header and the first fragment before joining.
+	var firstBody bytes.Buffer
+	for _, want := range []string{"ftyp", "moov", "moof"} {
+		if kind, err := copyBox(&firstBody, first.Body); err != nil || kind != want {
+			t.Fatalf("first reader: box %q (%v), want %q", kind, err, want)
+		}
+	}
+
+	second, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondBody, err := io.ReadAll(second.Body)
+	second.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(&firstBody, first.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(invocations(t, logPath)); n != 1 {
+		t.Fatalf("%d ffmpeg runs for two readers, want 1", n)
+	}
+	boxes := boxTypes(secondBody)
+	if len(boxes) < 3 || !slices.Equal(boxes[:3], []string{"ftyp", "moov", "moof"}) {
+		t.Fatalf("second reader boxes %q, want ftyp, moov, moof...", boxes)
+	}
+	header := firstBody.Bytes()[:boxEnd(firstBody.Bytes(), 2)]
+	if !bytes.HasPrefix(secondBody, header) {
+		t.Error("second reader did not get the first reader's header")
+	}
+	if len(secondBody) >= firstBody.Len() {
+		t.Errorf("second reader got %d bytes, first %d: late joiner replayed fragments", len(secondBody), firstBody.Len())
+	}
+	if !bytes.HasSuffix(firstBody.Bytes(), secondBody[len(header):]) {
+		t.Error("second reader's fragments are not the tail of the first reader's")
+	}
+}
+
+// copyBox copies one top-level ISO BMFF box from src to dst.
+func copyBox(dst io.Writer, src io.Reader) (string, error) {
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(src, head); err != nil {
+		return "", err
+	}
+	size := int64(binary.BigEndian.Uint32(head))
+	if _, err := dst.Write(head); err != nil {
+		return "", err
+	}
+	_, err := io.CopyN(dst, src, size-8)
+	return string(head[4:]), err
+}
+
+// boxTypes lists the top-level boxes in b.
+func boxTypes(b []byte) []string {
+	var types []string
+	for i := 0; i+8 <= len(b); i += int(binary.BigEndian.Uint32(b[i:])) {
+		types = append(types, string(b[i+4:i+8]))
+		if binary.BigEndian.Uint32(b[i:]) < 8 {
+			break
+		}
+	}
+	return types
+}
+
+// boxEnd is the offset just past the first n top-level boxes of b.
+func boxEnd(b []byte, n int) int {
+	end := 0
+	for range n {
+		end += int(binary.BigEndian.Uint32(b[end:]))
+	}
+	return end
+}
+
+// rtspService starts the RTSP server on a free loopback port with two
+// scheduler slots, publishing over TCP.
+func rtspService(t *testing.T, bin string) *Service {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	svc := New().
+		WithCommand(bin).
+		WithRTSPTransport("tcp").
+		WithRTSPIdleTimeout(100 * time.Millisecond).
+		WithScheduler(SchedulerConfig{MaxConcurrent: 2}).
+		WithStreamResolver(StreamResolverFunc(func(ctx context.Context, videoID string) ([]SourceFormat, error) {
+			return testSources, nil
+		}))
+	// TCP only, so parallel test binaries do not fight over the UDP ports.
+	svc.udpRTPAddr, svc.udpRTCPAddr = "", ""
+	if err := svc.EnableRTSP(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(svc.rtsp.close)
+	return svc
+}
+
+// readerURL is the loopback reader URL of profile with query appended.
+func readerURL(svc *Service, profile Profile, query string) string {
+	u := svc.RTSPURL("127.0.0.1", profile, testVideoID)
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// playRTSP plays rawURL like a handset would. The returned channel gets a
+// value for every RTP packet received; err is the DESCRIBE failure.
+func playRTSP(t *testing.T, rawURL string) (*gortsplib.Client, <-chan struct{}, error) {
+	t.Helper()
+	u, err := base.ParseURL(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := gortsplib.TransportTCP
+	c := &gortsplib.Client{Transport: &transport}
+	if err := c.Start(u.Scheme, u.Host); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	desc, _, err := c.Describe(u)
+	if err != nil {
+		return c, nil, err
+	}
+	if err := c.SetupAll(desc.BaseURL, desc.Medias); err != nil {
+		t.Fatal(err)
+	}
+	packets := make(chan struct{}, 1)
+	c.OnPacketRTPAny(func(*description.Media, format.Format, *rtp.Packet) {
+		select {
+		case packets <- struct{}{}:
+		default:
+		}
+	})
+	if _, err := c.Play(nil); err != nil {
+		t.Fatal(err)
+	}
+	return c, packets, nil
+}
+
+// waitRTSPIdle waits until no publisher runs and the scheduler slot is back.
+func waitRTSPIdle(t *testing.T, svc *Service) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		running, _ := svc.Scheduler().Stats()
+		svc.rtsp.mu.Lock()
+		timelines := len(svc.rtsp.timelines)
+		svc.rtsp.mu.Unlock()
+		if running == 0 && timelines == 0 && svc.JobStats().Active == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still busy: %d slots, %d timelines, %d jobs", running, timelines, svc.JobStats().Active)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// timelineOffsets lists the start offsets of the running timelines.
+func timelineOffsets(svc *Service) []float64 {
+	svc.rtsp.mu.Lock()
+	defer svc.rtsp.mu.Unlock()
+	var offsets []float64
+	for _, stream := range svc.rtsp.timelines {
+		offsets = append(offsets, stream.startOffset)
+	}
+	slices.Sort(offsets)
+	return offsets
+}
+
+// waitTimelines waits until the running timelines start at want.
+func waitTimelines(t *testing.T, svc *Service, want ...float64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Equal(timelineOffsets(svc), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timelines start at %v, want %v", timelineOffsets(svc), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkRTSPArgs compares a logged publisher command line with what the
+// service builds for profile at start.
+func checkRTSPArgs(t *testing.T, svc *Service, got []string, profile Profile, start float64) {
+	t.Helper()
+	loopback := "rtsp://" + svc.rtsp.loopbackAddress() + "/"
+	target := got[len(got)-1]
+	if !strings.HasPrefix(target, loopback) || !strings.Contains(target, rtspPublisherParam+"=") {
+		t.Errorf("publish target %q, want a publisher URL under %s", target, loopback)
+	}
+	input, _, err := svc.buildInput(Source{URL: testUpstream}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := svc.profileRTSPArgs(profile, input, target, "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("argv:\n got %q\nwant %q", got, want)
+	}
+}
+
+func waitPacket(t *testing.T, packets <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-packets:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no RTP packet received")
+	}
+}
+
+func TestFakeFFmpegRTSP(t *testing.T) {
+	bin, logPath := fakeFFmpeg(t)
+	t.Setenv("FAKEFFMPEG_DURATION", "1")
+	svc := rtspService(t, bin)
+
+	for _, spec := range svc.Profiles().All() {
+		if !spec.RTSP {
+			continue
+		}
+		t.Run("publish "+string(spec.Name), func(t *testing.T) {
+			before := len(invocations(t, logPath))
+			_, packets, err := playRTSP(t, readerURL(svc, spec.Name, ""))
+			if err != nil {
+				t.Fatalf("DESCRIBE: %v", err)
+			}
+			waitPacket(t, packets)
+
+			lines := invocations(t, logPath)
+			if len(lines) != before+1 {
+				t.Fatalf("%d ffmpeg runs, want 1", len(lines)-before)
+			}
+			checkRTSPArgs(t, svc, lines[len(lines)-1], spec.Name, 0)
+			// The publisher ends on its own after FAKEFFMPEG_DURATION.
+			waitRTSPIdle(t, svc)
+		})
+	}
+
+	t.Run("reader teardown stops the publisher", func(t *testing.T) {
+		t.Setenv("FAKEFFMPEG_DURATION", "60")
+		c, packets, err := playRTSP(t, readerURL(svc, ProfileRetro, ""))
+		if err != nil {
+			t.Fatalf("DESCRIBE: %v", err)
+		}
+		waitPacket(t, packets)
+		c.Close()
+		waitRTSPIdle(t, svc)
+	})
+
+	t.Run("readers on one offset share a publisher, others get their own", func(t *testing.T) {
+		t.Setenv("FAKEFFMPEG_DURATION", "60")
+		before := len(invocations(t, logPath))
+		var clients []*gortsplib.Client
+		for _, query := range []string{"start=30", "start=30", "start=300"} {
+			c, packets, err := playRTSP(t, readerURL(svc, ProfileRetro, query))
+			if err != nil {
+				t.Fatalf("DESCRIBE %s: %v", query, err)
+			}
+			waitPacket(t, packets)
+			clients = append(clients, c)
+		}
+		lines := invocations(t, logPath)[before:]
+		if len(lines) != 2 {
+			t.Fatalf("%d ffmpeg runs for three readers on two offsets, want 2", len(lines))
+		}
+		checkRTSPArgs(t, svc, lines[0], ProfileRetro, 30)
+		checkRTSPArgs(t, svc, lines[1], ProfileRetro, 300)
+		waitTimelines(t, svc, 30, 300)
+
+		// The 30s timeline keeps running while one of its readers is left.
+		clients[0].Close()
+		clients[2].Close()
+		waitTimelines(t, svc, 30)
+		clients[1].Close()
+		waitRTSPIdle(t, svc)
+	})
+
+	t.Run("PLAY with an npt range moves the reader to a new timeline", func(t *testing.T) {
+		t.Setenv("FAKEFFMPEG_DURATION", "60")
+		before := len(invocations(t, logPath))
+		c, packets, err := playRTSP(t, readerURL(svc, ProfileEdge, ""))
+		if err != nil {
+			t.Fatalf("DESCRIBE: %v", err)
+		}
+		waitPacket(t, packets)
+		if _, err := c.Pause(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Play(&headers.Range{Value: &headers.RangeNPT{Start: 90 * time.Second}}); err != nil {
+			t.Fatalf("PLAY Range npt=90-: %v", err)
+		}
+		// Drop what the first timeline sent before the seek.
+		select {
+		case <-packets:
+		default:
+		}
+		waitPacket(t, packets)
+
+		lines := invocations(t, logPath)[before:]
+		if len(lines) != 2 {
+			t.Fatalf("%d ffmpeg runs, want 2", len(lines))
+		}
+		checkRTSPArgs(t, svc, lines[1], ProfileEdge, 90)
+		// The timeline left behind is reaped once idle.
+		waitTimelines(t, svc, 90)
+		c.Close()
+		waitRTSPIdle(t, svc)
+	})
+
+	t.Run("publisher exit closes the readers", func(t *testing.T) {
+		t.Setenv("FAKEFFMPEG_EXIT", "3")
+		c, packets, err := playRTSP(t, readerURL(svc, ProfileEdge, ""))
+		if err != nil {
+			t.Fatalf("DESCRIBE: %v", err)
+		}
+		waitPacket(t, packets)
+		done := make(chan error, 1)
+		go func() { done <- c.Wait() }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("reader still open after ffmpeg exited")
+		}
+		waitRTSPIdle(t, svc)
+	})
+
+	t.Run("publisher failure fails DESCRIBE", func(t *testing.T) {
+		t.Setenv("FAKEFFMPEG_FAIL", "1")
+		failed := svc.JobStats().Failed
+		start := time.Now()
+		_, _, err := playRTSP(t, readerURL(svc, ProfileAndroid, ""))
+		if err == nil {
+			t.Fatal("DESCRIBE succeeded without a publisher")
+		}
+		if elapsed := time.Since(start); elapsed >= rtspPublisherTimeout/2 {
+			t.Errorf("DESCRIBE took %v; it should not wait out the publisher timeout", elapsed)
+		}
+		if got := svc.JobStats().Failed; got != failed+1 {
+			t.Errorf("failed jobs = %d, want %d", got, failed+1)
+		}
+		waitRTSPIdle(t, svc)
+	})
+}
@@ -0,0 +1,498 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hlsSegmentSeconds = 6
+	hlsReadyTimeout   = 25 * time.Second
+	defaultHLSIdle    = 90 * time.Second
+	hlsPlaylistName   = "index.m3u8"
+)
+
+// HLSVariant is one rendition of the HLS ladder. Every variant is encoded as
+// H.264 Baseline + AAC-LC in MPEG-TS so iOS 3+ and Android 2.x/4.x can play it.
+type HLSVariant struct {
+	Name         string
+	Height       int
+	FPS          int
+	VideoBitrate int // kbit/s
+	AudioBitrate int // kbit/s
+	Level        string
+}
+
+// Bandwidth returns the BANDWIDTH attribute for the master playlist (bit/s).
+func (v HLSVariant) Bandwidth() int {
+	// Leave ~10% headroom for MPEG-TS overhead.
+	return (v.VideoBitrate + v.AudioBitrate) * 1100
+}
+
+// DefaultHLSVariants is the ladder used unless WithHLSVariants overrides it.
+var DefaultHLSVariants = []HLSVariant{
+	{Name: "144p", Height: 144, FPS: 12, VideoBitrate: 96, AudioBitrate: 32, Level: "1.3"},
+	{Name: "240p", Height: 240, FPS: 15, VideoBitrate: 256, AudioBitrate: 48, Level: "3.0"},
+	{Name: "360p", Height: 360, FPS: 25, VideoBitrate: 512, AudioBitrate: 64, Level: "3.0"},
+}
+
+type hlsManager struct {
+	svc      *Service
+	variants []HLSVariant
+	idle     time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*hlsSession
+	janitor  bool
+}
+
+type hlsSession struct {
+	key     string
+	videoID string
+	variant HLSVariant
+	start   float64
+	dir     string
+	ready   chan struct{}
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	cancel   context.CancelFunc
+	release  func()
+	cleanup  func()
+	err      error
+	done     bool
+}
+
+func newHLSManager(svc *Service) *hlsManager {
+	return &hlsManager{
+		svc:      svc,
+		variants: DefaultHLSVariants,
+		idle:     defaultHLSIdle,
+		sessions: make(map[string]*hlsSession),
+	}
+}
+
+// WithHLSVariants overrides the HLS bitrate ladder.
+func (s *Service) WithHLSVariants(variants []HLSVariant) *Service {
+	if len(variants) > 0 {
+		s.hls.variants = variants
+	}
+	return s
+}
+
+// WithHLSIdleTimeout sets how long an HLS session may go unrequested before its
+// ffmpeg process is stopped and its segments are deleted.
+func (s *Service) WithHLSIdleTimeout(d time.Duration) *Service {
+	if d > 0 {
+		s.hls.idle = d
+	}
+	return s
+}
+
+// HLSURL returns the master playlist path for videoID.
+func (s *Service) HLSURL(videoID string) string {
+	return fmt.Sprintf("/stream/hls/%s/%s", videoID, hlsPlaylistName)
+}
+
+// ServeHLS answers requests below /stream/hls/<videoID>/. rest is either
+// "index.m3u8" (master playlist, ?start= seeks), "<variant>/index.m3u8" or
+// "<variant>/<segment>.ts". A seeked session's variant directory carries the
+// offset, "<variant>@<seconds>/", so the relative segment URIs ffmpeg writes
+// resolve to the same session.
+func (s *Service) ServeHLS(w http.ResponseWriter, r *http.Request, videoID, rest string) {
+	m := s.hls
+	rest = strings.Trim(rest, "/")
+
+	if rest == hlsPlaylistName || rest == "" {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = io.WriteString(w, m.masterPlaylist(r.URL.RawQuery))
+		return
+	}
+
+	dir, file, ok := strings.Cut(rest, "/")
+	if !ok || file == "" || strings.Contains(file, "/") || strings.Contains(file, "..") {
+		http.NotFound(w, r)
+		return
+	}
+	variantName, start, ok := parseVariantDir(dir)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	variant, ok := m.variant(variantName)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if file == hlsPlaylistName {
+		ctx := WithClient(r.Context(), r.RemoteAddr)
+		sess, err := m.session(ctx, videoID, variant, start)
+		if err != nil {
+			var busy *BusyError
+			if errors.As(err, &busy) {
+				w.Header().Set("Retry-After", busy.RetryAfterSeconds())
+				http.Error(w, "transcoder busy, try again shortly", http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFile(w, r, sess.path(hlsPlaylistName))
+		return
+	}
+
+	if !strings.HasSuffix(file, ".ts") {
+		http.NotFound(w, r)
+		return
+	}
+	sess := m.lookup(hlsSessionKey(videoID, variant.Name, start))
+	if sess == nil {
+		http.Error(w, "hls session expired", http.StatusNotFound)
+		return
+	}
+	path := sess.path(file)
+	if path == "" {
+		http.NotFound(w, r)
+		return
+	}
+	sess.touch()
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeFile(w, r, path)
+}
+
+func hlsSessionKey(videoID, variant string, start float64) string {
+	return fmt.Sprintf("%s|%s|%d", videoID, variant, int64(math.Round(start)))
+}
+
+func (m *hlsManager) variant(name string) (HLSVariant, bool) {
+	for _, v := range m.variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return HLSVariant{}, false
+}
+
+// parseVariantDir splits "<variant>" or "<variant>@<seconds>".
+func parseVariantDir(dir string) (string, float64, bool) {
+	name, offset, seeked := strings.Cut(dir, "@")
+	if !seeked {
+		return name, 0, true
+	}
+	seconds, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil || seconds < 0 {
+		return "", 0, false
+	}
+	return name, float64(seconds), true
+}
+
+func (m *hlsManager) masterPlaylist(rawQuery string) string {
+	suffix := ""
+	if start := parseStartFromQuery(rawQuery); start > 0 {
+		suffix = fmt.Sprintf("@%d", int64(math.Round(start)))
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, v := range m.variants {
+		width := (v.Height*16/9 + 1) &^ 1
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:PROGRAM-ID=1,BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"avc1.42001e,mp4a.40.2\"\n", v.Bandwidth(), width, v.Height)
+		fmt.Fprintf(&b, "%s%s/%s\n", v.Name, suffix, hlsPlaylistName)
+	}
+	return b.String()
+}
+
+func (m *hlsManager) lookup(key string) *hlsSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[key]
+}
+
+// session returns a running session for the variant, starting ffmpeg if needed,
+// and waits until the first segment has been written.
+func (m *hlsManager) session(ctx context.Context, videoID string, variant HLSVariant, start float64) (*hlsSession, error) {
+	key := hlsSessionKey(videoID, variant.Name, start)
+	m.mu.Lock()
+	sess, ok := m.sessions[key]
+	if !ok {
+		sess = &hlsSession{
+			key:      key,
+			videoID:  videoID,
+			variant:  variant,
+			start:    start,
+			ready:    make(chan struct{}),
+			lastSeen: time.Now(),
+		}
+		m.sessions[key] = sess
+		if !m.janitor {
+			m.janitor = true
+			go m.reap()
+		}
+	}
+	m.mu.Unlock()
+
+	sess.touch()
+	if !ok {
+		if err := m.launch(ctx, sess); err != nil {
+			m.stop(sess, err)
+			return nil, err
+		}
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, hlsReadyTimeout)
+	defer cancel()
+	select {
+	case <-sess.ready:
+	case <-waitCtx.Done():
+		return nil, fmt.Errorf("hls: first segment not ready: %w", waitCtx.Err())
+	}
+	sess.mu.Lock()
+	err := sess.err
+	sess.mu.Unlock()
+	if err != nil {
+		// Drop the failed session so the next request retries from scratch.
+		m.stop(sess, nil)
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (m *hlsManager) launch(ctx context.Context, sess *hlsSession) error {
+	release, err := m.svc.scheduler.Acquire(ctx, Profile("hls-"+sess.variant.Name), clientFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	sess.mu.Lock()
+	sess.release = release
+	sess.mu.Unlock()
+
+	resolveCtx, resolveCancel := context.WithTimeout(ctx, rtspResolveTimeout)
+	src, err := m.svc.resolveSource(resolveCtx, sess.videoID, sess.variant.sourceNeeds())
+	resolveCancel()
+	if err != nil {
+		return fmt.Errorf("hls: resolve stream: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "ytm-hls-")
+	if err != nil {
+		return fmt.Errorf("hls: temp dir: %w", err)
+	}
+	sess.mu.Lock()
+	sess.dir = dir
+	sess.mu.Unlock()
+
+	input, cleanup, err := m.svc.buildInput(src, sess.start)
+	if err != nil {
+		return err
+	}
+	sess.mu.Lock()
+	sess.cleanup = cleanup
+	sess.mu.Unlock()
+
+	encodeCtx, cancel := context.WithCancel(context.Background())
+	sess.mu.Lock()
+	sess.cancel = cancel
+	sess.mu.Unlock()
+
+	args := append([]string{}, input.args...)
+	if input.pipe && input.postSeek {
+		args = append(args, "-ss", formatSeek(input.start))
+	}
+	args = append(args, input.mapArgs(true, true)...)
+	args = append(args, hlsArgs(sess.variant, dir)...)
+
+	// Segments are written to dir, so this never leaves the machine.
+	proc, err := LocalBackend{Command: m.svc.command}.Start(encodeCtx, Command{Args: args, Stdin: input.pipe})
+	if err != nil {
+		return err
+	}
+	if stdin := proc.Stdin(); stdin != nil {
+		m.svc.startInputPump(encodeCtx, stdin, input.srcURL)
+	}
+	log.Printf("[hls] started id=%s variant=%s dir=%s", sess.videoID, sess.variant.Name, dir)
+
+	job := m.svc.jobs.add(JobHLS, sess.videoID, Profile("hls-"+sess.variant.Name), clientFromContext(ctx), sess.start)
+	go logFFmpeg(proc.Stderr(), "[ffmpeg hls]", job)
+	go m.watchReady(encodeCtx, sess)
+	go func() {
+		err := proc.Wait()
+		m.svc.jobs.done(job, err != nil && encodeCtx.Err() == nil)
+		if err != nil && encodeCtx.Err() == nil {
+			log.Printf("[hls] ffmpeg wait id=%s variant=%s: %v", sess.videoID, sess.variant.Name, err)
+			sess.setErr(fmt.Errorf("ffmpeg: %w", err))
+		}
+		// Segments stay servable after a clean finish until the session goes idle.
+		sess.mu.Lock()
+		release := sess.release
+		sess.release = nil
+		sess.mu.Unlock()
+		if release != nil {
+			release()
+		}
+		safeClose(sess.ready)
+	}()
+	return nil
+}
+
+func (v HLSVariant) sourceNeeds() sourceNeeds {
+	return sourceNeeds{video: true, audio: true, height: v.Height, audioBitrate: float64(v.AudioBitrate * 1000)}
+}
+
+func hlsArgs(v HLSVariant, dir string) []string {
+	fps := v.FPS
+	if fps <= 0 {
+		fps = 15
+	}
+	level := v.Level
+	if level == "" {
+		level = "3.0"
+	}
+	audioRate := "44100"
+	if v.AudioBitrate < 48 {
+		audioRate = "22050"
+	}
+	return []string{
+		"-vf", fmt.Sprintf("scale=-2:%d,fps=%d", v.Height, fps),
+		"-c:v", "libx264",
+		"-profile:v", "baseline",
+		"-level", level,
+		"-pix_fmt", "yuv420p",
+		"-preset", "veryfast",
+		"-b:v", fmt.Sprintf("%dk", v.VideoBitrate),
+		"-maxrate", fmt.Sprintf("%dk", v.VideoBitrate*12/10),
+		"-bufsize", fmt.Sprintf("%dk", v.VideoBitrate*2),
+		"-g", strconv.Itoa(fps * hlsSegmentSeconds),
+		"-keyint_min", strconv.Itoa(fps * hlsSegmentSeconds),
+		"-sc_threshold", "0",
+		"-c:a", "aac",
+		"-ar", audioRate,
+		"-ac", "2",
+		"-b:a", fmt.Sprintf("%dk", v.AudioBitrate),
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(hlsSegmentSeconds),
+		"-hls_list_size", "0",
+		"-hls_playlist_type", "event",
+		"-hls_segment_filename", filepath.Join(dir, "seg_%05d.ts"),
+		filepath.Join(dir, hlsPlaylistName),
+	}
+}
+
+// watchReady polls the playlist until it references at least one segment.
+func (m *hlsManager) watchReady(ctx context.Context, sess *hlsSession) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	playlist := sess.path(hlsPlaylistName)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sess.ready:
+			return
+		case <-ticker.C:
+			raw, err := os.ReadFile(playlist)
+			if err == nil && strings.Contains(string(raw), ".ts") {
+				safeClose(sess.ready)
+				return
+			}
+		}
+	}
+}
+
+func (m *hlsManager) reap() {
+	ticker := time.NewTicker(m.idle / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		var idle []*hlsSession
+		for _, sess := range m.sessions {
+			if sess.idleFor() > m.idle {
+				idle = append(idle, sess)
+			}
+		}
+		m.mu.Unlock()
+		for _, sess := range idle {
+			log.Printf("[hls] reaping idle session id=%s variant=%s", sess.videoID, sess.variant.Name)
+			m.stop(sess, nil)
+		}
+	}
+}
+
+// stop kills the encoder and removes the session's segments.
+func (m *hlsManager) stop(sess *hlsSession, err error) {
+	m.mu.Lock()
+	if current, ok := m.sessions[sess.key]; ok && current == sess {
+		delete(m.sessions, sess.key)
+	}
+	m.mu.Unlock()
+
+	sess.mu.Lock()
+	if sess.done {
+		sess.mu.Unlock()
+		return
+	}
+	sess.done = true
+	if err != nil && sess.err == nil {
+		sess.err = err
+	}
+	cancel, release, cleanup, dir := sess.cancel, sess.release, sess.cleanup, sess.dir
+	sess.cancel, sess.release, sess.cleanup = nil, nil, nil
+	sess.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if cleanup != nil {
+		cleanup()
+	}
+	if release != nil {
+		release()
+	}
+	safeClose(sess.ready)
+	if dir != "" {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// path returns the on-disk location of file inside the session directory.
+func (sess *hlsSession) path(file string) string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.dir == "" {
+		return ""
+	}
+	return filepath.Join(sess.dir, file)
+}
+
+func (sess *hlsSession) touch() {
+	sess.mu.Lock()
+	sess.lastSeen = time.Now()
+	sess.mu.Unlock()
+}
+
+func (sess *hlsSession) idleFor() time.Duration {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return time.Since(sess.lastSeen)
+}
+
+func (sess *hlsSession) setErr(err error) {
+	sess.mu.Lock()
+	if sess.err == nil {
+		sess.err = err
+	}
+	sess.mu.Unlock()
+}
@@ -0,0 +1,424 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ImageMode selects one of the image-sequence outputs for devices that have
+// a browser but no video player.
+type ImageMode string
+
+const (
+	ImageMJPEG     ImageMode = "mjpeg"
+	ImageGIF       ImageMode = "gif"
+	ImageSlideshow ImageMode = "slides"
+)
+
+const (
+	mjpegBoundary = "ytmframe"
+	slideIdle     = 10 * time.Minute
+	slideTimeout  = 45 * time.Second
+)
+
+// ImageLimits caps what a client may ask of the image-sequence modes.
+type ImageLimits struct {
+	MJPEGMaxSeconds  float64
+	MJPEGMaxFPS      float64
+	GIFMaxSeconds    float64
+	GIFMaxFPS        float64
+	SlideMaxFrames   int
+	SlideMinInterval float64
+	MaxWidth         int
+}
+
+// DefaultImageLimits keeps each mode cheap enough to run alongside video transcodes.
+var DefaultImageLimits = ImageLimits{
+	MJPEGMaxSeconds:  300,
+	MJPEGMaxFPS:      5,
+	GIFMaxSeconds:    20,
+	GIFMaxFPS:        4,
+	SlideMaxFrames:   40,
+	SlideMinInterval: 2,
+	MaxWidth:         320,
+}
+
+// ImageRequest describes the clip an image mode renders. For slideshows FPS
+// is the inverse of the interval between frames.
+type ImageRequest struct {
+	Mode     ImageMode
+	Start    float64
+	Duration float64
+	FPS      float64
+	Width    int
+}
+
+// sourceNeeds asks for a silent rendition about as tall as a 16:9 frame of
+// the requested width.
+func (r ImageRequest) sourceNeeds() sourceNeeds {
+	height := defaultSourceHeight
+	if r.Width > 0 {
+		height = r.Width * 9 / 16
+	}
+	return sourceNeeds{video: true, height: height}
+}
+
+// Interval returns the seconds between two frames.
+func (r ImageRequest) Interval() float64 {
+	if r.FPS <= 0 {
+		return 0
+	}
+	return 1 / r.FPS
+}
+
+// Frames returns how many frames the request produces.
+func (r ImageRequest) Frames() int {
+	return int(math.Max(1, math.Floor(r.Duration*r.FPS)))
+}
+
+// WithImageLimits overrides the image-sequence limits.
+func (s *Service) WithImageLimits(limits ImageLimits) *Service {
+	s.imageLimits = limits
+	return s
+}
+
+// ImageRequestFromQuery reads start/t, dur, fps (or every= for slideshows) and
+// w from q, filling defaults and clamping to the configured limits.
+func (s *Service) ImageRequestFromQuery(mode ImageMode, q url.Values) ImageRequest {
+	limits := s.imageLimits
+	req := ImageRequest{Mode: mode, Start: parseStartFromQuery(q.Encode())}
+	maxSeconds, maxFPS := limits.MJPEGMaxSeconds, limits.MJPEGMaxFPS
+	switch mode {
+	case ImageGIF:
+		req.Duration, req.FPS, req.Width = 8, 2, 160
+		maxSeconds, maxFPS = limits.GIFMaxSeconds, limits.GIFMaxFPS
+	case ImageSlideshow:
+		req.Duration, req.FPS, req.Width = 120, 0.1, 176
+		// Length is bounded by SlideMaxFrames below.
+		maxSeconds = math.MaxFloat64
+		maxFPS = 1 / limits.SlideMinInterval
+	default:
+		req.Duration, req.FPS, req.Width = 60, 2, 176
+	}
+	if v, err := strconv.ParseFloat(q.Get("dur"), 64); err == nil && v > 0 {
+		req.Duration = v
+	}
+	if v, err := strconv.ParseFloat(q.Get("fps"), 64); err == nil && v > 0 {
+		req.FPS = v
+	}
+	if v, err := strconv.ParseFloat(q.Get("every"), 64); err == nil && v > 0 {
+		req.FPS = 1 / v
+	}
+	if v, err := strconv.Atoi(q.Get("w")); err == nil && v > 0 {
+		req.Width = v
+	}
+	req.Duration = math.Min(req.Duration, maxSeconds)
+	req.FPS = math.Min(req.FPS, maxFPS)
+	if mode == ImageSlideshow && req.Frames() > limits.SlideMaxFrames {
+		req.Duration = float64(limits.SlideMaxFrames) / req.FPS
+	}
+	req.Width = max(64, min(req.Width, limits.MaxWidth)) &^ 1
+	return req
+}
+
+// Query renders req back into URL parameters.
+func (r ImageRequest) Query() url.Values {
+	q := url.Values{}
+	if r.Start > 0 {
+		q.Set("start", strconv.Itoa(int(math.Round(r.Start))))
+	}
+	q.Set("dur", strconv.FormatFloat(r.Duration, 'f', -1, 64))
+	if r.Mode == ImageSlideshow {
+		q.Set("every", strconv.FormatFloat(r.Interval(), 'f', -1, 64))
+	} else {
+		q.Set("fps", strconv.FormatFloat(r.FPS, 'f', -1, 64))
+	}
+	q.Set("w", strconv.Itoa(r.Width))
+	return q
+}
+
+func (r ImageRequest) profile() Profile {
+	return Profile("image-" + string(r.Mode))
+}
+
+// ServeMJPEG streams the clip as multipart/x-mixed-replace JPEG frames, paced
+// in real time so browsers without a video player see it move.
+func (s *Service) ServeMJPEG(w http.ResponseWriter, r *http.Request, videoID string, req ImageRequest) {
+	out := &lazyWriter{w: w, header: func(h http.Header) {
+		h.Set("Content-Type", "multipart/x-mixed-replace;boundary="+mjpegBoundary)
+		h.Set("Cache-Control", "no-cache")
+	}}
+	args := []string{
+		"-t", formatSeek(req.Duration), "-an",
+		"-vf", fmt.Sprintf("fps=%s,scale=%d:-2", strconv.FormatFloat(req.FPS, 'f', -1, 64), req.Width),
+		"-c:v", "mjpeg", "-q:v", "8",
+		"-f", "mpjpeg", "-boundary_tag", mjpegBoundary, "pipe:1",
+	}
+	err := s.runImageJob(WithClient(r.Context(), r.RemoteAddr), videoID, req, args, out, true)
+	s.imageError(w, out, err)
+}
+
+// ServeGIF renders the clip as a looping animated GIF with a reduced palette.
+func (s *Service) ServeGIF(w http.ResponseWriter, r *http.Request, videoID string, req ImageRequest) {
+	out := &lazyWriter{w: w, header: func(h http.Header) {
+		h.Set("Content-Type", "image/gif")
+		h.Set("Cache-Control", "public, max-age=3600")
+		h.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s_%d.gif"`, videoID, int(req.Start)))
+	}}
+	filter := fmt.Sprintf("fps=%s,scale=%d:-1:flags=lanczos,split[a][b];[a]palettegen=max_colors=64[p];[b][p]paletteuse=dither=bayer:bayer_scale=3",
+		strconv.FormatFloat(req.FPS, 'f', -1, 64), req.Width)
+	args := []string{"-t", formatSeek(req.Duration), "-an", "-filter_complex", filter, "-loop", "0", "-f", "gif", "pipe:1"}
+	err := s.runImageJob(WithClient(r.Context(), r.RemoteAddr), videoID, req, args, out, false)
+	s.imageError(w, out, err)
+}
+
+func (s *Service) imageError(w http.ResponseWriter, out *lazyWriter, err error) {
+	if err == nil || out.started {
+		if err != nil {
+			log.Printf("[image] %v", err)
+		}
+		return
+	}
+	var busy *BusyError
+	if errors.As(err, &busy) {
+		w.Header().Set("Retry-After", busy.RetryAfterSeconds())
+		http.Error(w, "transcoder busy, try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// runImageJob runs one ffmpeg process for req with the given output options,
+// holding a scheduler slot and a job registry entry for its lifetime.
+func (s *Service) runImageJob(ctx context.Context, videoID string, req ImageRequest, output []string, stdout io.Writer, realtime bool) error {
+	release, err := s.scheduler.Acquire(ctx, req.profile(), clientFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	resolveCtx, resolveCancel := context.WithTimeout(ctx, rtspResolveTimeout)
+	src, err := s.resolveSource(resolveCtx, videoID, req.sourceNeeds())
+	resolveCancel()
+	if err != nil {
+		return fmt.Errorf("resolve stream: %w", err)
+	}
+	input, cleanup, err := s.buildInputPaced(src, req.Start, realtime)
+	if err != nil {
+		return err
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	args := append([]string{}, input.args...)
+	if input.pipe && input.postSeek {
+		args = append(args, "-ss", formatSeek(input.start))
+	}
+	args = append(args, output...)
+
+	proc, err := s.start(ctx, Command{Args: args, Stdin: input.pipe, Stdout: stdout})
+	if err != nil {
+		return err
+	}
+	if stdin := proc.Stdin(); stdin != nil {
+		s.startInputPump(ctx, stdin, input.srcURL)
+	}
+	job := s.jobs.add(JobHTTP, videoID, req.profile(), clientFromContext(ctx), req.Start)
+	go logFFmpeg(proc.Stderr(), "[ffmpeg "+string(req.Mode)+"]", job)
+
+	err = proc.Wait()
+	stopped := ctx.Err() != nil
+	s.jobs.done(job, err != nil && !stopped)
+	if err != nil && !stopped {
+		return fmt.Errorf("ffmpeg %s: %w", req.Mode, err)
+	}
+	return nil
+}
+
+// lazyWriter sets response headers on the first byte so failures before any
+// output can still become a proper HTTP error, and flushes every write.
+type lazyWriter struct {
+	w       http.ResponseWriter
+	header  func(http.Header)
+	started bool
+}
+
+func (lw *lazyWriter) Write(p []byte) (int, error) {
+	if !lw.started {
+		lw.started = true
+		lw.header(lw.w.Header())
+	}
+	n, err := lw.w.Write(p)
+	if f, ok := lw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// Slideshow is a set of JPEG frames extracted from a clip.
+type Slideshow struct {
+	VideoID string
+	Request ImageRequest
+	Frames  int
+}
+
+// FrameTime returns the offset into the video of frame n (1-based).
+func (sl *Slideshow) FrameTime(n int) float64 {
+	return sl.Request.Start + float64(n-1)*sl.Request.Interval()
+}
+
+type slideManager struct {
+	mu      sync.Mutex
+	sets    map[string]*slideSet
+	janitor bool
+}
+
+type slideSet struct {
+	dir      string
+	ready    chan struct{}
+	frames   int
+	err      error
+	lastSeen time.Time
+}
+
+func slideKey(videoID string, req ImageRequest) string {
+	return videoID + "|" + req.Query().Encode()
+}
+
+// Slideshow extracts (or reuses) the frames for req. Extraction runs once per
+// clip; sets are deleted after ten idle minutes.
+func (s *Service) Slideshow(ctx context.Context, videoID string, req ImageRequest) (*Slideshow, error) {
+	m := s.slides
+	key := slideKey(videoID, req)
+	m.mu.Lock()
+	set, ok := m.sets[key]
+	if !ok {
+		set = &slideSet{ready: make(chan struct{})}
+		m.sets[key] = set
+		if !m.janitor {
+			m.janitor = true
+			go m.reap()
+		}
+	}
+	set.lastSeen = time.Now()
+	m.mu.Unlock()
+
+	if !ok {
+		go s.extractSlides(WithClient(context.Background(), clientFromContext(ctx)), key, set, videoID, req)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, slideTimeout)
+	defer cancel()
+	select {
+	case <-set.ready:
+	case <-waitCtx.Done():
+		return nil, fmt.Errorf("slideshow not ready: %w", waitCtx.Err())
+	}
+	if set.err != nil {
+		m.drop(key, set)
+		return nil, set.err
+	}
+	return &Slideshow{VideoID: videoID, Request: req, Frames: set.frames}, nil
+}
+
+// ServeSlideFrame writes frame n (1-based) of the slideshow for req.
+func (s *Service) ServeSlideFrame(w http.ResponseWriter, r *http.Request, videoID string, req ImageRequest, n int) {
+	show, err := s.Slideshow(WithClient(r.Context(), r.RemoteAddr), videoID, req)
+	if err != nil {
+		s.imageError(w, &lazyWriter{}, err)
+		return
+	}
+	if n < 1 || n > show.Frames {
+		http.NotFound(w, r)
+		return
+	}
+	s.slides.mu.Lock()
+	set := s.slides.sets[slideKey(videoID, req)]
+	s.slides.mu.Unlock()
+	if set == nil {
+		http.Error(w, "slideshow expired", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeFile(w, r, filepath.Join(set.dir, slideFileName(n)))
+}
+
+func slideFileName(n int) string {
+	return fmt.Sprintf("%03d.jpg", n)
+}
+
+func (s *Service) extractSlides(ctx context.Context, key string, set *slideSet, videoID string, req ImageRequest) {
+	defer close(set.ready)
+	dir, err := os.MkdirTemp("", "ytm-slides-")
+	if err != nil {
+		set.err = fmt.Errorf("slides: temp dir: %w", err)
+		return
+	}
+	set.dir = dir
+	ctx, cancel := context.WithTimeout(ctx, 2*slideTimeout)
+	defer cancel()
+	args := []string{
+		"-t", formatSeek(req.Duration), "-an",
+		"-vf", fmt.Sprintf("fps=%s,scale=%d:-2", strconv.FormatFloat(req.FPS, 'f', -1, 64), req.Width),
+		"-q:v", "8", "-frames:v", strconv.Itoa(req.Frames()),
+		filepath.Join(dir, "%03d.jpg"),
+	}
+	if err := s.runImageJob(ctx, videoID, req, args, io.Discard, false); err != nil {
+		set.err = err
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jpg"))
+	if len(files) == 0 {
+		set.err = errors.New("slides: no frames extracted")
+		return
+	}
+	set.frames = len(files)
+	log.Printf("[slides] extracted %d frames id=%s key=%s", set.frames, videoID, key)
+}
+
+func (m *slideManager) drop(key string, set *slideSet) {
+	m.mu.Lock()
+	if m.sets[key] == set {
+		delete(m.sets, key)
+	}
+	m.mu.Unlock()
+	if set.dir != "" {
+		_ = os.RemoveAll(set.dir)
+	}
+}
+
+func (m *slideManager) reap() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		var stale []*slideSet
+		m.mu.Lock()
+		for key, set := range m.sets {
+			select {
+			case <-set.ready:
+			default:
+				continue
+			}
+			if time.Since(set.lastSeen) > slideIdle {
+				delete(m.sets, key)
+				stale = append(stale, set)
+			}
+		}
+		m.mu.Unlock()
+		for _, set := range stale {
+			_ = os.RemoveAll(set.dir)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package transcode
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobKind tells which output an ffmpeg process feeds.
+type JobKind string
+
+const (
+	JobHTTP JobKind = "http"
+	JobRTSP JobKind = "rtsp"
+	JobHLS  JobKind = "hls"
+	// JobDownload is an offline transcode into the downloads library.
+	JobDownload JobKind = "download"
+)
+
+// jobStallAfter is how long out_time may stand still before a job counts as stalled.
+const jobStallAfter = 20 * time.Second
+
+// progressArgs makes ffmpeg write machine-readable key=value progress blocks
+// to stderr instead of the interactive status line.
+var progressArgs = []string{"-nostats", "-progress", "pipe:2"}
+
+// Progress is the latest block reported by ffmpeg -progress.
+type Progress struct {
+	Frames      int64   `json:"frames"`
+	FPS         float64 `json:"fps"`
+	BitrateKbps float64 `json:"bitrate_kbps"`
+	TotalSize   int64   `json:"total_size"`
+	OutTime     float64 `json:"out_time"`
+	Speed       float64 `json:"speed"`
+	DupFrames   int64   `json:"dup_frames"`
+	DropFrames  int64   `json:"drop_frames"`
+	Ended       bool    `json:"ended"`
+}
+
+// Job is a snapshot of one running ffmpeg process.
+type Job struct {
+	ID       uint64    `json:"id"`
+	Kind     JobKind   `json:"kind"`
+	VideoID  string    `json:"video_id"`
+	Profile  Profile   `json:"profile"`
+	Client   string    `json:"client,omitempty"`
+	Offset   float64   `json:"offset"`
+	Started  time.Time `json:"started"`
+	Updated  time.Time `json:"updated"`
+	Stalled  bool      `json:"stalled"`
+	Progress Progress  `json:"progress"`
+}
+
+// JobStats aggregates the job registry for metrics.
+type JobStats struct {
+	Active   int
+	HTTP     int
+	RTSP     int
+	HLS      int
+	Download int
+	Stalled  int
+	Started  uint64
+	Finished uint64
+	Failed   uint64
+	// Speed is the average encode speed of active jobs (1.0 = realtime).
+	Speed float64
+}
+
+type jobRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	jobs     map[uint64]*job
+	started  uint64
+	finished uint64
+	failed   uint64
+}
+
+type job struct {
+	mu          sync.Mutex
+	info        Job
+	lastAdvance time.Time
+	pending     Progress
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{jobs: make(map[uint64]*job)}
+}
+
+// add registers a freshly started ffmpeg process.
+func (r *jobRegistry) add(kind JobKind, videoID string, profile Profile, client string, offset float64) *job {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	r.started++
+	j := &job{
+		info: Job{
+			ID:      r.nextID,
+			Kind:    kind,
+			VideoID: videoID,
+			Profile: profile,
+			Client:  client,
+			Offset:  offset,
+			Started: now,
+		},
+		lastAdvance: now,
+	}
+	r.jobs[j.info.ID] = j
+	return j
+}
+
+// done removes j once its process has exited; failed counts abnormal exits.
+func (r *jobRegistry) done(j *job, failed bool) {
+	if j == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.jobs[j.info.ID]; !ok {
+		return
+	}
+	delete(r.jobs, j.info.ID)
+	if failed {
+		r.failed++
+	} else {
+		r.finished++
+	}
+}
+
+func (r *jobRegistry) snapshot() []Job {
+	r.mu.Lock()
+	list := make([]*job, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		list = append(list, j)
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	out := make([]Job, 0, len(list))
+	for _, j := range list {
+		out = append(out, j.snapshot(now))
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].ID < out[k].ID })
+	return out
+}
+
+func (r *jobRegistry) stats() JobStats {
+	jobs := r.snapshot()
+	r.mu.Lock()
+	stats := JobStats{Active: len(jobs), Started: r.started, Finished: r.finished, Failed: r.failed}
+	r.mu.Unlock()
+	var speed float64
+	for _, j := range jobs {
+		switch j.Kind {
+		case JobHTTP:
+			stats.HTTP++
+		case JobRTSP:
+			stats.RTSP++
+		case JobHLS:
+			stats.HLS++
+		case JobDownload:
+			stats.Download++
+		}
+		if j.Stalled {
+			stats.Stalled++
+		}
+		speed += j.Progress.Speed
+	}
+	if len(jobs) > 0 {
+		stats.Speed = speed / float64(len(jobs))
+	}
+	return stats
+}
+
+func (j *job) snapshot(now time.Time) Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := j.info
+	info.Stalled = !info.Progress.Ended && now.Sub(j.lastAdvance) > jobStallAfter
+	return info
+}
+
+// field applies one key=value line of ffmpeg -progress output. Values are
+// collected until the closing "progress=" line publishes the whole block.
+func (j *job) field(key, value string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p := &j.pending
+	switch key {
+	case "frame":
+		p.Frames, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		p.FPS, _ = strconv.ParseFloat(value, 64)
+	case "bitrate":
+		// "523.4kbits/s" or "N/A"
+		p.BitrateKbps, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+	case "total_size":
+		p.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+	case "out_time_us", "out_time_ms":
+		// Both keys carry microseconds.
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			p.OutTime = float64(us) / 1e6
+		}
+	case "speed":
+		p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+	case "dup_frames":
+		p.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "drop_frames":
+		p.DropFrames, _ = strconv.ParseInt(value, 10, 64)
+	case "progress":
+		p.Ended = value == "end"
+		now := time.Now()
+		if p.OutTime > j.info.Progress.OutTime || p.Ended {
+			j.lastAdvance = now
+		}
+		j.info.Progress = *p
+		j.info.Updated = now
+	case "out_time":
+		// Same value as out_time_us, formatted.
+	default:
+		// Per-stream quantisers (stream_0_0_q=...) are not tracked.
+		return strings.HasPrefix(key, "stream_")
+	}
+	return true
+}
+
+// logFFmpeg drains ffmpeg's stderr, feeding -progress lines into j and
+// logging everything else with prefix.
+func logFFmpeg(r io.Reader, prefix string, j *job) {
+	scanner := newStderrScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key, value, ok := strings.Cut(line, "="); ok && j != nil && !strings.ContainsAny(key, " \t") {
+			if j.field(key, strings.TrimSpace(value)) {
+				continue
+			}
+		}
+		if strings.Contains(line, "frame=") {
+			continue
+		}
+		log.Printf("%s %s", prefix, line)
+	}
+}
+
+// Jobs returns a snapshot of every running ffmpeg process, oldest first.
+func (s *Service) Jobs() []Job {
+	return s.jobs.snapshot()
+}
+
+// JobStats summarises the running and finished ffmpeg processes.
+func (s *Service) JobStats() JobStats {
+	return s.jobs.stats()
+}
+
+// JobsHandler serves the job registry as JSON.
+func (s *Service) JobsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(struct {
+			Jobs []Job `json:"jobs"`
+		}{Jobs: s.Jobs()})
+	})
+}
@@ -0,0 +1,587 @@
+package transcode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// VideoParams describes the video encoder settings of a profile.
+type VideoParams struct {
+	Codec     string   `json:"codec"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	FPS       float64  `json:"fps"`
+	Bitrate   string   `json:"bitrate"`
+	MaxRate   string   `json:"maxrate,omitempty"`
+	BufSize   string   `json:"bufsize,omitempty"`
+	PixFmt    string   `json:"pix_fmt,omitempty"`
+	Profile   string   `json:"profile,omitempty"`
+	Level     string   `json:"level,omitempty"`
+	Preset    string   `json:"preset,omitempty"`
+	BFrames   *int     `json:"bframes,omitempty"`
+	GOP       int      `json:"gop,omitempty"`
+	KeyintMin int      `json:"keyint_min,omitempty"`
+	Extra     []string `json:"extra,omitempty"`
+}
+
+// AudioParams describes the audio encoder settings of a profile.
+type AudioParams struct {
+	Codec      string   `json:"codec"`
+	SampleRate int      `json:"sample_rate"`
+	Channels   int      `json:"channels"`
+	Bitrate    string   `json:"bitrate"`
+	Extra      []string `json:"extra,omitempty"`
+}
+
+// AudioVariant is an alternative bitrate for an audio profile, selectable with
+// ?kbps= on /stream/audio/. Zero sample rate or channels keep the profile's values.
+type AudioVariant struct {
+	Bitrate    string `json:"bitrate"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+}
+
+// Kbps returns the numeric bitrate of the variant ("12.2k" -> 12.2).
+func (v AudioVariant) Kbps() float64 {
+	kbps, _ := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(v.Bitrate), "k"), 64)
+	return kbps
+}
+
+// ProfileSpec declares one transcode preset: how ffmpeg encodes it, how it is
+// muxed for HTTP, whether RTSP may publish it, and how the UI labels it.
+type ProfileSpec struct {
+	Name        Profile      `json:"name"`
+	Label       string       `json:"label"`
+	RTSPLabel   string       `json:"rtsp_label,omitempty"`
+	Container   string       `json:"container"`
+	Extension   string       `json:"extension,omitempty"`
+	ContentType string       `json:"content_type"`
+	Suffix      string       `json:"suffix,omitempty"`
+	Video       *VideoParams `json:"video,omitempty"`
+	Audio       *AudioParams `json:"audio,omitempty"`
+	// Filters are appended to the scale/fps chain.
+	Filters []string `json:"filters,omitempty"`
+	// RetroFilter applies the service-wide retro look (WithRetroFilter).
+	RetroFilter bool `json:"retro_filter,omitempty"`
+	// MuxArgs go right before "-f <container>" for HTTP output.
+	MuxArgs []string `json:"mux_args,omitempty"`
+	// AudioVariants lists the bitrates an audio-only profile can be requested at.
+	AudioVariants []AudioVariant `json:"audio_variants,omitempty"`
+	HTTP          bool           `json:"http"`
+	RTSP          bool           `json:"rtsp"`
+	// Hidden keeps the profile routable but out of the watch page.
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// Format returns the HTTP output format of the profile.
+func (p ProfileSpec) Format() outputFormat {
+	ext := p.Extension
+	if ext == "" {
+		ext = p.Container
+	}
+	suffix := p.Suffix
+	if suffix == "" {
+		suffix = "_" + string(p.Name)
+	}
+	return outputFormat{ContentType: p.ContentType, Extension: ext, Suffix: suffix, Fragmented: p.fragmented()}
+}
+
+// fragmented reports whether MuxArgs turn on a frag_* movflag, whichever
+// of the mov, mp4, 3gp or 3g2 muxers writes it. The last -movflags wins,
+// as it does in ffmpeg.
+func (p ProfileSpec) fragmented() bool {
+	var flags string
+	for i := 0; i+1 < len(p.MuxArgs); i++ {
+		if p.MuxArgs[i] == "-movflags" {
+			flags = p.MuxArgs[i+1]
+		}
+	}
+	on := false
+	for flags != "" {
+		add := flags[0] != '-'
+		flags = strings.TrimLeft(flags, "+-")
+		end := strings.IndexAny(flags, "+-")
+		if end < 0 {
+			end = len(flags)
+		}
+		if strings.HasPrefix(flags[:end], "frag_") {
+			on = add
+		}
+		flags = flags[end:]
+	}
+	return on
+}
+
+// DisplayLabel returns the label shown next to RTSP or HTTP links.
+func (p ProfileSpec) DisplayLabel(rtsp bool) string {
+	if rtsp && p.RTSPLabel != "" {
+		return p.RTSPLabel
+	}
+	if p.Label != "" {
+		return p.Label
+	}
+	return string(p.Name)
+}
+
+// AudioOnly reports whether the profile drops the picture entirely.
+func (p ProfileSpec) AudioOnly() bool {
+	return p.Video == nil && p.Audio != nil
+}
+
+// audioVariantSep joins a base profile and a bitrate, e.g. "audio-mp3@64k".
+const audioVariantSep = "@"
+
+// AudioVariantProfile names base at the given bitrate for Lookup and Stream.
+func AudioVariantProfile(base Profile, bitrate string) Profile {
+	if bitrate == "" {
+		return base
+	}
+	return base + Profile(audioVariantSep+bitrate)
+}
+
+// NearestAudioVariant returns the variant whose bitrate is closest to kbps.
+func (p ProfileSpec) NearestAudioVariant(kbps float64) (AudioVariant, bool) {
+	if len(p.AudioVariants) == 0 {
+		return AudioVariant{}, false
+	}
+	best := p.AudioVariants[0]
+	for _, v := range p.AudioVariants[1:] {
+		if math.Abs(v.Kbps()-kbps) < math.Abs(best.Kbps()-kbps) {
+			best = v
+		}
+	}
+	return best, true
+}
+
+func (p ProfileSpec) withAudioVariant(v AudioVariant) ProfileSpec {
+	audio := *p.Audio
+	audio.Bitrate = v.Bitrate
+	if v.SampleRate > 0 {
+		audio.SampleRate = v.SampleRate
+	}
+	if v.Channels > 0 {
+		audio.Channels = v.Channels
+	}
+	suffix := p.Format().Suffix
+	p.Audio = &audio
+	p.Name = AudioVariantProfile(p.Name, v.Bitrate)
+	p.Suffix = suffix + "_" + v.Bitrate
+	return p
+}
+
+func (p ProfileSpec) videoFilter(retroFilter, overlay string) string {
+	if p.Video == nil {
+		return ""
+	}
+	base := make([]string, 0, 2+len(p.Filters))
+	if p.Video.Width > 0 && p.Video.Height > 0 {
+		base = append(base, fmt.Sprintf("scale=%d:%d", p.Video.Width, p.Video.Height))
+	}
+	if p.Video.FPS > 0 {
+		base = append(base, "fps="+strconv.FormatFloat(p.Video.FPS, 'f', -1, 64))
+	}
+	base = append(base, p.Filters...)
+	extra := ""
+	if p.RetroFilter {
+		extra = retroFilter
+	}
+	// Overlays go last so the retro look does not blur them.
+	return buildFilterChain(base, strings.Trim(extra+","+overlay, ","))
+}
+
+// codecArgs renders the -vf/-c:v/-c:a portion of the ffmpeg command line.
+// overlay is an optional filter appended after the profile's own chain.
+func (p ProfileSpec) codecArgs(retroFilter, overlay string) []string {
+	var args []string
+	if v := p.Video; v != nil {
+		if vf := p.videoFilter(retroFilter, overlay); vf != "" {
+			args = append(args, "-vf", vf)
+		}
+		args = append(args, "-c:v", v.Codec)
+		if v.Profile != "" {
+			args = append(args, "-profile:v", v.Profile)
+		}
+		if v.Level != "" {
+			args = append(args, "-level", v.Level)
+		}
+		if v.Preset != "" {
+			args = append(args, "-preset", v.Preset)
+		}
+		if v.PixFmt != "" {
+			args = append(args, "-pix_fmt", v.PixFmt)
+		}
+		if v.Bitrate != "" {
+			args = append(args, "-b:v", v.Bitrate)
+		}
+		if v.MaxRate != "" {
+			args = append(args, "-maxrate", v.MaxRate)
+		}
+		if v.BufSize != "" {
+			args = append(args, "-bufsize", v.BufSize)
+		}
+		if v.BFrames != nil {
+			args = append(args, "-bf", strconv.Itoa(*v.BFrames))
+		}
+		if v.GOP > 0 {
+			args = append(args, "-g", strconv.Itoa(v.GOP))
+		}
+		if v.KeyintMin > 0 {
+			args = append(args, "-keyint_min", strconv.Itoa(v.KeyintMin))
+		}
+		args = append(args, v.Extra...)
+	} else {
+		args = append(args, "-vn")
+	}
+	if a := p.Audio; a != nil {
+		args = append(args, "-c:a", a.Codec)
+		if a.SampleRate > 0 {
+			args = append(args, "-ar", strconv.Itoa(a.SampleRate))
+		}
+		if a.Channels > 0 {
+			args = append(args, "-ac", strconv.Itoa(a.Channels))
+		}
+		if a.Bitrate != "" {
+			args = append(args, "-b:a", a.Bitrate)
+		}
+		args = append(args, a.Extra...)
+	} else {
+		args = append(args, "-an")
+	}
+	return args
+}
+
+var (
+	profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	// Query keys already used by the stream endpoints cannot double as profile flags.
+	reservedProfileNames = map[string]bool{
+		"start": true, "t": true, "profile": true, "transport": true, "rtsp_transport": true,
+		"subs": true, "subs_mode": true, "tlang": true,
+	}
+	// videoCodecs and audioCodecs are the ffmpeg encoders a profile may
+	// name; anything else is most likely a typo that would only fail once
+	// ffmpeg runs.
+	videoCodecs = map[string]bool{
+		"h263": true, "h263p": true, "mpeg4": true, "libxvid": true, "libx264": true,
+		"libx265": true, "libvpx": true, "libvpx-vp9": true, "libtheora": true,
+		"mpeg1video": true, "mpeg2video": true, "msmpeg4": true, "msmpeg4v2": true,
+		"wmv1": true, "wmv2": true, "flv": true, "mjpeg": true,
+	}
+	audioCodecs = map[string]bool{
+		"libopencore_amrnb": true, "libvo_amrwbenc": true, "aac": true, "libfdk_aac": true,
+		"libmp3lame": true, "mp2": true, "libvorbis": true, "libopus": true, "opus": true,
+		"ac3": true, "flac": true, "wmav1": true, "wmav2": true, "pcm_s16le": true,
+		"pcm_u8": true, "pcm_mulaw": true, "pcm_alaw": true, "adpcm_ima_wav": true,
+	}
+)
+
+// Validate checks that the spec is complete enough to build an ffmpeg command.
+func (p ProfileSpec) Validate() error {
+	name := string(p.Name)
+	if !profileNamePattern.MatchString(name) || reservedProfileNames[name] {
+		return fmt.Errorf("profile: invalid name %q", name)
+	}
+	if p.Video == nil && p.Audio == nil {
+		return fmt.Errorf("profile %s: needs video or audio settings", name)
+	}
+	if p.Video != nil && p.Video.Codec == "" {
+		return fmt.Errorf("profile %s: video codec missing", name)
+	}
+	if p.Video != nil && !videoCodecs[p.Video.Codec] {
+		return fmt.Errorf("profile %s: unknown video codec %q", name, p.Video.Codec)
+	}
+	if p.Audio != nil && p.Audio.Codec == "" {
+		return fmt.Errorf("profile %s: audio codec missing", name)
+	}
+	if p.Audio != nil && !audioCodecs[p.Audio.Codec] {
+		return fmt.Errorf("profile %s: unknown audio codec %q", name, p.Audio.Codec)
+	}
+	if len(p.AudioVariants) > 0 && !p.AudioOnly() {
+		return fmt.Errorf("profile %s: audio_variants need an audio-only profile", name)
+	}
+	if p.HTTP && (p.Container == "" || p.ContentType == "") {
+		return fmt.Errorf("profile %s: HTTP output needs container and content_type", name)
+	}
+	if !p.HTTP && !p.RTSP {
+		return fmt.Errorf("profile %s: enable http and/or rtsp", name)
+	}
+	return nil
+}
+
+// ProfileRegistry holds the known transcode profiles in display order.
+type ProfileRegistry struct {
+	mu    sync.RWMutex
+	order []Profile
+	specs map[Profile]ProfileSpec
+	def   Profile
+}
+
+// NewProfileRegistry returns an empty registry whose fallback profile is def.
+func NewProfileRegistry(def Profile) *ProfileRegistry {
+	return &ProfileRegistry{specs: make(map[Profile]ProfileSpec), def: def}
+}
+
+func intPtr(v int) *int { return &v }
+
+// DefaultProfiles returns the built-in video presets (retro, edge, aac, mp3,
+// android), the audio-only presets served behind /stream/audio/ and the
+// audio-only RTSP radio presets.
+func DefaultProfiles() *ProfileRegistry {
+	reg := NewProfileRegistry(ProfileRetro)
+	fragmented3GP := []string{"-use_editlist", "0", "-movflags", "+faststart+frag_keyframe+empty_moov"}
+	amr := func(bitrate string) *AudioParams {
+		return &AudioParams{Codec: "libopencore_amrnb", SampleRate: 8000, Channels: 1, Bitrate: bitrate}
+	}
+	for _, spec := range []ProfileSpec{
+		{
+			Name: ProfileAAC, Label: "MP4 240p (AAC)",
+			Container: "mp4", ContentType: "video/mp4",
+			Video:   &VideoParams{Codec: "mpeg4", Width: 320, Height: 240, FPS: 15, Bitrate: "256k"},
+			Audio:   &AudioParams{Codec: "aac", SampleRate: 16000, Channels: 1, Bitrate: "32k"},
+			MuxArgs: []string{"-movflags", "frag_keyframe+empty_moov"},
+			HTTP:    true,
+		},
+		{
+			Name: ProfileRetro, Label: "3GP 144p (Retro)", RTSPLabel: "3GP 144p (Retro RTSP)",
+			Container: "3gp", ContentType: "video/3gpp",
+			Video:       &VideoParams{Codec: "h263", Width: 176, Height: 144, FPS: 12, Bitrate: "120k"},
+			Audio:       amr("12.2k"),
+			RetroFilter: true,
+			MuxArgs:     fragmented3GP,
+			HTTP:        true, RTSP: true,
+		},
+		{
+			Name: ProfileEdge, Label: "3GP 96p (Edge)", RTSPLabel: "3GP 96p (Edge RTSP)",
+			Container: "3gp", ContentType: "video/3gpp",
+			Video:       &VideoParams{Codec: "h263", Width: 128, Height: 96, FPS: 10, Bitrate: "60k"},
+			Audio:       amr("10.2k"),
+			RetroFilter: true,
+			MuxArgs:     fragmented3GP,
+			HTTP:        true, RTSP: true,
+		},
+		{
+			Name: ProfileAndroid, Label: "RTSP 240p (Android MPEG-4)",
+			Video: &VideoParams{
+				Codec: "mpeg4", Width: 320, Height: 180, FPS: 24, PixFmt: "yuv420p",
+				Bitrate: "350k", MaxRate: "400k", BufSize: "800k",
+				BFrames: intPtr(0), GOP: 48, KeyintMin: 24,
+			},
+			Audio:       &AudioParams{Codec: "aac", SampleRate: 44100, Channels: 2, Bitrate: "64k"},
+			RetroFilter: true,
+			RTSP:        true,
+		},
+		{
+			Name: ProfileMP3, Label: "AVI 144p (MP3)",
+			Container: "avi", ContentType: "video/x-msvideo",
+			Video:  &VideoParams{Codec: "mpeg4", Width: 176, Height: 144, FPS: 12, Bitrate: "120k"},
+			Audio:  &AudioParams{Codec: "libmp3lame", SampleRate: 11025, Channels: 1, Bitrate: "24k"},
+			HTTP:   true,
+			Hidden: true,
+		},
+		{
+			Name: ProfileAudioMP3, Label: "MP3",
+			Container: "mp3", ContentType: "audio/mpeg",
+			Audio: &AudioParams{Codec: "libmp3lame", SampleRate: 44100, Channels: 2, Bitrate: "128k"},
+			AudioVariants: []AudioVariant{
+				{Bitrate: "32k", SampleRate: 22050, Channels: 1},
+				{Bitrate: "48k", SampleRate: 22050, Channels: 1},
+				{Bitrate: "64k", SampleRate: 44100, Channels: 2},
+				{Bitrate: "96k"},
+				{Bitrate: "128k"},
+			},
+			MuxArgs: []string{"-write_xing", "0"},
+			HTTP:    true, Hidden: true,
+		},
+		{
+			Name: ProfileAudioAMR, Label: "AMR-NB",
+			Container: "amr", ContentType: "audio/amr",
+			Audio: amr("12.2k"),
+			AudioVariants: []AudioVariant{
+				{Bitrate: "4.75k"},
+				{Bitrate: "7.95k"},
+				{Bitrate: "12.2k"},
+			},
+			HTTP: true, Hidden: true,
+		},
+		{
+			Name: ProfileAudioAAC, Label: "AAC-LC",
+			Container: "adts", Extension: "aac", ContentType: "audio/aac",
+			Audio: &AudioParams{Codec: "aac", SampleRate: 44100, Channels: 2, Bitrate: "64k"},
+			AudioVariants: []AudioVariant{
+				{Bitrate: "24k", SampleRate: 22050, Channels: 1},
+				{Bitrate: "48k", SampleRate: 44100, Channels: 2},
+				{Bitrate: "64k"},
+				{Bitrate: "96k"},
+			},
+			HTTP: true, Hidden: true,
+		},
+		{
+			Name: ProfileAudioOgg, Label: "Ogg Vorbis",
+			Container: "ogg", ContentType: "audio/ogg",
+			Audio: &AudioParams{Codec: "libvorbis", SampleRate: 44100, Channels: 2, Bitrate: "64k"},
+			AudioVariants: []AudioVariant{
+				{Bitrate: "32k", SampleRate: 22050, Channels: 1},
+				{Bitrate: "64k"},
+				{Bitrate: "96k"},
+			},
+			HTTP: true, Hidden: true,
+		},
+		{
+			Name: ProfileRadioAMR, Label: "AMR-NB", RTSPLabel: "AMR-NB radio (RTSP)",
+			Audio: amr("7.95k"),
+			RTSP:  true, Hidden: true,
+		},
+		{
+			Name: ProfileRadioAAC, Label: "AAC-LC mono", RTSPLabel: "AAC-LC mono radio (RTSP)",
+			Audio: &AudioParams{Codec: "aac", SampleRate: 22050, Channels: 1, Bitrate: "24k"},
+			RTSP:  true, Hidden: true,
+		},
+	} {
+		if err := reg.Register(spec); err != nil {
+			panic(err)
+		}
+	}
+	return reg
+}
+
+// Register adds spec, replacing an existing profile of the same name in place.
+func (r *ProfileRegistry) Register(spec ProfileSpec) error {
+	spec.Name = Profile(strings.ToLower(strings.TrimSpace(string(spec.Name))))
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.specs[spec.Name]; !exists {
+		r.order = append(r.order, spec.Name)
+	}
+	r.specs[spec.Name] = spec
+	return nil
+}
+
+// Lookup returns the spec for name; the empty name resolves to the default profile.
+// Names of the form "<audio profile>@<bitrate>" resolve to that audio variant.
+func (r *ProfileRegistry) Lookup(name Profile) (ProfileSpec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if name == "" {
+		name = r.def
+	}
+	base, bitrate, hasVariant := strings.Cut(strings.ToLower(string(name)), audioVariantSep)
+	spec, ok := r.specs[Profile(base)]
+	if !ok || !hasVariant {
+		return spec, ok
+	}
+	for _, v := range spec.AudioVariants {
+		if strings.EqualFold(v.Bitrate, bitrate) {
+			return spec.withAudioVariant(v), true
+		}
+	}
+	return ProfileSpec{}, false
+}
+
+// Default returns the fallback profile name.
+func (r *ProfileRegistry) Default() Profile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.def
+}
+
+// All returns every profile in registration order.
+func (r *ProfileRegistry) All() []ProfileSpec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]ProfileSpec, 0, len(r.order))
+	for _, name := range r.order {
+		out = append(out, r.specs[name])
+	}
+	return out
+}
+
+// FromQuery picks an HTTP profile from either ?profile=<name> or a bare ?<name>
+// flag (the legacy ?aac / ?edge form), falling back to the default profile.
+func (r *ProfileRegistry) FromQuery(values url.Values) Profile {
+	if name, ok := r.Requested(values); ok {
+		return name
+	}
+	return r.Default()
+}
+
+// Requested is FromQuery without the fallback: it reports whether the query
+// named an HTTP profile at all.
+func (r *ProfileRegistry) Requested(values url.Values) (Profile, bool) {
+	if name := Profile(strings.ToLower(strings.TrimSpace(values.Get("profile")))); name != "" {
+		if spec, ok := r.Lookup(name); ok && spec.HTTP {
+			return spec.Name, true
+		}
+	}
+	for _, spec := range r.All() {
+		if spec.HTTP && len(values[string(spec.Name)]) > 0 {
+			return spec.Name, true
+		}
+	}
+	return "", false
+}
+
+// profileFile is the on-disk shape accepted by LoadFile.
+type profileFile struct {
+	Default  Profile       `json:"default,omitempty"`
+	Profiles []ProfileSpec `json:"profiles"`
+}
+
+// LoadFile merges profiles from a JSON file into the registry. Entries with an
+// existing name override the built-in preset; new names are appended. The
+// file is checked as a whole first, so a bad one leaves the registry as it was.
+func (r *ProfileRegistry) LoadFile(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("profiles: %w", err)
+	}
+	var file profileFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return fmt.Errorf("profiles: %s: %w", path, err)
+	}
+	seen := make(map[Profile]ProfileSpec, len(file.Profiles))
+	for i, spec := range file.Profiles {
+		spec.Name = Profile(strings.ToLower(strings.TrimSpace(string(spec.Name))))
+		if err := spec.Validate(); err != nil {
+			return fmt.Errorf("profiles: %s: %w", path, err)
+		}
+		if _, ok := seen[spec.Name]; ok {
+			return fmt.Errorf("profiles: %s: duplicate profile %q", path, spec.Name)
+		}
+		seen[spec.Name] = spec
+		file.Profiles[i] = spec
+	}
+	def := Profile(strings.ToLower(strings.TrimSpace(string(file.Default))))
+	if def != "" {
+		spec, ok := seen[def]
+		if !ok {
+			spec, ok = r.Lookup(def)
+		}
+		if !ok {
+			return fmt.Errorf("profiles: %s: unknown default %q", path, file.Default)
+		}
+		// The default answers requests without a profile, which are HTTP.
+		if !spec.HTTP {
+			return fmt.Errorf("profiles: %s: default %q does not serve http", path, file.Default)
+		}
+	}
+	for _, spec := range file.Profiles {
+		if err := r.Register(spec); err != nil {
+			return fmt.Errorf("profiles: %s: %w", path, err)
+		}
+	}
+	if def != "" {
+		r.mu.Lock()
+		r.def = def
+		r.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package transcode
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeProfiles(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	reg := DefaultProfiles()
+	before := len(reg.All())
+	path := writeProfiles(t, `{
+		"default": "QVGA",
+		"profiles": [
+			{
+				"name": "qvga", "label": "MP4 240p (H.264)",
+				"container": "mp4", "content_type": "video/mp4",
+				"video": {"codec": "libx264", "width": 320, "height": 240, "fps": 15, "bitrate": "200k", "bframes": 0},
+				"audio": {"codec": "aac", "sample_rate": 22050, "channels": 1, "bitrate": "48k"},
+				"mux_args": ["-movflags", "frag_keyframe+empty_moov"],
+				"http": true
+			},
+			{
+				"name": "edge", "label": "3GP 96p (slower)",
+				"container": "3gp", "content_type": "video/3gpp",
+				"video": {"codec": "h263", "width": 128, "height": 96, "fps": 8, "bitrate": "48k"},
+				"audio": {"codec": "libopencore_amrnb", "sample_rate": 8000, "channels": 1, "bitrate": "7.95k"},
+				"http": true, "rtsp": true
+			}
+		]
+	}`)
+	if err := reg.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+
+	if got := reg.Default(); got != "qvga" {
+		t.Errorf("Default = %q, want qvga", got)
+	}
+	all := reg.All()
+	if len(all) != before+1 {
+		t.Fatalf("%d profiles, want %d: the override must replace edge in place", len(all), before+1)
+	}
+	if all[len(all)-1].Name != "qvga" {
+		t.Errorf("new profile %q not appended last", all[len(all)-1].Name)
+	}
+	qvga, ok := reg.Lookup("qvga")
+	if !ok {
+		t.Fatal("qvga missing")
+	}
+	if bf := qvga.Video.BFrames; bf == nil || *bf != 0 {
+		t.Errorf("bframes = %v, want explicit 0", bf)
+	}
+	if !qvga.Format().Fragmented {
+		t.Error("qvga mux_args should make fragmented output")
+	}
+	edge, _ := reg.Lookup(ProfileEdge)
+	if edge.Label != "3GP 96p (slower)" || edge.Video.FPS != 8 {
+		t.Errorf("edge not overridden: %+v", edge)
+	}
+}
+
+func TestLoadFileExample(t *testing.T) {
+	reg := DefaultProfiles()
+	if err := reg.LoadFile(filepath.Join("..", "..", "docs", "profiles.example.json")); err != nil {
+		t.Fatalf("docs/profiles.example.json: %v", err)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	// profile renders one entry around the given fields.
+	profile := func(name, fields string) string {
+		return `{"name": "` + name + `", "label": "x", "container": "mp4", "content_type": "video/mp4", "http": true` + fields + `}`
+	}
+	video := `, "video": {"codec": "mpeg4", "bitrate": "200k"}`
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name:    "unknown video codec",
+			body:    `{"profiles": [` + profile("bad", `, "video": {"codec": "mpeg5"}`) + `]}`,
+			wantErr: `unknown video codec "mpeg5"`,
+		},
+		{
+			name:    "unknown audio codec",
+			body:    `{"profiles": [` + profile("bad", `, "audio": {"codec": "amr"}`) + `]}`,
+			wantErr: `unknown audio codec "amr"`,
+		},
+		{
+			name:    "option smuggled as codec",
+			body:    `{"profiles": [` + profile("bad", `, "video": {"codec": "-f"}`) + `]}`,
+			wantErr: "unknown video codec",
+		},
+		{
+			name:    "duplicate name",
+			body:    `{"profiles": [` + profile("twice", video) + `, ` + profile("Twice", video) + `]}`,
+			wantErr: `duplicate profile "twice"`,
+		},
+		{
+			name:    "missing codec",
+			body:    `{"profiles": [` + profile("bad", `, "video": {"width": 176}`) + `]}`,
+			wantErr: "video codec missing",
+		},
+		{
+			name:    "no streams",
+			body:    `{"profiles": [` + profile("bad", "") + `]}`,
+			wantErr: "needs video or audio",
+		},
+		{
+			name:    "reserved name",
+			body:    `{"profiles": [` + profile("start", video) + `]}`,
+			wantErr: "invalid name",
+		},
+		{
+			name:    "http without container",
+			body:    `{"profiles": [{"name": "bad", "http": true` + video + `}]}`,
+			wantErr: "needs container and content_type",
+		},
+		{
+			name:    "no output",
+			body:    `{"profiles": [{"name": "bad"` + video + `}]}`,
+			wantErr: "enable http and/or rtsp",
+		},
+		{
+			name:    "variants on a video profile",
+			body:    `{"profiles": [` + profile("bad", video+`, "audio": {"codec": "aac"}, "audio_variants": [{"bitrate": "32k"}]`) + `]}`,
+			wantErr: "audio_variants need an audio-only profile",
+		},
+		{
+			name:    "unknown default",
+			body:    `{"default": "nope", "profiles": [` + profile("fine", video) + `]}`,
+			wantErr: `unknown default "nope"`,
+		},
+		{
+			name:    "rtsp-only default",
+			body:    `{"default": "radio", "profiles": [{"name": "radio", "rtsp": true, "audio": {"codec": "aac"}}]}`,
+			wantErr: `default "radio" does not serve http`,
+		},
+		{
+			name:    "built-in rtsp-only default",
+			body:    `{"default": "` + string(ProfileRadioAAC) + `", "profiles": [` + profile("fine", video) + `]}`,
+			wantErr: "does not serve http",
+		},
+		{
+			name:    "not json",
+			body:    `{"profiles": [`,
+			wantErr: "unexpected end of JSON input",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := DefaultProfiles()
+			want := reg.All()
+			err := reg.LoadFile(writeProfiles(t, tt.body))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadFile error = %v, want %q", err, tt.wantErr)
+			}
+			if got := reg.All(); !reflect.DeepEqual(got, want) {
+				t.Error("a rejected file changed the registry")
+			}
+			if reg.Default() != ProfileRetro {
+				t.Errorf("default changed to %q", reg.Default())
+			}
+		})
+	}
+}
+
+// TestDefaultProfilesLegacyArgs pins the built-in presets to the ffmpeg
+// command lines that were hardcoded before the registry existed.
+func TestDefaultProfilesLegacyArgs(t *testing.T) {
+	const filter = "eq=contrast=1.2"
+	input := ffmpegInput{args: []string{"-i", "in.mp4"}}
+	seeked := ffmpegInput{args: []string{"-i", "pipe:0"}, pipe: true, postSeek: true, start: 12.5}
+
+	tests := []struct {
+		profile Profile
+		input   ffmpegInput
+		want    []string
+		format  outputFormat
+	}{
+		{
+			profile: ProfileAAC,
+			input:   input,
+			want: []string{"-i", "in.mp4",
+				"-vf", "scale=320:240,fps=15",
+				"-c:v", "mpeg4", "-b:v", "256k",
+				"-c:a", "aac", "-ar", "16000", "-ac", "1", "-b:a", "32k",
+				"-movflags", "frag_keyframe+empty_moov",
+				"-f", "mp4", "pipe:1"},
+			format: outputFormat{ContentType: "video/mp4", Extension: "mp4", Suffix: "_aac", Fragmented: true},
+		},
+		{
+			profile: ProfileMP3,
+			input:   input,
+			want: []string{"-i", "in.mp4",
+				"-vf", "scale=176:144,fps=12",
+				"-c:v", "mpeg4", "-b:v", "120k",
+				"-c:a", "libmp3lame", "-ar", "11025", "-ac", "1", "-b:a", "24k",
+				"-f", "avi", "pipe:1"},
+			format: outputFormat{ContentType: "video/x-msvideo", Extension: "avi", Suffix: "_mp3"},
+		},
+		{
+			profile: ProfileEdge,
+			input:   input,
+			want: []string{"-i", "in.mp4",
+				"-vf", "scale=128:96,fps=10," + filter,
+				"-c:v", "h263", "-b:v", "60k",
+				"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "10.2k",
+				"-use_editlist", "0",
+				"-movflags", "+faststart+frag_keyframe+empty_moov",
+				"-f", "3gp", "pipe:1"},
+			format: outputFormat{ContentType: "video/3gpp", Extension: "3gp", Suffix: "_edge", Fragmented: true},
+		},
+		{
+			profile: ProfileRetro,
+			input:   input,
+			want: []string{"-i", "in.mp4",
+				"-vf", "scale=176:144,fps=12," + filter,
+				"-c:v", "h263", "-b:v", "120k",
+				"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "12.2k",
+				"-use_editlist", "0",
+				"-movflags", "+faststart+frag_keyframe+empty_moov",
+				"-f", "3gp", "pipe:1"},
+			format: outputFormat{ContentType: "video/3gpp", Extension: "3gp", Suffix: "_retro", Fragmented: true},
+		},
+		{
+			// The empty name falls back to retro, as the old switch did.
+			profile: "",
+			input:   seeked,
+			want: []string{"-i", "pipe:0", "-ss", formatSeek(12.5),
+				"-vf", "scale=176:144,fps=12," + filter,
+				"-c:v", "h263", "-b:v", "120k",
+				"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "12.2k",
+				"-use_editlist", "0",
+				"-movflags", "+faststart+frag_keyframe+empty_moov",
+				"-f", "3gp", "pipe:1"},
+			format: outputFormat{ContentType: "video/3gpp", Extension: "3gp", Suffix: "_retro", Fragmented: true},
+		},
+	}
+	svc := New().WithRetroFilter(filter)
+	for _, tt := range tests {
+		t.Run("http "+string(tt.profile), func(t *testing.T) {
+			args, format, err := svc.profileArgs(tt.profile, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("args:\n got %q\nwant %q", args, tt.want)
+			}
+			if format != tt.format {
+				t.Errorf("format = %+v, want %+v", format, tt.format)
+			}
+		})
+	}
+
+	rtspTail := []string{"-f", "rtsp", "-rtsp_transport", "tcp", "-muxdelay", "0.1", "rtsp://127.0.0.1:8554/x"}
+	rtspTests := []struct {
+		profile Profile
+		codec   []string
+	}{
+		{ProfileRetro, []string{
+			"-vf", "scale=176:144,fps=12," + filter,
+			"-c:v", "h263", "-b:v", "120k",
+			"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "12.2k"}},
+		{ProfileEdge, []string{
+			"-vf", "scale=128:96,fps=10," + filter,
+			"-c:v", "h263", "-b:v", "60k",
+			"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "10.2k"}},
+		{ProfileAndroid, []string{
+			"-vf", "scale=320:180,fps=24," + filter,
+			"-c:v", "mpeg4", "-pix_fmt", "yuv420p",
+			"-b:v", "350k", "-maxrate", "400k", "-bufsize", "800k",
+			"-bf", "0", "-g", "48", "-keyint_min", "24",
+			"-c:a", "aac", "-ar", "44100", "-ac", "2", "-b:a", "64k"}},
+	}
+	for _, tt := range rtspTests {
+		t.Run("rtsp "+string(tt.profile), func(t *testing.T) {
+			args, err := svc.profileRTSPArgs(tt.profile, input, rtspTail[len(rtspTail)-1], "tcp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append(append([]string{"-i", "in.mp4"}, tt.codec...), rtspTail...)
+			if !reflect.DeepEqual(args, want) {
+				t.Errorf("args:\n got %q\nwant %q", args, want)
+			}
+		})
+	}
+
+	for _, name := range []Profile{ProfileAAC, ProfileMP3} {
+		if _, err := svc.profileRTSPArgs(name, input, "rtsp://x", "tcp"); err == nil {
+			t.Errorf("%s: RTSP output should be refused", name)
+		}
+	}
+}
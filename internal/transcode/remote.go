@@ -0,0 +1,307 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	workerTranscodePath = "/transcode"
+	workerHealthPath    = "/health"
+	// workerTokenHeader carries the shared secret between nodes.
+	workerTokenHeader = "X-Worker-Token"
+	// workerErrorTrailer reports an ffmpeg failure after the output started.
+	workerErrorTrailer = "X-Transcode-Error"
+
+	defaultWorkerHealthInterval = 10 * time.Second
+	workerHealthTimeout         = 3 * time.Second
+	// workerHeaderTimeout bounds the wait for a worker's answer to a job,
+	// which comes with ffmpeg's first output; a stuck worker is skipped.
+	workerHeaderTimeout = 15 * time.Second
+)
+
+// WorkerHealth is what a worker node reports on /health.
+type WorkerHealth struct {
+	Active int `json:"active"`
+	// Capacity is the worker's concurrent job limit, 0 when unlimited.
+	Capacity int `json:"capacity"`
+}
+
+// RemoteBackend sends HTTP transcodes to worker nodes (see WorkerHandler)
+// and streams their output back. Workers are health-checked in the
+// background and each job goes to the least loaded healthy one. Commands
+// without a TranscodeSpec, or with no worker available, run locally.
+type RemoteBackend struct {
+	client  *http.Client
+	token   string
+	workers []*remoteWorker
+	done    chan struct{}
+}
+
+type remoteWorker struct {
+	base string
+	mu   sync.Mutex
+	// healthy and reported come from the last health check; sent counts
+	// the jobs this node dispatched since.
+	healthy  bool
+	reported WorkerHealth
+	sent     int
+}
+
+// NewRemoteBackend health-checks the workers at urls (e.g.
+// "http://10.0.0.2:8091") every interval (0 = 10s) until Close.
+func NewRemoteBackend(urls []string, token string, interval time.Duration) *RemoteBackend {
+	if interval <= 0 {
+		interval = defaultWorkerHealthInterval
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = workerHeaderTimeout
+	b := &RemoteBackend{
+		// No overall timeout: responses last as long as the transcode.
+		client: &http.Client{Transport: transport},
+		token:  token,
+		done:   make(chan struct{}),
+	}
+	for _, raw := range urls {
+		if base := strings.TrimRight(strings.TrimSpace(raw), "/"); base != "" {
+			b.workers = append(b.workers, &remoteWorker{base: base})
+		}
+	}
+	go b.watch(interval)
+	return b
+}
+
+// Close stops the health checks.
+func (b *RemoteBackend) Close() {
+	safeClose(b.done)
+}
+
+// Start implements Backend.
+func (b *RemoteBackend) Start(ctx context.Context, c Command) (Process, error) {
+	if c.Spec == nil {
+		return nil, ErrRunLocally
+	}
+	body, err := json.Marshal(c.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("worker: %w", err)
+	}
+	for _, w := range b.candidates() {
+		proc, err := b.dispatch(ctx, w, body, c.Stdout)
+		if err == nil {
+			return proc, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("[worker] %s: %v", w.base, err)
+	}
+	return nil, ErrRunLocally
+}
+
+func (b *RemoteBackend) dispatch(ctx context.Context, w *remoteWorker, body []byte, stdout io.Writer) (Process, error) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(jobCtx, http.MethodPost, w.base+workerTranscodePath, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(workerTokenHeader, b.token)
+
+	w.dispatched()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		cancel()
+		w.setHealthy(false)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	p := &remoteProcess{worker: w, resp: resp, stdout: stdout, ctx: jobCtx, cancel: cancel}
+	p.body = &eofReader{r: resp.Body}
+	return p, nil
+}
+
+// candidates lists the healthy workers, least loaded first.
+func (b *RemoteBackend) candidates() []*remoteWorker {
+	type scored struct {
+		w    *remoteWorker
+		load float64
+	}
+	var out []scored
+	for _, w := range b.workers {
+		if load, ok := w.load(); ok {
+			out = append(out, scored{w, load})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].load < out[j].load })
+	workers := make([]*remoteWorker, len(out))
+	for i, s := range out {
+		workers[i] = s.w
+	}
+	return workers
+}
+
+func (b *RemoteBackend) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, w := range b.workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b.check(w)
+			}()
+		}
+		wg.Wait()
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *RemoteBackend) check(w *remoteWorker) {
+	ctx, cancel := context.WithTimeout(context.Background(), workerHealthTimeout)
+	defer cancel()
+	var health WorkerHealth
+	err := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.base+workerHealthPath, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(workerTokenHeader, b.token)
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.New(resp.Status)
+		}
+		return json.NewDecoder(resp.Body).Decode(&health)
+	}()
+
+	w.mu.Lock()
+	was := w.healthy
+	w.healthy = err == nil
+	if err == nil {
+		w.reported = health
+		w.sent = 0
+	}
+	w.mu.Unlock()
+	switch {
+	case err != nil && was:
+		log.Printf("[worker] %s down: %v", w.base, err)
+	case err == nil && !was:
+		log.Printf("[worker] %s up (%d/%d jobs)", w.base, health.Active, health.Capacity)
+	}
+}
+
+// load is the worker's share of its capacity in use, counting jobs sent
+// since the last health check as running; ok is false when the worker is
+// down or full.
+func (w *remoteWorker) load() (float64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.healthy {
+		return 0, false
+	}
+	active := w.reported.Active + w.sent
+	if w.reported.Capacity <= 0 {
+		return float64(active), true
+	}
+	if active >= w.reported.Capacity {
+		return 0, false
+	}
+	return float64(active) / float64(w.reported.Capacity), true
+}
+
+func (w *remoteWorker) dispatched() {
+	w.mu.Lock()
+	w.sent++
+	w.mu.Unlock()
+}
+
+func (w *remoteWorker) setHealthy(ok bool) {
+	w.mu.Lock()
+	w.healthy = ok
+	w.mu.Unlock()
+}
+
+// remoteProcess is a transcode running on a worker; its output is the
+// response body. Worker-side ffmpeg logs stay on the worker.
+type remoteProcess struct {
+	worker *remoteWorker
+	resp   *http.Response
+	body   *eofReader
+	stdout io.Writer
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (p *remoteProcess) Stdin() io.WriteCloser { return nil }
+func (p *remoteProcess) Stderr() io.Reader     { return strings.NewReader("") }
+
+func (p *remoteProcess) Stdout() io.Reader {
+	if p.stdout != nil {
+		return nil
+	}
+	return p.body
+}
+
+func (p *remoteProcess) Wait() error {
+	defer p.cancel()
+	if p.stdout != nil {
+		if _, err := io.Copy(p.stdout, p.body); err != nil {
+			p.resp.Body.Close()
+			return err
+		}
+	}
+	p.resp.Body.Close()
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	if !p.body.eof {
+		return fmt.Errorf("worker %s: output cut short", p.worker.base)
+	}
+	if msg := p.resp.Trailer.Get(workerErrorTrailer); msg != "" {
+		return fmt.Errorf("worker %s: %s", p.worker.base, msg)
+	}
+	return nil
+}
+
+func (p *remoteProcess) Kill() error {
+	p.cancel()
+	return nil
+}
+
+// eofReader remembers whether r was read to the end.
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (e *eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		e.eof = true
+	}
+	return n, err
+}
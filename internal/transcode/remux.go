@@ -0,0 +1,96 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// remuxProfile labels remux jobs for the scheduler and the job list. Copying
+// streams costs next to no CPU, but each job still holds two upstream
+// connections.
+const remuxProfile Profile = "remux"
+
+// ServeRemux copies the picture of src.URL and the sound of src.AudioURL into
+// one fragmented MP4 without re-encoding, so adaptive 720p/1080p renditions
+// play as a single file. The output is produced on the fly and cannot answer
+// byte ranges; start seeks both inputs instead.
+func (s *Service) ServeRemux(w http.ResponseWriter, r *http.Request, videoID string, src Source, start float64) {
+	setHeaders := func(h http.Header) {
+		h.Set("Content-Type", "video/mp4")
+		h.Set("Accept-Ranges", "none")
+		h.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.mp4"`, videoID))
+	}
+	if r.Method == http.MethodHead {
+		setHeaders(w.Header())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	out := &lazyWriter{w: w, header: setHeaders}
+	err := s.remux(WithClient(r.Context(), r.RemoteAddr), videoID, src, start, out)
+	if err == nil || out.started {
+		if err != nil {
+			log.Printf("[remux] id=%s: %v", videoID, err)
+		}
+		return
+	}
+	var busy *BusyError
+	if errors.As(err, &busy) {
+		w.Header().Set("Retry-After", busy.RetryAfterSeconds())
+		http.Error(w, "transcoder busy, try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+func (s *Service) remux(ctx context.Context, videoID string, src Source, start float64, stdout io.Writer) error {
+	// No -re: the client's read rate already paces the copy.
+	input, cleanup, err := s.buildInputPaced(src, start, false)
+	if err != nil {
+		return err
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	proc, release, err := s.startScheduled(ctx, ctx, Command{Args: remuxArgs(input), Stdin: input.pipe, Stdout: stdout}, remuxProfile, clientFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer release()
+	if stdin := proc.Stdin(); stdin != nil {
+		s.startInputPump(ctx, stdin, input.srcURL)
+	}
+	job := s.jobs.add(JobHTTP, videoID, remuxProfile, clientFromContext(ctx), start)
+	go logFFmpeg(proc.Stderr(), "[ffmpeg remux]", job)
+
+	err = proc.Wait()
+	stopped := ctx.Err() != nil
+	s.jobs.done(job, err != nil && !stopped)
+	if err != nil && !stopped {
+		return fmt.Errorf("ffmpeg remux: %w", err)
+	}
+	return nil
+}
+
+// remuxArgs copies the streams into MP4 fragments that start playing before
+// the whole file exists: an empty moov up front, one fragment per keyframe.
+func remuxArgs(input ffmpegInput) []string {
+	args := append([]string{}, input.args...)
+	if input.pipe && input.postSeek {
+		args = append(args, "-ss", formatSeek(input.start))
+	}
+	if input.separateAudio {
+		args = append(args, input.mapArgs(true, true)...)
+	} else {
+		args = append(args, "-map", "0:v:0", "-map", "0:a:0?")
+	}
+	return append(args,
+		"-c", "copy",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "pipe:1",
+	)
+}
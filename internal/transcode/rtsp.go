@@ -4,12 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"math"
 	"net"
 	"net/url"
-	"os/exec"
 	"sort"
 	"strconv"
 	"strings"
@@ -28,18 +25,20 @@ const (
 	defaultRTSPAddress   = ":8554"
 	rtspPublisherTimeout = 12 * time.Second
 	rtspResolveTimeout   = 15 * time.Second
+	defaultRTSPIdle      = 15 * time.Second
 )
 
-// StreamResolver resolves a YouTube video ID into a direct stream URL.
+// StreamResolver lists the upstream renditions of a YouTube video; the
+// source picker chooses among them per output profile.
 type StreamResolver interface {
-	ResolveStream(ctx context.Context, videoID string) (string, error)
+	ResolveSources(ctx context.Context, videoID string) ([]SourceFormat, error)
 }
 
 // StreamResolverFunc is an adapter to allow the use of regular functions as resolvers.
-type StreamResolverFunc func(ctx context.Context, videoID string) (string, error)
+type StreamResolverFunc func(ctx context.Context, videoID string) ([]SourceFormat, error)
 
-// ResolveStream implements StreamResolver.
-func (fn StreamResolverFunc) ResolveStream(ctx context.Context, videoID string) (string, error) {
+// ResolveSources implements StreamResolver.
+func (fn StreamResolverFunc) ResolveSources(ctx context.Context, videoID string) ([]SourceFormat, error) {
 	return fn(ctx, videoID)
 }
 
@@ -49,6 +48,15 @@ func (s *Service) WithStreamResolver(res StreamResolver) *Service {
 	return s
 }
 
+// WithRTSPIdleTimeout sets how long an RTSP publisher may run without any
+// playing reader (all paused or disconnected) before it is stopped.
+func (s *Service) WithRTSPIdleTimeout(d time.Duration) *Service {
+	if d > 0 {
+		s.rtspIdle = d
+	}
+	return s
+}
+
 // EnableRTSP spins up the internal RTSP server if not already running.
 func (s *Service) EnableRTSP(addr string) error {
 	if s.resolver == nil {
@@ -80,41 +88,81 @@ func (s *Service) RTSPEnabled() bool {
 	return s.rtsp != nil
 }
 
-// RTSPURL returns the public RTSP URL for a given profile and video ID.
+// RTSPURL returns the public RTSP URL for a given profile and video ID, or
+// the http:// tunnel URL when the RTSP-over-HTTP tunnel is enabled.
 func (s *Service) RTSPURL(host string, profile Profile, videoID string) string {
 	if s.rtsp == nil {
 		return ""
 	}
+	if s.RTSPTunnelEnabled() {
+		return s.rtsp.tunnelURL(host, profile, videoID)
+	}
 	return s.rtsp.publicURL(host, profile, videoID)
 }
 
-func (s *Service) resolveStream(ctx context.Context, videoID string) (string, error) {
+// resolveSource lists the video's renditions and picks the cheapest inputs
+// that satisfy needs.
+func (s *Service) resolveSource(ctx context.Context, videoID string, needs sourceNeeds) (Source, error) {
 	if s.resolver == nil {
-		return "", errors.New("rtsp: stream resolver not configured")
+		return Source{}, errors.New("rtsp: stream resolver not configured")
 	}
-	return s.resolver.ResolveStream(ctx, videoID)
+	formats, err := s.resolver.ResolveSources(ctx, videoID)
+	if err != nil {
+		return Source{}, err
+	}
+	return pickSource(formats, needs)
 }
 
 type rtspServer struct {
-	svc        *Service
-	addr       string
-	port       int
-	server     *gortsplib.Server
-	mu         sync.Mutex
-	streams    map[string]*rtspStream
+	svc    *Service
+	addr   string
+	port   int
+	server *gortsplib.Server
+	mu     sync.Mutex
+	// streams holds the running timelines per path and query; readers on
+	// the same offset share one, a seek elsewhere gets its own.
+	streams    map[string][]*rtspStream
+	timelines  map[string]*rtspStream
 	publishers map[*gortsplib.ServerSession]*rtspStream
+	readers    map[*gortsplib.ServerSession]*rtspReader
+	nextID     uint64
+	done       chan struct{}
+	// tlsServer is the optional RTSPS listener; see EnableRTSPS.
+	tlsServer *gortsplib.Server
+	tlsPort   int
+	// tunnels maps x-sessioncookie to open RTSP-over-HTTP tunnels, and
+	// tunnelClients the loopback address of each to its HTTP client; both
+	// stay nil until EnableRTSPTunnel.
+	tunnels       map[string]*rtspTunnel
+	tunnelClients map[string]string
+}
+
+// rtspListener handles one gortsplib server, plain or TLS, on behalf of the
+// shared rtspServer: a reader's stream must belong to the server its
+// session runs on.
+type rtspListener struct {
+	*rtspServer
+	srv *gortsplib.Server
+	// described holds the streams DESCRIBE handed to RTSPS connections.
+	// Their SRTP keys went out in the SDP, so SETUP on the same connection
+	// has to use the same stream. Guarded by rtspServer.mu.
+	described map[*gortsplib.ServerConn]*gortsplib.ServerStream
 }
 
 func newRTSPServer(svc *Service, addr string) (*rtspServer, error) {
 	rs := &rtspServer{
 		svc:        svc,
 		addr:       addr,
-		streams:    make(map[string]*rtspStream),
+		streams:    make(map[string][]*rtspStream),
+		timelines:  make(map[string]*rtspStream),
 		publishers: make(map[*gortsplib.ServerSession]*rtspStream),
+		readers:    make(map[*gortsplib.ServerSession]*rtspReader),
+		done:       make(chan struct{}),
 	}
 
+	listener := &rtspListener{rtspServer: rs}
 	rtspSrv := &gortsplib.Server{
-		Handler:        rs,
+		Handler:        listener,
 		RTSPAddress:    addr,
 		UDPRTPAddress:  svc.udpRTPAddr,
 		UDPRTCPAddress: svc.udpRTCPAddr,
@@ -126,16 +174,22 @@ func newRTSPServer(svc *Service, addr string) (*rtspServer, error) {
 		return nil, fmt.Errorf("rtsp: start: %w", err)
 	}
 
+	listener.srv = rtspSrv
 	rs.server = rtspSrv
 	rs.port = extractPort(addr)
+	go rs.reap(svc.rtspIdle)
 	log.Printf("[rtsp] listening on %s", addr)
 	return rs, nil
 }
 
 func (r *rtspServer) close() {
+	safeClose(r.done)
 	if r.server != nil {
 		r.server.Close()
 	}
+	if r.tlsServer != nil {
+		r.tlsServer.Close()
+	}
 }
 
 func extractPort(address string) int {
@@ -164,15 +218,30 @@ func extractPort(address string) int {
 }
 
 func (r *rtspServer) publicURL(host string, profile Profile, videoID string) string {
+	return r.publicURLFor("rtsp", r.port, host, profile, videoID)
+}
+
+// publicURLFor builds a reader URL, signed when URL signing is enabled.
+func (r *rtspServer) publicURLFor(scheme string, port int, host string, profile Profile, videoID string) string {
 	if host == "" {
 		host = "localhost"
 	}
 	host = stripPort(host)
+	path := r.readerURLPath(profile, videoID)
+	if port > 0 {
+		return fmt.Sprintf("%s://%s:%d/%s", scheme, host, port, path)
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, host, path)
+}
+
+// readerURLPath is the path (and token, when URL signing is enabled) of a
+// reader URL.
+func (r *rtspServer) readerURLPath(profile Profile, videoID string) string {
 	path := r.pathFor(profile, videoID)
-	if r.port > 0 {
-		return fmt.Sprintf("rtsp://%s:%d/%s", host, r.port, path)
+	if auth := r.svc.rtspAuth; len(auth.secret) > 0 {
+		path += "?" + rtspTokenParam + "=" + url.QueryEscape(auth.sign(path, time.Now()))
 	}
-	return fmt.Sprintf("rtsp://%s/%s", host, path)
+	return path
 }
 
 func stripPort(hostport string) string {
@@ -197,24 +266,20 @@ func stripPort(hostport string) string {
 }
 
 func (r *rtspServer) pathFor(profile Profile, videoID string) string {
-	return fmt.Sprintf("%s/%s.3gp", profileSegment(profile), videoID)
+	if profile == "" {
+		profile = r.svc.profiles.Default()
+	}
+	return fmt.Sprintf("%s/%s.3gp", profile, videoID)
 }
 
-func profileSegment(profile Profile) string {
-	switch profile {
-	case ProfileEdge:
-		return "edge"
-	case ProfileAndroid:
-		return "android"
-	case ProfileRetro, "":
-		return "retro"
-	default:
-		return string(profile)
-	}
+// readerPath is a reader's request path without surrounding slashes or the
+// tunnel prefix.
+func readerPath(path string) string {
+	return strings.TrimPrefix(strings.Trim(path, "/"), rtspTunnelPrefix)
 }
 
 func (r *rtspServer) parsePath(path, query string) (Profile, string, float64, string, error) {
-	trimmed := strings.Trim(path, "/")
+	trimmed := readerPath(path)
 	if trimmed == "" {
 		return "", "", 0, "", errors.New("empty path")
 	}
@@ -233,35 +298,61 @@ func (r *rtspServer) parsePath(path, query string) (Profile, string, float64, st
 	start := parseStartFromQuery(query)
 	transport := transportFromQuery(query, r.svc.rtspTransport)
 
-	switch profilePart {
-	case "", "retro":
-		return ProfileRetro, videoPart, start, transport, nil
-	case "edge":
-		return ProfileEdge, videoPart, start, transport, nil
-	case "android":
-		return ProfileAndroid, videoPart, start, transport, nil
-	default:
+	spec, ok := r.svc.profiles.Lookup(Profile(profilePart))
+	if !ok || !spec.RTSP {
 		return "", "", 0, "", fmt.Errorf("unsupported profile %q", profilePart)
 	}
+	return spec.Name, videoPart, start, transport, nil
 }
 
-func (r *rtspServer) getStream(path, query string) *rtspStream {
-	key := canonicalKey(path, query)
+// timelineFor returns a running timeline of key whose playback position is
+// close to offset, or creates one starting there. query is the canonical
+// query without the start offset.
+func (r *rtspServer) timelineFor(key, path, query string, profile Profile, videoID string, offset float64, transport string) (*rtspStream, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.streams[key]
+	for _, existing := range r.streams[key] {
+		if existing.shareable(offset) {
+			return existing, false
+		}
+	}
+	r.nextID++
+	id := strconv.FormatUint(r.nextID, 10)
+	stream := newRTSPStream(r, id, key, path, query, profile, videoID, offset, transport)
+	r.streams[key] = append(r.streams[key], stream)
+	r.timelines[id] = stream
+	return stream, true
 }
 
-func (r *rtspServer) getOrCreateStream(path, query string, profile Profile, videoID string, start float64, transport string) *rtspStream {
-	key := canonicalKey(path, query)
+// publisherTimeline finds the timeline an internal ffmpeg publishes to by
+// the publisher id in its URL.
+func (r *rtspServer) publisherTimeline(query string) *rtspStream {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if existing, ok := r.streams[key]; ok {
-		return existing
+	return r.timelines[values.Get(rtspPublisherParam)]
+}
+
+// reserve takes a scheduler slot for a new timeline. On refusal the timeline
+// is failed and the response to send is returned.
+func (r *rtspServer) reserve(stream *rtspStream, client string) *base.Response {
+	release, err := r.svc.scheduler.Acquire(context.Background(), stream.profile, client)
+	if err != nil {
+		stream.fail(err)
+		var busy *BusyError
+		if errors.As(err, &busy) {
+			return &base.Response{
+				StatusCode: base.StatusNotEnoughBandwidth,
+				Header:     base.Header{"Retry-After": base.HeaderValue{busy.RetryAfterSeconds()}},
+			}
+		}
+		return &base.Response{StatusCode: base.StatusServiceUnavailable}
 	}
-	stream := newRTSPStream(r, key, canonicalPath(path), canonicalQuery(query), profile, videoID, start, transport)
-	r.streams[key] = stream
-	return stream
+	stream.setRelease(release, client)
+	return nil
 }
 
 func (r *rtspServer) registerPublisher(session *gortsplib.ServerSession, stream *rtspStream) {
@@ -278,28 +369,81 @@ func (r *rtspServer) streamByPublisher(session *gortsplib.ServerSession) *rtspSt
 
 func (r *rtspServer) removeStream(stream *rtspStream) {
 	r.mu.Lock()
-	if current, ok := r.streams[stream.key]; ok && current == stream {
+	timelines := r.streams[stream.key]
+	for i, st := range timelines {
+		if st == stream {
+			timelines = append(timelines[:i:i], timelines[i+1:]...)
+			break
+		}
+	}
+	if len(timelines) == 0 {
 		delete(r.streams, stream.key)
+	} else {
+		r.streams[stream.key] = timelines
+	}
+	if r.timelines[stream.id] == stream {
+		delete(r.timelines, stream.id)
 	}
 	for sess, st := range r.publishers {
 		if st == stream {
 			delete(r.publishers, sess)
 		}
 	}
+	// Readers still on this timeline have nothing left to watch.
+	var orphans []*rtspReader
+	for sess, reader := range r.readers {
+		// Paused readers are not watching; they resume on a new timeline.
+		if reader.timeline == stream && !reader.paused {
+			delete(r.readers, sess)
+			orphans = append(orphans, reader)
+		}
+	}
 	r.mu.Unlock()
 
+	for _, reader := range orphans {
+		stream.removeReader(reader)
+		reader.close()
+	}
 	stream.shutdown()
 }
 
-// OnDescribe handles DESCRIBE requests.
-func (r *rtspServer) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
+// OnDescribe handles DESCRIBE requests. Plain clients get the timeline's
+// stream; RTSPS clients get the stream they will set up, see described.
+func (l *rtspListener) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	res, stream, err := l.describe(ctx)
+	if stream == nil || l.srv.TLSConfig == nil {
+		return res, stream, err
+	}
+	readerStream := &gortsplib.ServerStream{Server: l.srv, Desc: stream.Desc}
+	if err := readerStream.Initialize(); err != nil {
+		return &base.Response{StatusCode: base.StatusInternalServerError}, nil, err
+	}
+	l.mu.Lock()
+	previous := l.described[ctx.Conn]
+	if l.described == nil {
+		l.described = make(map[*gortsplib.ServerConn]*gortsplib.ServerStream)
+	}
+	l.described[ctx.Conn] = readerStream
+	l.mu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return res, readerStream, err
+}
+
+func (r *rtspServer) describe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
 	profile, videoID, start, transport, err := r.parsePath(ctx.Path, ctx.Query)
 	if err != nil {
 		return &base.Response{StatusCode: base.StatusNotFound}, nil, nil
 	}
+	if res, err := r.authorize(ctx.Conn, ctx.Request, ctx.Path, ctx.Query); res != nil {
+		return res, nil, err
+	}
 
-	stream := r.getOrCreateStream(ctx.Path, ctx.Query, profile, videoID, start, transport)
-	stream.ensureStarted()
+	stream, res := r.startTimeline(ctx.Path, ctx.Query, profile, videoID, start, transport, r.connClient(ctx.Conn))
+	if res != nil {
+		return res, nil, nil
+	}
 
 	waitCtx, cancel := context.WithTimeout(context.Background(), rtspPublisherTimeout)
 	defer cancel()
@@ -312,14 +456,31 @@ func (r *rtspServer) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*bas
 	return &base.Response{StatusCode: base.StatusOK}, srvStream, nil
 }
 
+// startTimeline joins or creates the timeline for a reader request and makes
+// sure its publisher is running.
+func (r *rtspServer) startTimeline(path, query string, profile Profile, videoID string, start float64, transport, client string) (*rtspStream, *base.Response) {
+	path = readerPath(path)
+	timelineQuery := canonicalQuery(withoutReaderParams(query))
+	key := canonicalKey(path, timelineQuery)
+	stream, created := r.timelineFor(key, canonicalPath(path), timelineQuery, profile, videoID, start, transport)
+	if created {
+		if res := r.reserve(stream, client); res != nil {
+			return nil, res
+		}
+	}
+	stream.ensureStarted()
+	return stream, nil
+}
+
 // OnAnnounce handles ANNOUNCE from the internal ffmpeg publisher.
 func (r *rtspServer) OnAnnounce(ctx *gortsplib.ServerHandlerOnAnnounceCtx) (*base.Response, error) {
-	stream := r.getStream(ctx.Path, ctx.Query)
+	stream := r.publisherTimeline(ctx.Query)
 	if stream == nil {
 		return &base.Response{StatusCode: base.StatusNotFound}, nil
 	}
 
-	if !isLocalPublisher(ctx.Conn.NetConn().RemoteAddr()) {
+	addr := ctx.Conn.NetConn().RemoteAddr()
+	if !isLocalPublisher(addr) || r.tunnelledClient(addr) != "" {
 		return &base.Response{StatusCode: base.StatusForbidden}, nil
 	}
 
@@ -330,44 +491,103 @@ func (r *rtspServer) OnAnnounce(ctx *gortsplib.ServerHandlerOnAnnounceCtx) (*bas
 	return &base.Response{StatusCode: base.StatusOK}, nil
 }
 
-// OnSetup handles SETUP requests.
-func (r *rtspServer) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+// OnSetup handles SETUP requests. Every reader session gets its own
+// ServerStream fed by a shared timeline, so it can later move to another
+// timeline without disturbing the other readers.
+func (l *rtspListener) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
 	// publisher path, no stream yet required.
 	if ctx.Session.State() == gortsplib.ServerSessionStatePreRecord {
 		return &base.Response{StatusCode: base.StatusOK}, nil, nil
 	}
 
-	stream := r.getStream(ctx.Path, ctx.Query)
-	if stream == nil {
+	if reader := l.readerFor(ctx.Session); reader != nil {
+		return &base.Response{StatusCode: base.StatusOK}, reader.stream, nil
+	}
+
+	profile, videoID, start, transport, err := l.parsePath(ctx.Path, ctx.Query)
+	if err != nil {
 		return &base.Response{StatusCode: base.StatusNotFound}, nil, nil
 	}
-	srvStream := stream.currentStream()
-	if srvStream == nil {
+	if res, err := l.authorize(ctx.Conn, ctx.Request, ctx.Path, ctx.Query); res != nil {
+		return res, nil, err
+	}
+	stream, res := l.startTimeline(ctx.Path, ctx.Query, profile, videoID, start, transport, l.connClient(ctx.Conn))
+	if res != nil {
+		return res, nil, nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), rtspPublisherTimeout)
+	defer cancel()
+	srvStream, err := stream.waitReady(waitCtx)
+	if err != nil {
 		return &base.Response{StatusCode: base.StatusNotFound}, nil, nil
 	}
 
-	return &base.Response{StatusCode: base.StatusOK}, srvStream, nil
+	var reader *rtspReader
+	if described := l.takeDescribed(ctx.Conn); described != nil && described.Desc == srvStream.Desc {
+		reader = &rtspReader{session: ctx.Session, stream: described, offset: start}
+	} else {
+		if described != nil {
+			described.Close()
+		}
+		if reader, err = newRTSPReader(l.srv, ctx.Session, srvStream.Desc, start); err != nil {
+			return &base.Response{StatusCode: base.StatusInternalServerError}, nil, err
+		}
+	}
+	l.addReader(reader, stream)
+	return &base.Response{StatusCode: base.StatusOK}, reader.stream, nil
 }
 
-// OnPlay handles PLAY requests.
+func (l *rtspListener) takeDescribed(conn *gortsplib.ServerConn) *gortsplib.ServerStream {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stream := l.described[conn]
+	delete(l.described, conn)
+	return stream
+}
+
+// OnConnClose drops a described stream no session went on to set up.
+func (l *rtspListener) OnConnClose(ctx *gortsplib.ServerHandlerOnConnCloseCtx) {
+	if stream := l.takeDescribed(ctx.Conn); stream != nil {
+		stream.Close()
+	}
+}
+
+// OnPlay handles PLAY requests. A Range that points elsewhere than the
+// session's timeline moves only this session to a matching timeline.
 func (r *rtspServer) OnPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
-	stream := r.getStream(ctx.Path, ctx.Query)
-	if stream == nil {
+	reader := r.readerFor(ctx.Session)
+	if reader == nil {
 		return &base.Response{StatusCode: base.StatusNotFound}, nil
 	}
 
-	if offset, ok := rangeStartSeconds(ctx.Request); ok {
-		if err := stream.seek(offset); err != nil {
-			return &base.Response{StatusCode: base.StatusInvalidRange}, err
+	offset, ok := rangeStartSeconds(ctx.Request)
+	if !ok {
+		offset, ok = r.pausedAt(reader)
+	}
+	if ok {
+		first := ctx.Session.State() == gortsplib.ServerSessionStatePrePlay
+		if res, err := r.seekReader(reader, offset, first, r.connClient(ctx.Conn)); res != nil {
+			return res, err
 		}
 	}
 
 	return &base.Response{StatusCode: base.StatusOK}, nil
 }
 
+// OnPause handles PAUSE requests. The session leaves its timeline and keeps
+// the position it reached; the next PLAY resumes from there. A timeline left
+// without readers is stopped once it has been idle for the grace period.
+func (r *rtspServer) OnPause(ctx *gortsplib.ServerHandlerOnPauseCtx) (*base.Response, error) {
+	if reader := r.readerFor(ctx.Session); reader != nil {
+		r.pauseReader(reader)
+	}
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
 // OnRecord handles RECORD requests from the publisher.
 func (r *rtspServer) OnRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.Response, error) {
-	stream := r.getStream(ctx.Path, ctx.Query)
+	stream := r.publisherTimeline(ctx.Query)
 	if stream == nil {
 		return &base.Response{StatusCode: base.StatusNotFound}, nil
 	}
@@ -377,10 +597,11 @@ func (r *rtspServer) OnRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.Re
 
 // OnSessionClose cleans up streams when sessions terminate.
 func (r *rtspServer) OnSessionClose(ctx *gortsplib.ServerHandlerOnSessionCloseCtx) {
-	stream := r.streamByPublisher(ctx.Session)
-	if stream != nil {
+	if stream := r.streamByPublisher(ctx.Session); stream != nil {
 		r.removeStream(stream)
+		return
 	}
+	r.removeReader(ctx.Session)
 }
 
 func isLocalPublisher(addr net.Addr) bool {
@@ -400,6 +621,7 @@ func isLocalPublisher(addr net.Addr) bool {
 
 type rtspStream struct {
 	server      *rtspServer
+	id          string
 	key         string
 	path        string
 	query       string
@@ -412,16 +634,23 @@ type rtspStream struct {
 	stream    *gortsplib.ServerStream
 	publisher *gortsplib.ServerSession
 	cancel    context.CancelFunc
-	cmd       *exec.Cmd
+	proc      Process
 	err       error
 	cleanup   func()
+	release   func()
+	client    string
 	running   bool
 	ready     chan struct{}
+	readyAt   time.Time
+	readers   map[*rtspReader]struct{}
+	// idleSince is when the last reader left; zero while someone watches.
+	idleSince time.Time
 }
 
-func newRTSPStream(server *rtspServer, key, path, query string, profile Profile, videoID string, start float64, transport string) *rtspStream {
+func newRTSPStream(server *rtspServer, id, key, path, query string, profile Profile, videoID string, start float64, transport string) *rtspStream {
 	return &rtspStream{
 		server:      server,
+		id:          id,
 		key:         key,
 		path:        path,
 		query:       query,
@@ -430,6 +659,7 @@ func newRTSPStream(server *rtspServer, key, path, query string, profile Profile,
 		startOffset: start,
 		transport:   transport,
 		ready:       make(chan struct{}),
+		readers:     make(map[*rtspReader]struct{}),
 	}
 }
 
@@ -455,7 +685,7 @@ func (rs *rtspStream) launchPublisher() {
 	rs.mu.Unlock()
 
 	resolveCtx, resolveCancel := context.WithTimeout(ctx, rtspResolveTimeout)
-	streamURL, err := rs.server.svc.resolveStream(resolveCtx, rs.videoID)
+	src, err := rs.server.svc.resolveSource(resolveCtx, rs.videoID, rs.server.svc.sourceNeedsFor(rs.profile))
 	resolveCancel()
 	if err != nil {
 		rs.setRunning(false)
@@ -463,12 +693,25 @@ func (rs *rtspStream) launchPublisher() {
 		return
 	}
 
-	input, cleanup, err := rs.server.svc.buildInput(streamURL, rs.startOffset)
+	input, cleanup, err := rs.server.svc.buildInput(src, rs.startOffset)
 	if err != nil {
 		rs.setRunning(false)
 		rs.fail(err)
 		return
 	}
+	if subs, ok := rs.subtitles(); ok {
+		if removeSubs, err := rs.server.svc.attachSubtitles(ctx, &input, rs.videoID, subs); err != nil {
+			log.Printf("[rtsp] id=%s: %v (continuing without)", rs.videoID, err)
+		} else if cleanup != nil {
+			inputCleanup := cleanup
+			cleanup = func() {
+				inputCleanup()
+				removeSubs()
+			}
+		} else {
+			cleanup = removeSubs
+		}
+	}
 	rs.setCleanup(cleanup)
 
 	target := rs.publishURL()
@@ -483,133 +726,79 @@ func (rs *rtspStream) launchPublisher() {
 		return
 	}
 
-	cmd := exec.CommandContext(ctx, rs.server.svc.command, args...)
-	var stdin io.WriteCloser
-	if input.pipe {
-		stdInPipe, err := cmd.StdinPipe()
-		if err != nil {
-			if cb := rs.takeCleanup(); cb != nil {
-				cb()
-			}
-			rs.setRunning(false)
-			rs.fail(fmt.Errorf("stdin pipe: %w", err))
-			return
-		}
-		stdin = stdInPipe
-	}
-	stderr, err := cmd.StderrPipe()
+	// The publisher pushes to the loopback listener, so it always runs here.
+	proc, err := LocalBackend{Command: rs.server.svc.command}.Start(ctx, Command{Args: args, Stdin: input.pipe})
 	if err != nil {
-		if stdin != nil {
-			_ = stdin.Close()
-		}
 		if cb := rs.takeCleanup(); cb != nil {
 			cb()
 		}
 		rs.setRunning(false)
-		rs.fail(fmt.Errorf("stderr pipe: %w", err))
-		return
-	}
-
-	if err := cmd.Start(); err != nil {
-		if stdin != nil {
-			_ = stdin.Close()
-		}
-		if cb := rs.takeCleanup(); cb != nil {
-			cb()
-		}
-		rs.setRunning(false)
-		rs.fail(fmt.Errorf("ffmpeg start: %w", err))
+		rs.fail(err)
 		return
 	}
 
-	if input.pipe && stdin != nil {
+	if stdin := proc.Stdin(); stdin != nil {
 		rs.server.svc.startInputPump(ctx, stdin, input.srcURL)
 	}
 
 	rs.mu.Lock()
-	rs.cmd = cmd
+	rs.proc = proc
+	client := rs.client
 	rs.mu.Unlock()
 
-	go rs.logStderr(stderr)
+	job := rs.server.svc.jobs.add(JobRTSP, rs.videoID, rs.profile, client, input.start)
+	go logFFmpeg(proc.Stderr(), "[ffmpeg rtsp]", job)
 
 	go func() {
-		err := cmd.Wait()
-		if err != nil && ctx.Err() == nil && !errors.Is(err, context.Canceled) {
+		err := proc.Wait()
+		failed := err != nil && ctx.Err() == nil && !errors.Is(err, context.Canceled)
+		rs.server.svc.jobs.done(job, failed)
+		if failed {
 			log.Printf("[rtsp] ffmpeg wait: %v", err)
 		}
 		if cb := rs.takeCleanup(); cb != nil {
 			cb()
 		}
 		rs.mu.Lock()
-		if rs.cmd == cmd {
-			rs.cmd = nil
+		if rs.proc == proc {
+			rs.proc = nil
 		}
 		rs.running = false
+		published := rs.publisher != nil
 		rs.mu.Unlock()
+		if !published && ctx.Err() == nil {
+			// Nobody will announce; fail the readers waiting in DESCRIBE
+			// or SETUP instead of leaving them to the publisher timeout.
+			cause := errors.New("ffmpeg exited before publishing")
+			if err != nil {
+				cause = fmt.Errorf("ffmpeg exited before publishing: %w", err)
+			}
+			rs.fail(cause)
+		}
 	}()
 }
 
-func (rs *rtspStream) publishURL() string {
-	host := "127.0.0.1"
-	port := rs.server.port
-	if port == 0 {
-		port = 8554
+// subtitles reads ?subs= from the stream URL. The query is part of the stream
+// key, so every caption choice gets its own publisher.
+func (rs *rtspStream) subtitles() (SubtitleRequest, bool) {
+	// Radio profiles have no picture to burn captions into.
+	if spec, ok := rs.server.svc.profiles.Lookup(rs.profile); ok && spec.Video == nil {
+		return SubtitleRequest{}, false
 	}
-	path := strings.TrimPrefix(rs.path, "/")
-	if rs.query != "" {
-		path = fmt.Sprintf("%s?%s", path, rs.query)
-	}
-	return fmt.Sprintf("rtsp://%s:%d/%s", host, port, path)
-}
-
-func (rs *rtspStream) logStderr(r io.Reader) {
-	scanner := newStderrScanner(r)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.Contains(line, "frame=") {
-			log.Printf("[ffmpeg rtsp] %s", line)
-		}
+	values, err := url.ParseQuery(rs.query)
+	if err != nil {
+		return SubtitleRequest{}, false
 	}
+	return SubtitlesFromQuery(values)
 }
 
-func (rs *rtspStream) seek(offset float64) error {
-	if offset < 0 {
-		offset = 0
-	}
-
-	rs.mu.Lock()
-	current := rs.startOffset
-	oldReady := rs.ready
-	oldCancel := rs.cancel
-	oldCleanup := rs.cleanup
-	if math.Abs(current-offset) < 0.5 && rs.stream != nil {
-		rs.mu.Unlock()
-		return nil
-	}
-	rs.startOffset = offset
-	rs.cancel = nil
-	rs.cmd = nil
-	rs.err = nil
-	rs.cleanup = nil
-	rs.running = false
-	rs.ready = make(chan struct{})
-	rs.publisher = nil
-	rs.mu.Unlock()
-
-	if oldCancel != nil {
-		oldCancel()
-	}
-	if oldCleanup != nil {
-		oldCleanup()
-	}
-	safeClose(oldReady)
-
-	rs.ensureStarted()
-
-	waitCtx, cancel := context.WithTimeout(context.Background(), rtspPublisherTimeout)
-	defer cancel()
-	_, err := rs.waitReady(waitCtx)
-	return err
+// publishURL is where the internal ffmpeg announces. The query is rebuilt so
+// the publisher id set here is the only one, whatever the reader sent.
+func (rs *rtspStream) publishURL() string {
+	values, _ := url.ParseQuery(rs.query)
+	values.Set(rtspPublisherParam, rs.id)
+	path := strings.TrimPrefix(rs.path, "/")
+	return fmt.Sprintf("rtsp://%s/%s?%s", rs.server.loopbackAddress(), path, values.Encode())
 }
 
 func (rs *rtspStream) setRunning(state bool) {
@@ -624,6 +813,15 @@ func (rs *rtspStream) setCleanup(fn func()) {
 	rs.mu.Unlock()
 }
 
+// setRelease stores the scheduler slot held by this stream and the client it
+// was reserved for; the slot is returned on shutdown.
+func (rs *rtspStream) setRelease(fn func(), client string) {
+	rs.mu.Lock()
+	rs.release = fn
+	rs.client = client
+	rs.mu.Unlock()
+}
+
 func (rs *rtspStream) takeCleanup() func() {
 	rs.mu.Lock()
 	fn := rs.cleanup
@@ -666,12 +864,6 @@ func (rs *rtspStream) waitReady(ctx context.Context) (*gortsplib.ServerStream, e
 	}
 }
 
-func (rs *rtspStream) currentStream() *gortsplib.ServerStream {
-	rs.mu.RLock()
-	defer rs.mu.RUnlock()
-	return rs.stream
-}
-
 func (rs *rtspStream) attachPublisher(session *gortsplib.ServerSession, desc *description.Session) error {
 	rs.mu.Lock()
 	if rs.publisher != nil && rs.publisher != session {
@@ -694,6 +886,10 @@ func (rs *rtspStream) attachPublisher(session *gortsplib.ServerSession, desc *de
 		stream.Desc = desc
 	}
 	rs.publisher = session
+	rs.readyAt = time.Now()
+	if len(rs.readers) == 0 {
+		rs.idleSince = rs.readyAt
+	}
 	ready := rs.ready
 	rs.mu.Unlock()
 
@@ -701,13 +897,25 @@ func (rs *rtspStream) attachPublisher(session *gortsplib.ServerSession, desc *de
 	return nil
 }
 
+// forwardPackets copies the publisher's packets to every reader on this
+// timeline. Readers may have joined from another timeline, so medias are
+// matched by position rather than identity.
 func (rs *rtspStream) forwardPackets(session *gortsplib.ServerSession) {
+	index := make(map[*description.Media]int)
+	if desc := session.AnnouncedDescription(); desc != nil {
+		for i, medi := range desc.Medias {
+			index[medi] = i
+		}
+	}
 	session.OnPacketRTPAny(func(medi *description.Media, _ format.Format, pkt *rtp.Packet) {
+		idx, ok := index[medi]
+		if !ok {
+			return
+		}
 		rs.mu.RLock()
-		stream := rs.stream
-		rs.mu.RUnlock()
-		if stream != nil {
-			if err := stream.WritePacketRTP(medi, pkt); err != nil {
+		defer rs.mu.RUnlock()
+		for reader := range rs.readers {
+			if err := reader.writePacket(idx, pkt); err != nil {
 				log.Printf("[rtsp] write packet: %v", err)
 			}
 		}
@@ -742,23 +950,25 @@ func (rs *rtspStream) fail(err error) {
 func (rs *rtspStream) shutdown() {
 	rs.mu.Lock()
 	cancel := rs.cancel
-	cmd := rs.cmd
+	proc := rs.proc
 	stream := rs.stream
 	cleanup := rs.cleanup
+	release := rs.release
 	ready := rs.ready
 	rs.cancel = nil
-	rs.cmd = nil
+	rs.proc = nil
 	rs.stream = nil
 	rs.publisher = nil
 	rs.cleanup = nil
+	rs.release = nil
 	rs.running = false
 	rs.mu.Unlock()
 
 	if cancel != nil {
 		cancel()
 	}
-	if cmd != nil {
-		_ = cmd.Process.Kill()
+	if proc != nil {
+		_ = proc.Kill()
 	}
 	if stream != nil {
 		stream.Close()
@@ -766,6 +976,9 @@ func (rs *rtspStream) shutdown() {
 	if cleanup != nil {
 		cleanup()
 	}
+	if release != nil {
+		release()
+	}
 	safeClose(ready)
 }
 
@@ -812,6 +1025,24 @@ func canonicalQuery(query string) string {
 	return canonical.Encode()
 }
 
+// withoutReaderParams drops what only concerns one reader from its query:
+// the start offset picks the timeline rather than the stream, and the access
+// token differs per URL. A publisher id is the server's to set.
+func withoutReaderParams(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	values.Del("start")
+	values.Del("t")
+	values.Del(rtspTokenParam)
+	values.Del(rtspPublisherParam)
+	return values.Encode()
+}
+
 func canonicalKey(path, query string) string {
 	base := canonicalPath(path)
 	if query == "" {
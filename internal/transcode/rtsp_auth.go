@@ -0,0 +1,164 @@
+package transcode
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+)
+
+const (
+	defaultRTSPSAddress = ":8322"
+	defaultRTSPTokenTTL = 6 * time.Hour
+	// rtspTokenParam carries the signed "<expiry>.<signature>" access token.
+	rtspTokenParam = "token"
+)
+
+// rtspAuth guards reader sessions. Either form of access is enough: a valid
+// token in the URL, or basic/digest credentials. Internal publishers are
+// checked by address instead.
+type rtspAuth struct {
+	user   string
+	pass   string
+	secret []byte
+	ttl    time.Duration
+}
+
+func (a rtspAuth) required() bool {
+	return a.user != "" || len(a.secret) > 0
+}
+
+// sign returns the token for path, valid until now+ttl.
+func (a rtspAuth) sign(path string, now time.Time) string {
+	expires := strconv.FormatInt(now.Add(a.ttl).Unix(), 10)
+	return expires + "." + a.signature(path, expires)
+}
+
+func (a rtspAuth) signature(path, expires string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(strings.Trim(path, "/") + "|" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// validToken checks the token in query against path. Tokens cover the
+// profile and video, not the start offset, so seeking keeps working.
+func (a rtspAuth) validToken(path, query string, now time.Time) bool {
+	if len(a.secret) == 0 {
+		return false
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return false
+	}
+	expires, sig, ok := strings.Cut(values.Get(rtspTokenParam), ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(a.signature(path, expires)))
+}
+
+// WithRTSPAuth requires RTSP readers to log in with basic or digest
+// credentials unless their URL carries a valid signed token.
+func (s *Service) WithRTSPAuth(user, pass string) *Service {
+	s.rtspAuth.user = user
+	s.rtspAuth.pass = pass
+	return s
+}
+
+// WithRTSPURLSigning makes RTSPURL embed a token signed with secret that
+// expires after ttl (0 = 6h); readers need it (or credentials) to connect.
+func (s *Service) WithRTSPURLSigning(secret []byte, ttl time.Duration) *Service {
+	if ttl <= 0 {
+		ttl = defaultRTSPTokenTTL
+	}
+	s.rtspAuth.secret = secret
+	s.rtspAuth.ttl = ttl
+	return s
+}
+
+// EnableRTSPS adds a TLS listener next to the plain RTSP one. It serves
+// readers only, with SRTP interleaved over the TLS connection; the internal
+// publishers keep using the plain listener on loopback. EnableRTSP must be
+// called first, and restarting it drops the RTSPS listener.
+func (s *Service) EnableRTSPS(addr, certFile, keyFile string) error {
+	if s.rtsp == nil {
+		return errors.New("rtsps: RTSP server not enabled")
+	}
+	if addr == "" {
+		addr = defaultRTSPSAddress
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("rtsps: %w", err)
+	}
+	return s.rtsp.startTLS(addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+}
+
+// RTSPSURL returns the public RTSPS URL, or "" when RTSPS is not enabled.
+func (s *Service) RTSPSURL(host string, profile Profile, videoID string) string {
+	if s.rtsp == nil || s.rtsp.tlsServer == nil {
+		return ""
+	}
+	return s.rtsp.publicURLFor("rtsps", s.rtsp.tlsPort, host, profile, videoID)
+}
+
+func (r *rtspServer) startTLS(addr string, cfg *tls.Config) error {
+	listener := &rtspListener{rtspServer: r}
+	srv := &gortsplib.Server{
+		Handler:      listener,
+		RTSPAddress:  addr,
+		TLSConfig:    cfg,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	listener.srv = srv
+	if err := srv.Start(); err != nil {
+		return fmt.Errorf("rtsps: start: %w", err)
+	}
+	r.mu.Lock()
+	previous := r.tlsServer
+	r.tlsServer = srv
+	r.tlsPort = extractPort(addr)
+	r.mu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	log.Printf("[rtsps] listening on %s", addr)
+	return nil
+}
+
+// authorize admits a reader request. A nil response means access granted;
+// otherwise the response (and error, for credential challenges) is returned
+// as is.
+func (r *rtspServer) authorize(conn *gortsplib.ServerConn, req *base.Request, path, query string) (*base.Response, error) {
+	auth := r.svc.rtspAuth
+	if !auth.required() {
+		return nil, nil
+	}
+	if auth.validToken(readerPath(path), query, time.Now()) {
+		return nil, nil
+	}
+	if auth.user != "" {
+		if conn.VerifyCredentials(req, auth.user, auth.pass) {
+			return nil, nil
+		}
+		// gortsplib answers with a WWW-Authenticate challenge.
+		return &base.Response{StatusCode: base.StatusUnauthorized}, liberrors.ErrServerAuth{}
+	}
+	return &base.Response{StatusCode: base.StatusForbidden}, nil
+}
@@ -0,0 +1,261 @@
+package transcode
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/pion/rtp"
+)
+
+const (
+	// rtspPublisherParam tags the internal publish URL with its timeline.
+	rtspPublisherParam = "publisher"
+	// rtspShareWindow is how far (seconds) a running timeline may be from a
+	// reader's requested offset and still be shared.
+	rtspShareWindow = 3.0
+	// rtspSeekTolerance ignores PLAY ranges this close to the current position.
+	rtspSeekTolerance = 0.5
+)
+
+// rtspReader is one playing client session. It owns the ServerStream it was
+// set up with; the timeline it is attached to writes into that stream.
+type rtspReader struct {
+	session *gortsplib.ServerSession
+	stream  *gortsplib.ServerStream
+	// timeline, offset and paused are guarded by rtspServer.mu. A paused
+	// reader keeps its last timeline for the stream settings but is not in
+	// its reader set; offset then holds the position to resume from.
+	timeline *rtspStream
+	offset   float64
+	paused   bool
+}
+
+func newRTSPReader(server *gortsplib.Server, session *gortsplib.ServerSession, desc *description.Session, offset float64) (*rtspReader, error) {
+	stream := &gortsplib.ServerStream{Server: server, Desc: desc}
+	if err := stream.Initialize(); err != nil {
+		return nil, err
+	}
+	return &rtspReader{session: session, stream: stream, offset: offset}, nil
+}
+
+// writePacket sends pkt on the reader's media at idx.
+func (rd *rtspReader) writePacket(idx int, pkt *rtp.Packet) error {
+	medias := rd.stream.Desc.Medias
+	if idx >= len(medias) {
+		return nil
+	}
+	return rd.stream.WritePacketRTP(medias[idx], pkt)
+}
+
+func (rd *rtspReader) close() {
+	rd.stream.Close()
+}
+
+func (r *rtspServer) readerFor(session *gortsplib.ServerSession) *rtspReader {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readers[session]
+}
+
+func (r *rtspServer) addReader(reader *rtspReader, stream *rtspStream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readers[reader.session] = reader
+	reader.timeline = stream
+	stream.addReader(reader)
+}
+
+func (r *rtspServer) removeReader(session *gortsplib.ServerSession) {
+	r.mu.Lock()
+	reader, ok := r.readers[session]
+	if ok {
+		delete(r.readers, session)
+		reader.timeline.removeReader(reader)
+	}
+	r.mu.Unlock()
+	if ok {
+		reader.close()
+	}
+}
+
+// pauseReader detaches reader from its timeline, remembering where it was.
+func (r *rtspServer) pauseReader(reader *rtspReader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reader.paused || r.readers[reader.session] != reader {
+		return
+	}
+	reader.offset = reader.timeline.position()
+	reader.paused = true
+	reader.timeline.removeReader(reader)
+}
+
+// pausedAt returns the position a paused reader resumes from.
+func (r *rtspServer) pausedAt(reader *rtspReader) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return reader.offset, reader.paused
+}
+
+// seekReader moves reader to a timeline at offset, sharing one that is
+// already there or starting a new publisher. The first PLAY of a session
+// only seeks when it asks for something other than the offset it was set up
+// with; npt=0-, which players send to mean "from the start", keeps a ?start=
+// offset. A paused reader always rejoins. A timeline left without readers is
+// stopped. A non-nil response rejects the PLAY.
+func (r *rtspServer) seekReader(reader *rtspReader, offset float64, first bool, client string) (*base.Response, error) {
+	offset = max(offset, 0)
+	r.mu.Lock()
+	current, requested, paused := reader.timeline, reader.offset, reader.paused
+	r.mu.Unlock()
+	if current == nil {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil
+	}
+	if !paused {
+		if first && (offset == 0 || math.Abs(offset-requested) < rtspSeekTolerance) {
+			return nil, nil
+		}
+		if !first && math.Abs(offset-current.position()) < rtspSeekTolerance {
+			return nil, nil
+		}
+	}
+
+	target, created := r.timelineFor(current.key, current.path, current.query, current.profile, current.videoID, offset, current.transport)
+	if target == current && !paused {
+		return nil, nil
+	}
+	if created {
+		if res := r.reserve(target, client); res != nil {
+			return res, nil
+		}
+	}
+	target.ensureStarted()
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), rtspPublisherTimeout)
+	defer cancel()
+	if _, err := target.waitReady(waitCtx); err != nil {
+		return &base.Response{StatusCode: base.StatusInvalidRange}, err
+	}
+
+	r.mu.Lock()
+	if r.readers[reader.session] != reader {
+		// The session closed while the new timeline was starting.
+		r.mu.Unlock()
+		return nil, nil
+	}
+	previous := reader.timeline
+	reader.timeline = target
+	reader.offset = offset
+	reader.paused = false
+	previous.removeReader(reader)
+	target.addReader(reader)
+	r.mu.Unlock()
+
+	if previous != target && previous.readerCount() == 0 {
+		r.removeStream(previous)
+	}
+	return nil, nil
+}
+
+// reap stops timelines that have had no playing reader for idle, so ffmpeg
+// does not keep transcoding after every client paused or went away.
+func (r *rtspServer) reap(idle time.Duration) {
+	ticker := time.NewTicker(max(idle/3, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		var stale []*rtspStream
+		for _, stream := range r.timelines {
+			if stream.idleFor() > idle {
+				stale = append(stale, stream)
+			}
+		}
+		r.mu.Unlock()
+		for _, stream := range stale {
+			log.Printf("[rtsp] reaping idle publisher id=%s profile=%s at %.0fs", stream.videoID, stream.profile, stream.position())
+			r.removeStream(stream)
+		}
+	}
+}
+
+// connClient identifies the client behind conn for the scheduler; tunnelled
+// sessions count as the HTTP client that opened the tunnel.
+func (r *rtspServer) connClient(conn *gortsplib.ServerConn) string {
+	if conn == nil {
+		return ""
+	}
+	addr := conn.NetConn().RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	if client := r.tunnelledClient(addr); client != "" {
+		return client
+	}
+	return clientHost(addr.String())
+}
+
+func (rs *rtspStream) addReader(reader *rtspReader) {
+	rs.mu.Lock()
+	rs.readers[reader] = struct{}{}
+	rs.idleSince = time.Time{}
+	rs.mu.Unlock()
+}
+
+func (rs *rtspStream) removeReader(reader *rtspReader) {
+	rs.mu.Lock()
+	if _, ok := rs.readers[reader]; ok {
+		delete(rs.readers, reader)
+		if len(rs.readers) == 0 {
+			rs.idleSince = time.Now()
+		}
+	}
+	rs.mu.Unlock()
+}
+
+// idleFor is how long the timeline has been running without readers.
+func (rs *rtspStream) idleFor() time.Duration {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	if len(rs.readers) > 0 || rs.idleSince.IsZero() {
+		return 0
+	}
+	return time.Since(rs.idleSince)
+}
+
+func (rs *rtspStream) readerCount() int {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return len(rs.readers)
+}
+
+// position is where the timeline is now, in seconds into the video.
+func (rs *rtspStream) position() float64 {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.positionLocked()
+}
+
+func (rs *rtspStream) positionLocked() float64 {
+	if rs.readyAt.IsZero() {
+		return rs.startOffset
+	}
+	return rs.startOffset + time.Since(rs.readyAt).Seconds()
+}
+
+// shareable reports whether a reader asking for offset can join this
+// timeline.
+func (rs *rtspStream) shareable(offset float64) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.err == nil && math.Abs(rs.positionLocked()-offset) <= rtspShareWindow
+}
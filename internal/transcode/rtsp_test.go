@@ -0,0 +1,36 @@
+package transcode
+
+import (
+	"net/url"
+	"testing"
+)
+
+// TestPublishURLOwnsPublisherID checks that a publisher id smuggled into a
+// reader URL cannot steer the internal ffmpeg to another timeline.
+func TestPublishURLOwnsPublisherID(t *testing.T) {
+	server := &rtspServer{port: 8554, timelines: make(map[string]*rtspStream)}
+	other := &rtspStream{id: "1"}
+	server.timelines[other.id] = other
+
+	query := canonicalQuery(withoutReaderParams("publisher=1&subs=en&start=30&token=abc"))
+	if query != "subs=en" {
+		t.Fatalf("timeline query = %q, want subs=en", query)
+	}
+	// Even a query that kept the reader's id must not win.
+	stream := newRTSPStream(server, "2", "retro/x", "retro/x.3gp", "publisher=1&subs=en", ProfileRetro, "x", 0, "tcp")
+	server.timelines[stream.id] = stream
+
+	u, err := url.Parse(stream.publishURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query()[rtspPublisherParam]; len(got) != 1 || got[0] != "2" {
+		t.Errorf("publisher = %q, want only 2", got)
+	}
+	if got := u.Query().Get("subs"); got != "en" {
+		t.Errorf("subs = %q, want en", got)
+	}
+	if got := server.publisherTimeline(u.RawQuery); got != stream {
+		t.Errorf("ANNOUNCE resolves to timeline %v, want %s", got, stream.id)
+	}
+}
@@ -0,0 +1,300 @@
+package transcode
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rtspTunnelPrefix is where the HTTP tunnel is mounted. Tunnelled clients
+	// keep it in the RTSP URLs they send, so reader paths may carry it too.
+	rtspTunnelPrefix = "rtsp/"
+	// rtspTunnelContentType marks both halves of a tunnel.
+	rtspTunnelContentType = "application/x-rtsp-tunnelled"
+	// maxRTSPTunnels and maxRTSPTunnelsPerClient bound the open tunnels;
+	// each holds a loopback connection to the RTSP server.
+	maxRTSPTunnels          = 64
+	maxRTSPTunnelsPerClient = 4
+)
+
+var (
+	errTunnelInUse    = errors.New("session cookie in use")
+	errTooManyTunnels = errors.New("too many rtsp tunnels")
+)
+
+// rtspTunnel is one RTSP-over-HTTP session: the GET request receives what
+// the RTSP server writes to local, POST requests carry the client's base64
+// encoded RTSP requests into it. Only the client that opened the GET may
+// POST into it.
+type rtspTunnel struct {
+	local  net.Conn
+	client string
+	// mu serializes POST bodies; some clients send one POST per request.
+	mu sync.Mutex
+}
+
+// EnableRTSPTunnel accepts RTSP over HTTP (the QuickTime tunnelling mode)
+// below /rtsp/ on the main HTTP server and makes RTSPURL hand out http://
+// tunnel URLs, for networks that block the RTSP port and UDP. EnableRTSP
+// must be called first.
+func (s *Service) EnableRTSPTunnel() error {
+	if s.rtsp == nil {
+		return errors.New("rtsp tunnel: RTSP server not enabled")
+	}
+	s.rtsp.mu.Lock()
+	if s.rtsp.tunnels == nil {
+		s.rtsp.tunnels = make(map[string]*rtspTunnel)
+		s.rtsp.tunnelClients = make(map[string]string)
+	}
+	s.rtsp.mu.Unlock()
+	return nil
+}
+
+// RTSPTunnelEnabled reports whether RTSP URLs point at the HTTP tunnel.
+func (s *Service) RTSPTunnelEnabled() bool {
+	if s.rtsp == nil {
+		return false
+	}
+	s.rtsp.mu.Lock()
+	defer s.rtsp.mu.Unlock()
+	return s.rtsp.tunnels != nil
+}
+
+// ServeRTSPTunnel answers both halves of an RTSP-over-HTTP tunnel. The GET
+// stays open and streams the RTSP responses and interleaved RTP; POSTs with
+// the same x-sessioncookie from the same address carry the requests. Open
+// tunnels are capped overall and per client. The RTSP session itself runs
+// on the regular server through a loopback connection, so authentication,
+// seeking and pausing work as they do for direct clients (over TCP only).
+func (s *Service) ServeRTSPTunnel(w http.ResponseWriter, r *http.Request) {
+	if !s.RTSPTunnelEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	cookie := strings.TrimSpace(r.Header.Get("x-sessioncookie"))
+	if cookie == "" {
+		http.Error(w, "missing x-sessioncookie", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.rtsp.serveTunnelGet(w, r, cookie)
+	case http.MethodPost:
+		s.rtsp.serveTunnelPost(w, r, cookie)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *rtspServer) serveTunnelGet(w http.ResponseWriter, req *http.Request, cookie string) {
+	tunnel := &rtspTunnel{client: clientHost(req.RemoteAddr)}
+	switch err := r.openTunnel(cookie, tunnel); {
+	case errors.Is(err, errTunnelInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer r.closeTunnel(cookie, tunnel)
+	local, err := net.DialTimeout("tcp", r.loopbackAddress(), 5*time.Second)
+	if err != nil {
+		http.Error(w, "rtsp server unavailable", http.StatusBadGateway)
+		return
+	}
+	defer local.Close()
+	r.connectTunnel(tunnel, local)
+	stop := context.AfterFunc(req.Context(), func() { local.Close() })
+	defer stop()
+
+	h := w.Header()
+	h.Set("Content-Type", rtspTunnelContentType)
+	h.Set("Cache-Control", "no-store")
+	h.Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	_ = rc.Flush()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := local.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if rc.Flush() != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *rtspServer) serveTunnelPost(w http.ResponseWriter, req *http.Request, cookie string) {
+	tunnel := r.tunnel(cookie)
+	if tunnel == nil || tunnel.client != clientHost(req.RemoteAddr) {
+		http.Error(w, "unknown session cookie", http.StatusNotFound)
+		return
+	}
+	// The body lasts as long as the client keeps the session open.
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+	tunnel.mu.Lock()
+	_, err := io.Copy(tunnel.local, &tunnelDecoder{src: req.Body})
+	tunnel.mu.Unlock()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("[rtsp] tunnel %s: %v", tunnel.client, err)
+	}
+}
+
+// openTunnel registers tunnel under cookie, within the tunnel limits.
+func (r *rtspServer) openTunnel(cookie string, tunnel *rtspTunnel) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tunnels == nil || r.tunnels[cookie] != nil {
+		return errTunnelInUse
+	}
+	if len(r.tunnels) >= maxRTSPTunnels {
+		return errTooManyTunnels
+	}
+	perClient := 0
+	for _, t := range r.tunnels {
+		if t.client == tunnel.client {
+			perClient++
+		}
+	}
+	if perClient >= maxRTSPTunnelsPerClient {
+		return errTooManyTunnels
+	}
+	r.tunnels[cookie] = tunnel
+	return nil
+}
+
+// connectTunnel attaches the loopback connection to an open tunnel.
+func (r *rtspServer) connectTunnel(tunnel *rtspTunnel, local net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tunnel.local = local
+	r.tunnelClients[local.LocalAddr().String()] = tunnel.client
+}
+
+func (r *rtspServer) closeTunnel(cookie string, tunnel *rtspTunnel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tunnels[cookie] == tunnel {
+		delete(r.tunnels, cookie)
+	}
+	if tunnel.local != nil {
+		delete(r.tunnelClients, tunnel.local.LocalAddr().String())
+	}
+}
+
+// tunnel returns the connected tunnel for cookie, or nil.
+func (r *rtspServer) tunnel(cookie string) *rtspTunnel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t := r.tunnels[cookie]; t != nil && t.local != nil {
+		return t
+	}
+	return nil
+}
+
+// tunnelledClient returns the HTTP client behind a loopback RTSP connection
+// opened by the tunnel, or "" when addr is not one.
+func (r *rtspServer) tunnelledClient(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tunnelClients[addr.String()]
+}
+
+// loopbackAddress is where in-process clients (publishers, tunnels) reach
+// the plain RTSP listener.
+func (r *rtspServer) loopbackAddress() string {
+	port := r.port
+	if port == 0 {
+		port = 8554
+	}
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+func (r *rtspServer) tunnelURL(host string, profile Profile, videoID string) string {
+	if host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s/%s%s", host, rtspTunnelPrefix, r.readerURLPath(profile, videoID))
+}
+
+// tunnelDecoder decodes a POST body of base64 chunks. Clients encode each
+// request on its own, so padding can appear mid-stream and line breaks may
+// separate the chunks; every complete 4-byte group is decoded as it arrives.
+type tunnelDecoder struct {
+	src     io.Reader
+	pending []byte
+	out     []byte
+	err     error
+}
+
+func (d *tunnelDecoder) Read(p []byte) (int, error) {
+	buf := make([]byte, 4096)
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, err := d.src.Read(buf)
+		for _, c := range buf[:n] {
+			if c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+				d.pending = append(d.pending, c)
+			}
+		}
+		whole := len(d.pending) / 4 * 4
+		if whole > 0 {
+			decoded := make([]byte, base64.StdEncoding.DecodedLen(whole))
+			m, derr := decodeGroups(decoded, d.pending[:whole])
+			if derr != nil {
+				return 0, derr
+			}
+			d.out = decoded[:m]
+			d.pending = append(d.pending[:0], d.pending[whole:]...)
+		}
+		if err != nil {
+			d.err = err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// decodeGroups decodes src group by group, as a padded group is only valid
+// at the end of a base64 string.
+func decodeGroups(dst, src []byte) (int, error) {
+	total := 0
+	for len(src) > 0 {
+		end := len(src)
+		if i := strings.IndexByte(string(src), '='); i >= 0 {
+			end = min((i/4+1)*4, len(src))
+		}
+		n, err := base64.StdEncoding.Decode(dst[total:], src[:end])
+		if err != nil {
+			return total, fmt.Errorf("tunnel: %w", err)
+		}
+		total += n
+		src = src[end:]
+	}
+	return total, nil
+}
@@ -0,0 +1,306 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQueueSize    = 16
+	defaultQueueTimeout = 30 * time.Second
+	defaultRetryAfter   = 15 * time.Second
+	defaultClientSlots  = 2
+)
+
+// ErrQueueFull is returned when no transcode slot is free and the wait queue is saturated.
+var ErrQueueFull = errors.New("transcode: queue full")
+
+// ErrQueueTimeout is returned when a queued job did not get a slot in time.
+var ErrQueueTimeout = errors.New("transcode: queue timeout")
+
+// BusyError reports that the scheduler refused a job, with a hint for clients.
+type BusyError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%v (retry after %s)", e.Err, e.RetryAfter)
+}
+
+func (e *BusyError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfterSeconds formats the retry hint for a Retry-After header.
+func (e *BusyError) RetryAfterSeconds() string {
+	secs := int(e.RetryAfter.Round(time.Second) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return fmt.Sprint(secs)
+}
+
+// SchedulerConfig bounds how many ffmpeg processes may run at once.
+type SchedulerConfig struct {
+	// MaxConcurrent caps running jobs across all profiles (0 = number of CPUs).
+	MaxConcurrent int
+	// MaxPerProfile caps running jobs for a single profile (0 = no extra cap).
+	MaxPerProfile int
+	// ProfileLimits overrides MaxPerProfile for specific profiles.
+	ProfileLimits map[Profile]int
+	// MaxPerClient caps running plus queued jobs for one client address (0 = default).
+	MaxPerClient int
+	// QueueSize bounds how many jobs may wait for a slot (0 = default).
+	QueueSize int
+	// QueueTimeout bounds how long a job waits before giving up (0 = default).
+	QueueTimeout time.Duration
+	// RetryAfter is the hint sent to refused clients (0 = default).
+	RetryAfter time.Duration
+}
+
+// Scheduler hands out transcode slots with global, per-profile and per-client limits.
+type Scheduler struct {
+	cfg SchedulerConfig
+
+	mu       sync.Mutex
+	running  int
+	profiles map[Profile]int
+	clients  map[string]int
+	queue    []*slotWaiter
+}
+
+type slotWaiter struct {
+	profile Profile
+	client  string
+	ready   chan struct{}
+	granted bool
+}
+
+// NewScheduler returns a scheduler with defaults filled in.
+func NewScheduler(cfg SchedulerConfig) *Scheduler {
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = runtime.NumCPU()
+	}
+	if cfg.MaxPerClient <= 0 {
+		cfg.MaxPerClient = defaultClientSlots
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = defaultQueueTimeout
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = defaultRetryAfter
+	}
+	return &Scheduler{
+		cfg:      cfg,
+		profiles: make(map[Profile]int),
+		clients:  make(map[string]int),
+	}
+}
+
+// Acquire blocks until a slot for the profile is free, the queue timeout passes or ctx ends.
+// The returned release func must be called exactly once when the job finishes.
+func (s *Scheduler) Acquire(ctx context.Context, profile Profile, client string) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	if s.clients[client] >= s.cfg.MaxPerClient {
+		s.mu.Unlock()
+		return nil, s.busy(ErrQueueFull)
+	}
+	if len(s.queue) == 0 && s.canRunLocked(profile) {
+		s.startLocked(profile, client)
+		s.mu.Unlock()
+		return s.releaser(profile, client), nil
+	}
+	if len(s.queue) >= s.cfg.QueueSize {
+		s.mu.Unlock()
+		return nil, s.busy(ErrQueueFull)
+	}
+	waiter := &slotWaiter{profile: profile, client: client, ready: make(chan struct{})}
+	s.queue = append(s.queue, waiter)
+	s.clients[client]++
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	var cause error
+	select {
+	case <-waiter.ready:
+		return s.releaser(profile, client), nil
+	case <-timer.C:
+		cause = s.busy(ErrQueueTimeout)
+	case <-ctx.Done():
+		cause = ctx.Err()
+	}
+
+	s.mu.Lock()
+	if waiter.granted {
+		// Lost the race against dispatch; hand the slot back.
+		s.mu.Unlock()
+		s.releaser(profile, client)()
+		return nil, cause
+	}
+	s.removeWaiterLocked(waiter)
+	s.clients[client]--
+	if s.clients[client] <= 0 {
+		delete(s.clients, client)
+	}
+	s.dispatchLocked()
+	s.mu.Unlock()
+	return nil, cause
+}
+
+// TryAcquire takes a slot for the profile only if one is free right now and
+// nobody is queued ahead; otherwise it fails with ErrQueueFull at once.
+func (s *Scheduler) TryAcquire(profile Profile, client string) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[client] >= s.cfg.MaxPerClient || len(s.queue) > 0 || !s.canRunLocked(profile) {
+		return nil, s.busy(ErrQueueFull)
+	}
+	s.startLocked(profile, client)
+	return s.releaser(profile, client), nil
+}
+
+// Stats returns the running and queued job counts.
+func (s *Scheduler) Stats() (running, queued int) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running, len(s.queue)
+}
+
+// Capacity returns the concurrent job limit (0 when unlimited).
+func (s *Scheduler) Capacity() int {
+	if s == nil {
+		return 0
+	}
+	return s.cfg.MaxConcurrent
+}
+
+func (s *Scheduler) busy(err error) error {
+	return &BusyError{Err: err, RetryAfter: s.cfg.RetryAfter}
+}
+
+func (s *Scheduler) profileLimit(profile Profile) int {
+	if limit, ok := s.cfg.ProfileLimits[profile]; ok && limit > 0 {
+		return limit
+	}
+	return s.cfg.MaxPerProfile
+}
+
+func (s *Scheduler) canRunLocked(profile Profile) bool {
+	if s.running >= s.cfg.MaxConcurrent {
+		return false
+	}
+	if limit := s.profileLimit(profile); limit > 0 && s.profiles[profile] >= limit {
+		return false
+	}
+	return true
+}
+
+func (s *Scheduler) startLocked(profile Profile, client string) {
+	s.running++
+	s.profiles[profile]++
+	s.clients[client]++
+}
+
+func (s *Scheduler) releaser(profile Profile, client string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.running--
+			s.profiles[profile]--
+			if s.profiles[profile] <= 0 {
+				delete(s.profiles, profile)
+			}
+			s.clients[client]--
+			if s.clients[client] <= 0 {
+				delete(s.clients, client)
+			}
+			s.dispatchLocked()
+			s.mu.Unlock()
+		})
+	}
+}
+
+// dispatchLocked grants free slots to queued jobs. Among runnable waiters the one
+// whose client currently holds the fewest slots wins, so a single address cannot
+// monopolise the box by queueing early.
+func (s *Scheduler) dispatchLocked() {
+	for s.running < s.cfg.MaxConcurrent {
+		best := -1
+		bestLoad := 0
+		for i, w := range s.queue {
+			if !s.canRunLocked(w.profile) {
+				continue
+			}
+			load := s.clients[w.client]
+			if best < 0 || load < bestLoad {
+				best = i
+				bestLoad = load
+			}
+		}
+		if best < 0 {
+			return
+		}
+		w := s.queue[best]
+		s.queue = append(s.queue[:best], s.queue[best+1:]...)
+		// The waiter already counts against its client while queued.
+		s.running++
+		s.profiles[w.profile]++
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+func (s *Scheduler) removeWaiterLocked(target *slotWaiter) {
+	for i, w := range s.queue {
+		if w == target {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+type clientKey struct{}
+
+// WithClient tags ctx with the requesting client's address for per-client fairness.
+func WithClient(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, clientKey{}, clientHost(addr))
+}
+
+func clientFromContext(ctx context.Context) string {
+	if v, ok := ctx.Value(clientKey{}).(string); ok {
+		return v
+	}
+	return ""
+}
+
+func clientHost(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,308 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type slot struct {
+	profile Profile
+	client  string
+}
+
+func TestSchedulerAcquire(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  SchedulerConfig
+		// held run before the request; queued wait behind them.
+		held   []slot
+		queued []slot
+		req    slot
+		// wantErr is nil when req should get a slot straight away. Requests
+		// behind queued jobs must be refused at once.
+		wantErr        error
+		wantRetryAfter string
+	}{
+		{
+			name: "free slot",
+			cfg:  SchedulerConfig{MaxConcurrent: 2},
+			held: []slot{{ProfileRetro, "a"}},
+			req:  slot{ProfileRetro, "b"},
+		},
+		{
+			name:    "global limit waits out the queue timeout",
+			cfg:     SchedulerConfig{MaxConcurrent: 1},
+			held:    []slot{{ProfileRetro, "a"}},
+			req:     slot{ProfileEdge, "b"},
+			wantErr: ErrQueueTimeout,
+		},
+		{
+			name:    "per-profile limit",
+			cfg:     SchedulerConfig{MaxConcurrent: 4, MaxPerProfile: 1},
+			held:    []slot{{ProfileRetro, "a"}},
+			req:     slot{ProfileRetro, "b"},
+			wantErr: ErrQueueTimeout,
+		},
+		{
+			name: "per-profile limit leaves other profiles alone",
+			cfg:  SchedulerConfig{MaxConcurrent: 4, MaxPerProfile: 1},
+			held: []slot{{ProfileRetro, "a"}},
+			req:  slot{ProfileEdge, "b"},
+		},
+		{
+			name: "profile override raises the limit",
+			cfg:  SchedulerConfig{MaxConcurrent: 4, MaxPerProfile: 1, ProfileLimits: map[Profile]int{ProfileRetro: 2}},
+			held: []slot{{ProfileRetro, "a"}},
+			req:  slot{ProfileRetro, "b"},
+		},
+		{
+			name:    "profile override lowers the limit",
+			cfg:     SchedulerConfig{MaxConcurrent: 4, ProfileLimits: map[Profile]int{ProfileEdge: 1}},
+			held:    []slot{{ProfileEdge, "a"}},
+			req:     slot{ProfileEdge, "b"},
+			wantErr: ErrQueueTimeout,
+		},
+		{
+			name:           "per-client limit refuses at once",
+			cfg:            SchedulerConfig{MaxConcurrent: 4, MaxPerClient: 1, RetryAfter: 3 * time.Second},
+			held:           []slot{{ProfileRetro, "a"}},
+			req:            slot{ProfileEdge, "a"},
+			wantErr:        ErrQueueFull,
+			wantRetryAfter: "3",
+		},
+		{
+			name:           "queued jobs count against their client",
+			cfg:            SchedulerConfig{MaxConcurrent: 1, MaxPerClient: 2, RetryAfter: 3 * time.Second},
+			held:           []slot{{ProfileRetro, "a"}},
+			queued:         []slot{{ProfileRetro, "b"}, {ProfileRetro, "b"}},
+			req:            slot{ProfileRetro, "b"},
+			wantErr:        ErrQueueFull,
+			wantRetryAfter: "3",
+		},
+		{
+			name:           "queue overflow",
+			cfg:            SchedulerConfig{MaxConcurrent: 1, QueueSize: 1, RetryAfter: 7 * time.Second},
+			held:           []slot{{ProfileRetro, "a"}},
+			queued:         []slot{{ProfileRetro, "b"}},
+			req:            slot{ProfileRetro, "c"},
+			wantErr:        ErrQueueFull,
+			wantRetryAfter: "7",
+		},
+		{
+			name:           "retry hint rounds up to a second",
+			cfg:            SchedulerConfig{MaxConcurrent: 1, QueueSize: 1, RetryAfter: 200 * time.Millisecond},
+			held:           []slot{{ProfileRetro, "a"}},
+			queued:         []slot{{ProfileRetro, "b"}},
+			req:            slot{ProfileRetro, "c"},
+			wantErr:        ErrQueueFull,
+			wantRetryAfter: "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.QueueTimeout = 50 * time.Millisecond
+			if len(tt.queued) > 0 {
+				// Keep the waiters queued for the whole request.
+				cfg.QueueTimeout = time.Minute
+			}
+			s := NewScheduler(cfg)
+			for _, h := range tt.held {
+				release, err := s.Acquire(context.Background(), h.profile, h.client)
+				if err != nil {
+					t.Fatalf("hold %v: %v", h, err)
+				}
+				t.Cleanup(release)
+			}
+			if len(tt.queued) > 0 {
+				ctx, cancel := context.WithCancel(context.Background())
+				t.Cleanup(cancel)
+				for _, q := range tt.queued {
+					go func() {
+						if release, err := s.Acquire(ctx, q.profile, q.client); err == nil {
+							release()
+						}
+					}()
+				}
+				waitQueued(t, s, len(tt.queued))
+			}
+
+			release, err := s.Acquire(context.Background(), tt.req.profile, tt.req.client)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Acquire: %v", err)
+				}
+				release()
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Acquire error = %v, want %v", err, tt.wantErr)
+			}
+			var busy *BusyError
+			if !errors.As(err, &busy) {
+				t.Fatalf("Acquire error %T is not a *BusyError", err)
+			}
+			if tt.wantRetryAfter != "" && busy.RetryAfterSeconds() != tt.wantRetryAfter {
+				t.Errorf("RetryAfterSeconds = %s, want %s", busy.RetryAfterSeconds(), tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestSchedulerCancelWhileQueued(t *testing.T) {
+	s := NewScheduler(SchedulerConfig{MaxConcurrent: 1, QueueTimeout: time.Minute})
+	release, err := s.Acquire(context.Background(), ProfileRetro, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Acquire(ctx, ProfileRetro, "b")
+		done <- err
+	}()
+	waitQueued(t, s, 1)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Acquire error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acquire did not return after cancel")
+	}
+	if running, queued := s.Stats(); running != 1 || queued != 0 {
+		t.Errorf("Stats = %d running, %d queued; want 1, 0", running, queued)
+	}
+	s.mu.Lock()
+	held := s.clients["b"]
+	s.mu.Unlock()
+	if held != 0 {
+		t.Errorf("cancelled client still holds %d slots", held)
+	}
+}
+
+// TestSchedulerTryAcquire checks that a full scheduler refuses at once
+// rather than waiting out the queue timeout.
+func TestSchedulerTryAcquire(t *testing.T) {
+	s := NewScheduler(SchedulerConfig{MaxConcurrent: 1, QueueTimeout: time.Minute})
+	release, err := s.TryAcquire(ProfileRetro, "a")
+	if err != nil {
+		t.Fatalf("TryAcquire on a free scheduler: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := s.TryAcquire(ProfileEdge, "b"); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("TryAcquire error = %v, want ErrQueueFull", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("TryAcquire waited %v", waited)
+	}
+	if running, queued := s.Stats(); running != 1 || queued != 0 {
+		t.Errorf("Stats = %d running, %d queued; want 1, 0", running, queued)
+	}
+
+	release()
+	release, err = s.TryAcquire(ProfileEdge, "b")
+	if err != nil {
+		t.Fatalf("TryAcquire after release: %v", err)
+	}
+	release()
+}
+
+// TestSchedulerDispatch checks which waiter dispatchLocked picks when a
+// slot frees up.
+func TestSchedulerDispatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    SchedulerConfig
+		held   []slot
+		queued []slot
+		// release is the index into held freed to trigger dispatch.
+		release int
+		want    slot
+	}{
+		{
+			name:   "fewest slots wins over queue order",
+			cfg:    SchedulerConfig{MaxConcurrent: 2, MaxPerClient: 4},
+			held:   []slot{{ProfileRetro, "a"}, {ProfileRetro, "a"}},
+			queued: []slot{{ProfileRetro, "a"}, {ProfileRetro, "b"}},
+			want:   slot{ProfileRetro, "b"},
+		},
+		{
+			name:   "queue order breaks ties",
+			cfg:    SchedulerConfig{MaxConcurrent: 1, MaxPerClient: 4},
+			held:   []slot{{ProfileRetro, "a"}},
+			queued: []slot{{ProfileRetro, "b"}, {ProfileRetro, "c"}},
+			want:   slot{ProfileRetro, "b"},
+		},
+		{
+			name:    "waiters over their profile limit are skipped",
+			cfg:     SchedulerConfig{MaxConcurrent: 2, ProfileLimits: map[Profile]int{ProfileRetro: 1}},
+			held:    []slot{{ProfileRetro, "a"}, {ProfileEdge, "a"}},
+			queued:  []slot{{ProfileRetro, "b"}, {ProfileEdge, "c"}},
+			release: 1,
+			want:    slot{ProfileEdge, "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.QueueTimeout = time.Minute
+			s := NewScheduler(cfg)
+			releases := make([]func(), len(tt.held))
+			for i, h := range tt.held {
+				release, err := s.Acquire(context.Background(), h.profile, h.client)
+				if err != nil {
+					t.Fatalf("hold %v: %v", h, err)
+				}
+				releases[i] = release
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			granted := make(chan slot, len(tt.queued))
+			for i, q := range tt.queued {
+				go func() {
+					if _, err := s.Acquire(ctx, q.profile, q.client); err == nil {
+						granted <- q
+					}
+				}()
+				// Queue in order.
+				waitQueued(t, s, i+1)
+			}
+
+			releases[tt.release]()
+			select {
+			case got := <-granted:
+				if got != tt.want {
+					t.Errorf("granted %v, want %v", got, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no waiter was granted a slot")
+			}
+			select {
+			case got := <-granted:
+				t.Errorf("second waiter %v granted too", got)
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func waitQueued(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, queued := s.Stats(); queued >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waiting for %d queued jobs", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,233 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// containerOverhead pads the encoder bitrates when estimating output size.
+const containerOverhead = 1.05
+
+// ErrUnsatisfiableRange is returned by TimeSeekFor when a byte Range starts
+// past the estimated end of the transcode.
+var ErrUnsatisfiableRange = errors.New("transcode: range not satisfiable")
+
+// errRangeServed stops Stream once a closed byte range has been written.
+var errRangeServed = errors.New("transcode: requested range served")
+
+// TimeSeek is the playback window of an HTTP transcode request. Transcodes
+// have no real byte offsets, so seeks are expressed in time: ?t=/start=,
+// the DLNA TimeSeekRange header, or a byte Range converted through the
+// profile's bitrate.
+type TimeSeek struct {
+	// Start is where ffmpeg begins, in seconds.
+	Start float64
+	// Duration is the full media length in seconds; zero when unknown.
+	Duration float64
+
+	bytesPerSec float64
+	rangeStart  int64
+	rangeEnd    int64
+	dlna        bool
+}
+
+// TimeSeekFor works out the window for r. start is the already parsed
+// ?t=/start= value; it wins over headers. A byte Range is only mapped when
+// neither it nor TimeSeekRange.dlna.org is set.
+func (s *Service) TimeSeekFor(r *http.Request, profile Profile, start, duration float64) (TimeSeek, error) {
+	seek := TimeSeek{Start: start, Duration: duration, rangeStart: -1, rangeEnd: -1}
+	if spec, ok := s.profiles.Lookup(profile); ok {
+		seek.bytesPerSec = spec.EstimatedBitrate() / 8
+	}
+	if raw := r.Header.Get("TimeSeekRange.dlna.org"); raw != "" {
+		if secs, ok := parseNPTStart(raw); ok {
+			seek.dlna = true
+			if start == 0 {
+				seek.Start = secs
+			}
+		}
+	}
+	if start == 0 && !seek.dlna {
+		if from, to, ok := parseByteRange(r.Header.Get("Range")); ok && seek.bytesPerSec > 0 {
+			if total := seek.estimatedSize(); total > 0 && from >= total {
+				return seek, ErrUnsatisfiableRange
+			}
+			seek.rangeStart, seek.rangeEnd = from, to
+			seek.Start = float64(from) / seek.bytesPerSec
+		}
+	}
+	if seek.Duration > 0 && seek.Start >= seek.Duration {
+		seek.Start = max(0, seek.Duration-1)
+	}
+	return seek, nil
+}
+
+// Remaining is the playable length from Start, or zero when unknown.
+func (t TimeSeek) Remaining() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return max(0, t.Duration-t.Start)
+}
+
+func (t TimeSeek) estimatedSize() int64 {
+	return int64(t.Duration * t.bytesPerSec)
+}
+
+// SetHeaders adds the duration hints (X-Content-Duration, Content-Duration,
+// TimeSeekRange.dlna.org) and, for a mapped byte Range, an estimated
+// Content-Range. It returns the status code to answer with.
+func (t TimeSeek) SetHeaders(h http.Header) int {
+	h.Set("Accept-Ranges", "bytes")
+	if remaining := t.Remaining(); remaining > 0 {
+		h.Set("X-Content-Duration", strconv.FormatFloat(remaining, 'f', 3, 64))
+		h.Set("Content-Duration", strconv.Itoa(int(remaining+0.5)))
+	}
+	if t.dlna && t.Duration > 0 {
+		// Set directly: some DLNA renderers match the header name case-sensitively.
+		h["TimeSeekRange.dlna.org"] = []string{fmt.Sprintf("npt=%.3f-%.3f/%.3f", t.Start, t.Duration, t.Duration)}
+	}
+	if t.rangeStart < 0 {
+		// DLNA answers time seeks with 200 and the TimeSeekRange header.
+		return http.StatusOK
+	}
+	total := t.estimatedSize()
+	end := total - 1
+	if t.rangeEnd >= 0 && t.rangeEnd < end {
+		end = t.rangeEnd
+	}
+	if total > 0 {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", t.rangeStart, end, total))
+	} else {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-*/*", t.rangeStart))
+	}
+	return http.StatusPartialContent
+}
+
+// Writer wraps w so the seek headers and status go out with the first byte,
+// after Stream has set the content type. A closed byte range (e.g. the
+// "bytes=0-1" probe some players send) ends the response once it is filled.
+func (t TimeSeek) Writer(w http.ResponseWriter) http.ResponseWriter {
+	sw := &seekWriter{ResponseWriter: w, seek: t, limit: -1}
+	if t.rangeStart >= 0 && t.rangeEnd >= t.rangeStart {
+		sw.limit = t.rangeEnd - t.rangeStart + 1
+	}
+	return sw
+}
+
+// IsRangeServed reports whether err only signals that a closed byte range
+// was written in full.
+func IsRangeServed(err error) bool {
+	return errors.Is(err, errRangeServed)
+}
+
+type seekWriter struct {
+	http.ResponseWriter
+	seek        TimeSeek
+	wroteHeader bool
+	limit       int64
+}
+
+func (w *seekWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code == http.StatusOK {
+		code = w.seek.SetHeaders(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *seekWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.limit < 0 {
+		return w.ResponseWriter.Write(p)
+	}
+	if int64(len(p)) > w.limit {
+		p = p[:w.limit]
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.limit -= int64(n)
+	if err == nil && w.limit == 0 {
+		err = errRangeServed
+	}
+	return n, err
+}
+
+func (w *seekWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// EstimatedBitrate returns the expected output rate in bit/s from the video
+// and audio bitrates, padded for container overhead. Zero means unknown.
+func (p ProfileSpec) EstimatedBitrate() float64 {
+	var total float64
+	if p.Video != nil {
+		total += parseBitrate(p.Video.Bitrate)
+	}
+	if p.Audio != nil {
+		total += parseBitrate(p.Audio.Bitrate)
+	}
+	return total * containerOverhead
+}
+
+// parseBitrate reads ffmpeg-style rates such as "120k", "1.5M" or "64000".
+func parseBitrate(v string) float64 {
+	v = strings.TrimSpace(strings.ToLower(v))
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(v, "k"):
+		mult, v = 1e3, strings.TrimSuffix(v, "k")
+	case strings.HasSuffix(v, "m"):
+		mult, v = 1e6, strings.TrimSuffix(v, "m")
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n * mult
+}
+
+// parseNPTStart reads the start of "npt=<start>-[<end>]" (seconds or hh:mm:ss).
+func parseNPTStart(v string) (float64, bool) {
+	v = strings.TrimSpace(v)
+	npt, ok := strings.CutPrefix(strings.ToLower(v), "npt=")
+	if !ok {
+		return 0, false
+	}
+	start, _, _ := strings.Cut(npt, "-")
+	if strings.TrimSpace(start) == "now" {
+		return 0, true
+	}
+	return ParseTimeSpec(start)
+}
+
+// parseByteRange reads a single "bytes=<from>-[<to>]" range; to is -1 when
+// open-ended. Suffix ranges cannot be mapped to a time and are ignored.
+func parseByteRange(v string) (from, to int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(v), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	fromRaw, toRaw, _ := strings.Cut(spec, "-")
+	from, err := strconv.ParseInt(strings.TrimSpace(fromRaw), 10, 64)
+	if err != nil || from < 0 {
+		return 0, 0, false
+	}
+	to = -1
+	if toRaw = strings.TrimSpace(toRaw); toRaw != "" {
+		to, err = strconv.ParseInt(toRaw, 10, 64)
+		if err != nil || to < from {
+			return 0, 0, false
+		}
+	}
+	return from, to, true
+}
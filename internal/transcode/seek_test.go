@@ -0,0 +1,303 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTimeSeekFor(t *testing.T) {
+	const duration = 600.0
+	spec, _ := DefaultProfiles().Lookup(ProfileRetro)
+	bytesPerSec := spec.EstimatedBitrate() / 8
+	total := int64(duration * bytesPerSec)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		start   float64
+		// duration overrides the 600s default when set; -1 means unknown.
+		duration  float64
+		wantStart float64
+		wantErr   error
+		// wantStatus and the headers are what SetHeaders reports.
+		wantStatus       int
+		wantContentRange string
+		wantNPT          string
+	}{
+		{
+			name:       "no seek",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "query start",
+			start:      42,
+			wantStart:  42,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "npt seconds, open-ended",
+			headers:    map[string]string{"TimeSeekRange.dlna.org": "npt=90.5-"},
+			wantStart:  90.5,
+			wantStatus: http.StatusOK,
+			wantNPT:    "npt=90.500-600.000/600.000",
+		},
+		{
+			name:       "npt clock range",
+			headers:    map[string]string{"TimeSeekRange.dlna.org": "npt=00:01:30-00:02:00"},
+			wantStart:  90,
+			wantStatus: http.StatusOK,
+			wantNPT:    "npt=90.000-600.000/600.000",
+		},
+		{
+			name:       "npt now",
+			headers:    map[string]string{"TimeSeekRange.dlna.org": "NPT=now-"},
+			wantStatus: http.StatusOK,
+			wantNPT:    "npt=0.000-600.000/600.000",
+		},
+		{
+			name:       "npt past the end is clamped",
+			headers:    map[string]string{"TimeSeekRange.dlna.org": "npt=900-"},
+			wantStart:  599,
+			wantStatus: http.StatusOK,
+			wantNPT:    "npt=599.000-600.000/600.000",
+		},
+		{
+			name:       "query start wins over npt",
+			headers:    map[string]string{"TimeSeekRange.dlna.org": "npt=60-"},
+			start:      30,
+			wantStart:  30,
+			wantStatus: http.StatusOK,
+			wantNPT:    "npt=30.000-600.000/600.000",
+		},
+		{
+			name:       "npt wins over a byte range",
+			headers:    map[string]string{"TimeSeekRange.dlna.org": "npt=60-", "Range": "bytes=1000-"},
+			wantStart:  60,
+			wantStatus: http.StatusOK,
+			wantNPT:    "npt=60.000-600.000/600.000",
+		},
+		{
+			name:       "npt without a duration sends no range back",
+			headers:    map[string]string{"TimeSeekRange.dlna.org": "npt=60-"},
+			duration:   -1,
+			wantStart:  60,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:             "open-ended byte range",
+			headers:          map[string]string{"Range": "bytes=173512-"},
+			wantStart:        173512 / bytesPerSec,
+			wantStatus:       http.StatusPartialContent,
+			wantContentRange: fmt.Sprintf("bytes 173512-%d/%d", total-1, total),
+		},
+		{
+			name:             "closed byte range",
+			headers:          map[string]string{"Range": "bytes=0-1"},
+			wantStatus:       http.StatusPartialContent,
+			wantContentRange: fmt.Sprintf("bytes 0-1/%d", total),
+		},
+		{
+			name:             "range end past the estimate is cut",
+			headers:          map[string]string{"Range": "bytes=100-999999999"},
+			wantStart:        100 / bytesPerSec,
+			wantStatus:       http.StatusPartialContent,
+			wantContentRange: fmt.Sprintf("bytes 100-%d/%d", total-1, total),
+		},
+		{
+			name:             "range without a duration",
+			headers:          map[string]string{"Range": "bytes=100-"},
+			duration:         -1,
+			wantStart:        100 / bytesPerSec,
+			wantStatus:       http.StatusPartialContent,
+			wantContentRange: "bytes 100-*/*",
+		},
+		{
+			name:    "range past the estimated end",
+			headers: map[string]string{"Range": fmt.Sprintf("bytes=%d-", total)},
+			wantErr: ErrUnsatisfiableRange,
+		},
+		{
+			name:       "query start ignores the byte range",
+			headers:    map[string]string{"Range": "bytes=1000-"},
+			start:      5,
+			wantStart:  5,
+			wantStatus: http.StatusOK,
+		},
+		{name: "invalid npt", headers: map[string]string{"TimeSeekRange.dlna.org": "npt=soon-"}, wantStatus: http.StatusOK},
+		{name: "npt without prefix", headers: map[string]string{"TimeSeekRange.dlna.org": "60-"}, wantStatus: http.StatusOK},
+		{name: "suffix range", headers: map[string]string{"Range": "bytes=-500"}, wantStatus: http.StatusOK},
+		{name: "inverted range", headers: map[string]string{"Range": "bytes=500-100"}, wantStatus: http.StatusOK},
+		{name: "multiple ranges", headers: map[string]string{"Range": "bytes=0-1,5-6"}, wantStatus: http.StatusOK},
+		{name: "other unit", headers: map[string]string{"Range": "items=0-1"}, wantStatus: http.StatusOK},
+		{name: "garbage range", headers: map[string]string{"Range": "bytes=abc-"}, wantStatus: http.StatusOK},
+	}
+	svc := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			d := duration
+			switch {
+			case tt.duration < 0:
+				d = 0
+			case tt.duration > 0:
+				d = tt.duration
+			}
+			seek, err := svc.TimeSeekFor(r, ProfileRetro, tt.start, d)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(seek.Start-tt.wantStart) > 1e-9 {
+				t.Errorf("Start = %v, want %v", seek.Start, tt.wantStart)
+			}
+			h := http.Header{}
+			if status := seek.SetHeaders(h); status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if got := h.Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+			var npt string
+			if v := h["TimeSeekRange.dlna.org"]; len(v) > 0 {
+				npt = v[0]
+			}
+			if npt != tt.wantNPT {
+				t.Errorf("TimeSeekRange.dlna.org = %q, want %q", npt, tt.wantNPT)
+			}
+			if h.Get("Accept-Ranges") != "bytes" {
+				t.Error("Accept-Ranges missing")
+			}
+		})
+	}
+}
+
+func TestSeekWriter(t *testing.T) {
+	tests := []struct {
+		name       string
+		rangeHdr   string
+		code       int
+		writes     []string
+		wantStatus int
+		wantBody   string
+		// wantServed is whether the last write reports a filled range.
+		wantServed bool
+	}{
+		{
+			name:       "full response",
+			writes:     []string{"abc", "def"},
+			wantStatus: http.StatusOK,
+			wantBody:   "abcdef",
+		},
+		{
+			name:       "open-ended range streams on",
+			rangeHdr:   "bytes=100-",
+			writes:     []string{"abc", "def"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "abcdef",
+		},
+		{
+			name:       "closed range probe stops when filled",
+			rangeHdr:   "bytes=0-1",
+			writes:     []string{"abcdef"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "ab",
+			wantServed: true,
+		},
+		{
+			name:       "closed range across writes",
+			rangeHdr:   "bytes=10-14",
+			writes:     []string{"abc", "defgh"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "abcde",
+			wantServed: true,
+		},
+		{
+			name:       "errors keep their status",
+			rangeHdr:   "bytes=100-",
+			code:       http.StatusServiceUnavailable,
+			writes:     []string{"busy"},
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "busy",
+		},
+	}
+	svc := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.rangeHdr != "" {
+				r.Header.Set("Range", tt.rangeHdr)
+			}
+			seek, err := svc.TimeSeekFor(r, ProfileRetro, 0, 600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			w := seek.Writer(rec)
+			w.Header().Set("Content-Type", "video/3gpp")
+			if tt.code != 0 {
+				w.WriteHeader(tt.code)
+			}
+			var lastErr error
+			for _, chunk := range tt.writes {
+				_, lastErr = w.Write([]byte(chunk))
+			}
+			if IsRangeServed(lastErr) != tt.wantServed {
+				t.Errorf("last write error = %v, want range served %v", lastErr, tt.wantServed)
+			}
+			if !tt.wantServed && lastErr != nil {
+				t.Errorf("write: %v", lastErr)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusPartialContent && rec.Header().Get("Content-Range") == "" {
+				t.Error("206 without Content-Range")
+			}
+			if tt.wantStatus != http.StatusPartialContent && rec.Header().Get("Content-Range") != "" {
+				t.Errorf("Content-Range %q on a %d", rec.Header().Get("Content-Range"), tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestEstimatedBitrate(t *testing.T) {
+	reg := DefaultProfiles()
+	tests := []struct {
+		profile Profile
+		want    float64
+	}{
+		// (120k video + 12.2k AMR) padded by 5%.
+		{ProfileRetro, 132200 * containerOverhead},
+		{ProfileAAC, 288000 * containerOverhead},
+		{ProfileAudioMP3, 128000 * containerOverhead},
+	}
+	for _, tt := range tests {
+		spec, ok := reg.Lookup(tt.profile)
+		if !ok {
+			t.Fatalf("%s missing", tt.profile)
+		}
+		if got := spec.EstimatedBitrate(); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s: EstimatedBitrate = %v, want %v", tt.profile, got, tt.want)
+		}
+	}
+	for raw, want := range map[string]float64{"120k": 120e3, "1.5M": 1.5e6, "64000": 64000, "": 0, "fast": 0, "-5k": 0} {
+		if got := parseBitrate(raw); got != want {
+			t.Errorf("parseBitrate(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
@@ -3,14 +3,15 @@ package transcode
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
-	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"youtube-mini/internal/ui"
 )
@@ -24,6 +25,14 @@ const (
 	ProfileMP3     Profile = "mp3"
 	ProfileEdge    Profile = "edge"
 	ProfileAndroid Profile = "android"
+
+	ProfileAudioMP3 Profile = "audio-mp3"
+	ProfileAudioAMR Profile = "audio-amr"
+	ProfileAudioAAC Profile = "audio-aac"
+	ProfileAudioOgg Profile = "audio-ogg"
+
+	ProfileRadioAMR Profile = "radio-amr"
+	ProfileRadioAAC Profile = "radio-aac"
 )
 
 const (
@@ -37,6 +46,11 @@ type ffmpegInput struct {
 	postSeek bool
 	start    float64
 	srcURL   string
+	// separateAudio is set when input 1 carries the audio for input 0.
+	separateAudio bool
+	// subtitles is a local SRT file added by attachSubtitles.
+	subtitles    string
+	subtitleMode SubtitleMode
 }
 
 // Service converts modern streams to legacy-friendly formats on the fly.
@@ -44,26 +58,49 @@ type Service struct {
 	command       string
 	client        *http.Client
 	resolver      StreamResolver
+	captions      CaptionResolver
+	feeds         FeedResolver
 	rtsp          *rtspServer
 	rtspAddr      string
 	retroFilter   string
 	rtspTransport string
+	rtspIdle      time.Duration
+	rtspAuth      rtspAuth
 	udpRTPAddr    string
 	udpRTCPAddr   string
+	scheduler     *Scheduler
+	broadcasts    *broadcastHub
+	cache         *DiskCache
+	profiles      *ProfileRegistry
+	backend       Backend
+	hls           *hlsManager
+	jobs          *jobRegistry
+	imageLimits   ImageLimits
+	slides        *slideManager
+	downloads     *DownloadManager
+	sync          *Syncer
 }
 
 const DefaultRetroFilter = "eq=contrast=1.08:saturation=1.08,unsharp=4:4:0.45"
 
 // New returns a Service with defaults.
 func New() *Service {
-	return &Service{
+	s := &Service{
 		command:       "ffmpeg",
 		client:        http.DefaultClient,
 		rtspAddr:      defaultRTSPAddress,
 		rtspTransport: "udp",
+		rtspIdle:      defaultRTSPIdle,
 		udpRTPAddr:    "0.0.0.0:6970",
 		udpRTCPAddr:   "0.0.0.0:6971",
+		broadcasts:    newBroadcastHub(),
+		profiles:      DefaultProfiles(),
+		jobs:          newJobRegistry(),
+		imageLimits:   DefaultImageLimits,
+		slides:        &slideManager{sets: make(map[string]*slideSet)},
 	}
+	s.hls = newHLSManager(s)
+	return s
 }
 
 // WithCommand overrides the ffmpeg binary path.
@@ -72,6 +109,56 @@ func (s *Service) WithCommand(path string) *Service {
 	return s
 }
 
+// WithProfiles replaces the transcode profile registry.
+func (s *Service) WithProfiles(reg *ProfileRegistry) *Service {
+	if reg != nil {
+		s.profiles = reg
+	}
+	return s
+}
+
+// Profiles exposes the profile registry used for routing and link generation.
+func (s *Service) Profiles() *ProfileRegistry {
+	return s.profiles
+}
+
+// WithScheduler limits concurrent ffmpeg processes for HTTP and RTSP outputs.
+func (s *Service) WithScheduler(cfg SchedulerConfig) *Service {
+	s.scheduler = NewScheduler(cfg)
+	return s
+}
+
+// Scheduler exposes the transcode slot scheduler (nil when unlimited).
+func (s *Service) Scheduler() *Scheduler {
+	return s.scheduler
+}
+
+// EnableDiskCache stores finished full-length transcodes under dir, bounded to maxBytes.
+func (s *Service) EnableDiskCache(dir string, maxBytes int64) error {
+	cache, err := NewDiskCache(dir, maxBytes)
+	if err != nil {
+		return err
+	}
+	s.cache = cache
+	return nil
+}
+
+// ServeCached answers r from the disk cache (with Range support) when a finished
+// transcode of videoID in profile is available, and reports whether it did.
+func (s *Service) ServeCached(w http.ResponseWriter, r *http.Request, videoID string, profile Profile) bool {
+	return s.cache.Serve(w, r, cacheKey(videoID, profile, s.retroFilter))
+}
+
+// PurgeCache drops every cached transcode.
+func (s *Service) PurgeCache() int {
+	return s.cache.Purge()
+}
+
+// CacheStats reports disk cache usage; the zero value means caching is disabled.
+func (s *Service) CacheStats() CacheStats {
+	return s.cache.Stats()
+}
+
 // WithHTTPClient overrides the fetch client.
 func (s *Service) WithHTTPClient(client *http.Client) *Service {
 	if client != nil {
@@ -154,160 +241,285 @@ func portNumber(addr string) (int, error) {
 	return strconv.Atoi(port)
 }
 
-func (s *Service) buildInput(srcURL string, start float64) (ffmpegInput, func(), error) {
+func (s *Service) buildInput(src Source, start float64) (ffmpegInput, func(), error) {
+	return s.buildInputPaced(src, start, true)
+}
+
+// buildInputPaced is buildInput with optional -re; offline outputs such as
+// GIFs and slideshow frames are produced as fast as ffmpeg can decode.
+// A Source with AudioURL becomes two inputs, each seeked on its own.
+func (s *Service) buildInputPaced(src Source, start float64, realtime bool) (ffmpegInput, func(), error) {
 	spec := ffmpegInput{
-		args:   []string{"-hide_banner", "-re"},
-		srcURL: srcURL,
+		args:   append([]string{"-hide_banner"}, progressArgs...),
+		srcURL: src.URL,
 		start:  start,
 	}
-	var cleanup func()
+	var cleanups []func()
+	cleanup := func() {
+		for _, fn := range cleanups {
+			fn()
+		}
+	}
 
+	srcURL := src.URL
 	if requiresPipe(srcURL) {
 		proxy, err := newStreamProxy(s.client, srcURL)
 		if err == nil {
 			srcURL = proxy.URL()
-			cleanup = proxy.Close
-		} else {
+			cleanups = append(cleanups, proxy.Close)
+		} else if src.AudioURL == "" {
 			log.Printf("[proxy] falling back to pipe input: %v", err)
 			spec.pipe = true
+			if realtime {
+				spec.args = append(spec.args, "-re")
+			}
 			spec.args = append(spec.args, "-i", "pipe:0")
 			if start > 0 {
 				spec.postSeek = true
 			}
 			return spec, nil, nil
+		} else {
+			// stdin can only feed one of the two inputs.
+			return ffmpegInput{}, nil, fmt.Errorf("proxy: %w", err)
 		}
 	}
+	spec.args = append(spec.args, inputArgs(srcURL, start, realtime)...)
+	spec.srcURL = srcURL
+
+	if src.AudioURL != "" {
+		audioURL := src.AudioURL
+		if requiresPipe(audioURL) {
+			proxy, err := newStreamProxy(s.client, audioURL)
+			if err != nil {
+				cleanup()
+				return ffmpegInput{}, nil, fmt.Errorf("proxy: %w", err)
+			}
+			audioURL = proxy.URL()
+			cleanups = append(cleanups, proxy.Close)
+		}
+		spec.args = append(spec.args, inputArgs(audioURL, start, realtime)...)
+		spec.separateAudio = true
+	}
+	if len(cleanups) == 0 {
+		return spec, nil, nil
+	}
+	return spec, cleanup, nil
+}
 
+// inputArgs renders the per-input options and -i for one upstream URL.
+func inputArgs(url string, start float64, realtime bool) []string {
+	var args []string
+	if realtime {
+		args = append(args, "-re")
+	}
 	if start > 0 {
-		spec.args = append(spec.args, "-ss", formatSeek(start))
+		args = append(args, "-ss", formatSeek(start))
 	}
-	spec.args = append(spec.args,
+	return append(args,
 		"-headers", fmt.Sprintf("Referer: %s\r\n", defaultReferer),
 		"-user_agent", defaultUserAgent,
-		"-i", srcURL,
+		"-i", url,
 	)
-	spec.srcURL = srcURL
-	return spec, cleanup, nil
 }
 
-// Stream launches ffmpeg and proxies the converted output to the ResponseWriter.
-func (s *Service) Stream(ctx context.Context, w http.ResponseWriter, srcURL, videoID string, profile Profile, start float64) error {
-	input, cleanup, err := s.buildInput(srcURL, start)
-	if err != nil {
-		return err
+// mapArgs selects the picture and sound streams when they come from separate
+// inputs; a single input needs no mapping.
+func (in ffmpegInput) mapArgs(video, audio bool) []string {
+	if !in.separateAudio {
+		return nil
 	}
-	if cleanup != nil {
-		defer cleanup()
+	var args []string
+	if video {
+		args = append(args, "-map", "0:v:0")
+	}
+	if audio {
+		args = append(args, "-map", "1:a:0")
 	}
+	return args
+}
 
-	args, format, err := s.profileArgs(profile, input)
+// Stream launches ffmpeg and proxies the converted output to the ResponseWriter.
+// Concurrent requests for the same video, profile and start offset share one
+// ffmpeg process. When a scheduler is configured and no slot frees up, a
+// *BusyError is returned before anything is written to w. The cheapest of
+// sources that still fits the profile is used as input. A caption track
+// requested with WithSubtitles is burned in or muxed as timed text.
+func (s *Service) Stream(ctx context.Context, w http.ResponseWriter, sources []SourceFormat, videoID string, profile Profile, start float64) error {
+	key := broadcastKey(videoID, profile, start)
+	if subs, ok := subtitlesFromContext(ctx); ok {
+		key += "|" + subs.key()
+	}
+	b, reader, created := s.broadcasts.attach(key)
+	if reader == nil {
+		return errors.New("transcode: could not attach to encode")
+	}
+	defer b.leave(reader)
+
+	if created {
+		if err := s.launchBroadcast(ctx, b, sources, videoID, profile, start); err != nil {
+			b.finish(err)
+			return err
+		}
+	}
+
+	format, err := b.waitStarted(ctx)
 	if err != nil {
 		return err
 	}
 
-	cmd := exec.CommandContext(ctx, s.command, args...)
-	var stdin io.WriteCloser
-	if input.pipe {
-		stdInPipe, err := cmd.StdinPipe()
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, ui.Escape(format.FileName(videoID))))
+	w.Header().Set("Transfer-Encoding", "chunked")
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		chunk, err := reader.next(ctx)
 		if err != nil {
-			return fmt.Errorf("stdin pipe: %w", err)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("proxy: %w", err)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+		if flusher != nil {
+			flusher.Flush()
 		}
-		stdin = stdInPipe
 	}
-	stdout, err := cmd.StdoutPipe()
+}
+
+// launchBroadcast starts the ffmpeg process that feeds b, holding a
+// scheduler slot when it runs locally. The process outlives the request that
+// started it and is cancelled when the last reader leaves.
+func (s *Service) launchBroadcast(ctx context.Context, b *broadcast, sources []SourceFormat, videoID string, profile Profile, start float64) error {
+	encodeCtx, cancel := context.WithCancel(context.Background())
+	fail := func(err error) error {
+		cancel()
+		return err
+	}
+
+	src, err := pickSource(sources, s.sourceNeedsFor(profile))
 	if err != nil {
-		return fmt.Errorf("stdout pipe: %w", err)
+		return fail(err)
 	}
-	stderr, err := cmd.StderrPipe()
+	input, cleanup, err := s.buildInput(src, start)
 	if err != nil {
-		return fmt.Errorf("stderr pipe: %w", err)
+		return fail(err)
 	}
-
-	go func() {
-		Scanner := newStderrScanner(stderr)
-		for Scanner.Scan() {
-			line := Scanner.Text()
-			if !strings.Contains(line, "frame=") {
-				log.Printf("[ffmpeg] %s", line)
+	if cleanup == nil {
+		cleanup = func() {}
+	}
+	subs, hasSubs := subtitlesFromContext(ctx)
+	if hasSubs {
+		if removeSubs, err := s.attachSubtitles(ctx, &input, videoID, subs); err != nil {
+			log.Printf("[ffmpeg] id=%s: %v (continuing without)", videoID, err)
+		} else {
+			inputCleanup := cleanup
+			cleanup = func() {
+				inputCleanup()
+				removeSubs()
 			}
 		}
-	}()
-
-	if err := cmd.Start(); err != nil {
-		if stdin != nil {
-			_ = stdin.Close()
-		}
-		return fmt.Errorf("ffmpeg start: %w", err)
 	}
 
-	if input.pipe && stdin != nil {
-		s.startInputPump(ctx, stdin, input.srcURL)
+	args, format, err := s.profileArgs(profile, input)
+	if err != nil {
+		cleanup()
+		return fail(err)
 	}
 
-	w.Header().Set("Content-Type", format.ContentType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, ui.Escape(format.FileName(videoID))))
-	w.Header().Set("Transfer-Encoding", "chunked")
+	command := Command{Args: args, Stdin: input.pipe}
+	if !hasSubs {
+		command.Spec = s.transcodeSpec(videoID, src, profile, start)
+	}
+	proc, release, err := s.startScheduled(ctx, encodeCtx, command, profile, clientFromContext(ctx))
+	if err != nil {
+		cleanup()
+		return fail(err)
+	}
+	job := s.jobs.add(JobHTTP, videoID, profile, clientFromContext(ctx), start)
+	go logFFmpeg(proc.Stderr(), "[ffmpeg]", job)
 
-	if _, err := io.Copy(w, stdout); err != nil {
-		return fmt.Errorf("proxy: %w", err)
+	if stdin := proc.Stdin(); stdin != nil {
+		s.startInputPump(encodeCtx, stdin, input.srcURL)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("ffmpeg wait: %w", err)
+	var tee *cacheWriter
+	if start == 0 && !hasSubs {
+		if tee = s.cache.newWriter(cacheKey(videoID, profile, s.retroFilter), videoID, profile); tee != nil {
+			b.sink = tee
+		}
 	}
-	log.Printf("[ffmpeg] finished id=%s profile=%s format=%s", videoID, profile, format.Extension)
+
+	b.begin(format, cancel)
+
+	go func() {
+		defer release()
+		defer cleanup()
+		defer cancel()
+
+		pumpErr := b.pump(proc.Stdout())
+		stopped := encodeCtx.Err() != nil
+		if pumpErr != nil {
+			// Unblock ffmpeg if it is still writing into a pipe nobody drains.
+			cancel()
+		}
+		waitErr := proc.Wait()
+		s.jobs.done(job, !stopped && (pumpErr != nil || waitErr != nil))
+		if tee != nil {
+			if !stopped && pumpErr == nil && waitErr == nil {
+				tee.commit(format)
+			} else {
+				tee.abort()
+			}
+		}
+		switch {
+		case stopped:
+			log.Printf("[ffmpeg] stopped id=%s profile=%s (no readers left)", videoID, profile)
+			b.finish(encodeCtx.Err())
+		case pumpErr != nil:
+			b.finish(fmt.Errorf("proxy: %w", pumpErr))
+		case waitErr != nil:
+			b.finish(fmt.Errorf("ffmpeg wait: %w", waitErr))
+		default:
+			log.Printf("[ffmpeg] finished id=%s profile=%s format=%s", videoID, profile, format.Extension)
+			b.finish(nil)
+		}
+	}()
 	return nil
 }
 
 func (s *Service) profileArgs(profile Profile, input ffmpegInput) (args []string, format outputFormat, err error) {
-	args = append(args, input.args...)
+	spec, ok := s.profiles.Lookup(profile)
+	if !ok || !spec.HTTP {
+		return nil, outputFormat{}, fmt.Errorf("unknown profile %q", profile)
+	}
+	return httpArgs(spec, input, s.retroFilter), spec.Format(), nil
+}
+
+// httpArgs renders the ffmpeg command line that writes spec to stdout.
+func httpArgs(spec ProfileSpec, input ffmpegInput, retroFilter string) []string {
+	args := append([]string{}, input.args...)
+	soft := input.subtitles != "" && input.subtitleMode == SubtitlesSoft && spec.Video != nil && spec.carriesTimedText()
+	if soft {
+		args = append(args, "-i", input.subtitles)
+	}
 	if input.pipe && input.postSeek {
 		args = append(args, "-ss", formatSeek(input.start))
 	}
-
-	switch profile {
-	case ProfileAAC:
-		args = append(args,
-			"-vf", "scale=320:240,fps=15",
-			"-c:v", "mpeg4", "-b:v", "256k",
-			"-c:a", "aac", "-ar", "16000", "-ac", "1", "-b:a", "32k",
-			"-movflags", "frag_keyframe+empty_moov",
-			"-f", "mp4", "pipe:1",
-		)
-		return args, outputFormat{ContentType: "video/mp4", Extension: "mp4", Suffix: "_aac"}, nil
-	case ProfileMP3:
-		args = append(args,
-			"-vf", "scale=176:144,fps=12",
-			"-c:v", "mpeg4", "-b:v", "120k",
-			"-c:a", "libmp3lame", "-ar", "11025", "-ac", "1", "-b:a", "24k",
-			"-f", "avi", "pipe:1",
-		)
-		return args, outputFormat{ContentType: "video/x-msvideo", Extension: "avi", Suffix: "_mp3"}, nil
-	case ProfileEdge:
-		vf := buildFilterChain([]string{"scale=128:96", "fps=10"}, s.retroFilter)
-		args = append(args,
-			"-vf", vf,
-			"-c:v", "h263", "-b:v", "60k",
-			"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "10.2k",
-			"-use_editlist", "0",
-			"-movflags", "+faststart+frag_keyframe+empty_moov",
-			"-f", "3gp", "pipe:1",
-		)
-		return args, outputFormat{ContentType: "video/3gpp", Extension: "3gp", Suffix: "_edge"}, nil
-	case ProfileRetro, "":
-		vf := buildFilterChain([]string{"scale=176:144", "fps=12"}, s.retroFilter)
-		args = append(args,
-			"-vf", vf,
-			"-c:v", "h263", "-b:v", "120k",
-			"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "12.2k",
-			"-use_editlist", "0",
-			"-movflags", "+faststart+frag_keyframe+empty_moov",
-			"-f", "3gp", "pipe:1",
-		)
-		return args, outputFormat{ContentType: "video/3gpp", Extension: "3gp", Suffix: "_retro"}, nil
+	args = append(args, spec.codecArgs(retroFilter, burnOverlay(spec, input, soft))...)
+	switch {
+	case soft && input.separateAudio:
+		args = append(args, input.mapArgs(true, true)...)
+		args = append(args, "-map", "2:s:0", "-c:s", "mov_text")
+	case soft:
+		args = append(args, "-map", "0:v:0", "-map", "0:a:0?", "-map", "1:s:0", "-c:s", "mov_text")
 	default:
-		return nil, outputFormat{}, fmt.Errorf("unknown profile %q", profile)
+		args = append(args, input.mapArgs(spec.Video != nil, spec.Audio != nil)...)
 	}
+	args = append(args, spec.MuxArgs...)
+	return append(args, "-f", spec.Container, "pipe:1")
 }
 
 func (s *Service) profileRTSPArgs(profile Profile, input ffmpegInput, target string, transport string) ([]string, error) {
@@ -330,41 +542,13 @@ func (s *Service) profileRTSPArgs(profile Profile, input ffmpegInput, target str
 		transport = s.rtspTransport
 	}
 
-	switch profile {
-	case ProfileRetro, "":
-		vf := buildFilterChain([]string{"scale=176:144", "fps=12"}, s.retroFilter)
-		base = append(base,
-			"-vf", vf,
-			"-c:v", "h263", "-b:v", "120k",
-			"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "12.2k",
-		)
-	case ProfileEdge:
-		vf := buildFilterChain([]string{"scale=128:96", "fps=10"}, s.retroFilter)
-		base = append(base,
-			"-vf", vf,
-			"-c:v", "h263", "-b:v", "60k",
-			"-c:a", "libopencore_amrnb", "-ar", "8000", "-ac", "1", "-b:a", "10.2k",
-		)
-	case ProfileAndroid:
-		vf := buildFilterChain([]string{"scale=320:180", "fps=24"}, s.retroFilter)
-		base = append(base,
-			"-vf", vf,
-			"-c:v", "mpeg4",
-			"-pix_fmt", "yuv420p",
-			"-b:v", "350k",
-			"-maxrate", "400k",
-			"-bufsize", "800k",
-			"-bf", "0",
-			"-g", "48",
-			"-keyint_min", "24",
-			"-c:a", "aac",
-			"-ar", "44100",
-			"-ac", "2",
-			"-b:a", "64k",
-		)
-	default:
+	spec, ok := s.profiles.Lookup(profile)
+	if !ok || !spec.RTSP {
 		return nil, fmt.Errorf("profile %s does not support RTSP output", profile)
 	}
+	// RTP has no timed text payload here, so captions are always burned in.
+	base = append(base, input.mapArgs(spec.Video != nil, spec.Audio != nil)...)
+	base = append(base, spec.codecArgs(s.retroFilter, burnOverlay(spec, input, false))...)
 	base = append(base,
 		"-f", "rtsp",
 		"-rtsp_transport", transport,
@@ -375,10 +559,22 @@ func (s *Service) profileRTSPArgs(profile Profile, input ffmpegInput, target str
 	return base, nil
 }
 
+// burnOverlay returns the subtitles filter for input unless the track is
+// muxed as a soft track or the profile has no picture.
+func burnOverlay(spec ProfileSpec, input ffmpegInput, soft bool) string {
+	if input.subtitles == "" || soft || spec.Video == nil {
+		return ""
+	}
+	return subtitleOverlay(input.subtitles, spec.Video.Height)
+}
+
 type outputFormat struct {
 	ContentType string
 	Extension   string
 	Suffix      string
+	// Fragmented marks ISO BMFF output muxed with a frag_* movflag, whose
+	// header can be replayed to readers that join between fragments.
+	Fragmented bool
 }
 
 func (o outputFormat) FileName(videoID string) string {
@@ -0,0 +1,225 @@
+package transcode
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"youtube-mini/internal/youtube"
+)
+
+// defaultSourceHeight is assumed when a profile does not fix its output height.
+const defaultSourceHeight = 360
+
+// SourceFormat is one upstream rendition the source picker can choose from.
+// An empty Mime marks a plain URL of unknown content, treated as muxed.
+type SourceFormat struct {
+	URL     string
+	Itag    string
+	Mime    string
+	Width   int
+	Height  int
+	Bitrate int // bit/s
+}
+
+// HasVideo reports whether the rendition carries a picture.
+func (f SourceFormat) HasVideo() bool {
+	return f.Mime == "" || strings.HasPrefix(f.Mime, "video/")
+}
+
+// HasAudio reports whether the rendition carries sound. YouTube's adaptive
+// video formats list a single codec; progressive ones list video and audio.
+func (f SourceFormat) HasAudio() bool {
+	if f.Mime == "" || strings.HasPrefix(f.Mime, "audio/") {
+		return true
+	}
+	return strings.Contains(f.codecs(), ",")
+}
+
+func (f SourceFormat) codecs() string {
+	_, params, _ := strings.Cut(f.Mime, ";")
+	return strings.ToLower(params)
+}
+
+// decodeCost ranks video codecs by decoder CPU: H.264 and older are cheap,
+// VP9 costs more and AV1 the most.
+func (f SourceFormat) decodeCost() int {
+	codecs := f.codecs()
+	switch {
+	case strings.Contains(codecs, "av01"):
+		return 2
+	case strings.Contains(codecs, "vp9"), strings.Contains(codecs, "vp09"):
+		return 1
+	}
+	return 0
+}
+
+// shortSide is the smaller frame dimension, so portrait videos compare fairly.
+func (f SourceFormat) shortSide() int {
+	if f.Width > 0 && f.Height > 0 {
+		return min(f.Width, f.Height)
+	}
+	return f.Height
+}
+
+// Source is what ffmpeg reads: URL alone (muxed, video-only or audio-only),
+// or URL for the picture plus AudioURL as a second input.
+type Source struct {
+	URL      string `json:"url"`
+	AudioURL string `json:"audio_url,omitempty"`
+}
+
+// VideoSources lists every rendition of v for the source picker.
+func VideoSources(v youtube.Video) []SourceFormat {
+	out := make([]SourceFormat, 0, len(v.Formats)+len(v.Audio))
+	for _, group := range [][]youtube.Format{v.Formats, v.Audio} {
+		for _, f := range group {
+			if f.URL == "" {
+				continue
+			}
+			bitrate, _ := strconv.Atoi(f.Bitrate)
+			height := f.Height
+			if height == 0 {
+				// "144p", "720p60"
+				digits, _, _ := strings.Cut(f.Quality, "p")
+				height, _ = strconv.Atoi(digits)
+			}
+			out = append(out, SourceFormat{URL: f.URL, Itag: f.Itag, Mime: f.Mime, Width: f.Width, Height: height, Bitrate: bitrate})
+		}
+	}
+	return out
+}
+
+// sourceNeeds is what an output requires from its input.
+type sourceNeeds struct {
+	video bool
+	audio bool
+	// height is the output's short side; sources at least this tall are adequate.
+	height int
+	// audioBitrate is the output audio rate in bit/s.
+	audioBitrate float64
+}
+
+func (p ProfileSpec) sourceNeeds() sourceNeeds {
+	needs := sourceNeeds{video: p.Video != nil, audio: p.Audio != nil, height: defaultSourceHeight}
+	if p.Video != nil && p.Video.Width > 0 && p.Video.Height > 0 {
+		needs.height = min(p.Video.Width, p.Video.Height)
+	}
+	if p.Audio != nil {
+		needs.audioBitrate = parseBitrate(p.Audio.Bitrate)
+	}
+	return needs
+}
+
+// sourceNeedsFor looks up profile; unknown profiles ask for a muxed default.
+func (s *Service) sourceNeedsFor(profile Profile) sourceNeeds {
+	if spec, ok := s.profiles.Lookup(profile); ok {
+		return spec.sourceNeeds()
+	}
+	return sourceNeeds{video: true, audio: true, height: defaultSourceHeight}
+}
+
+var errNoSource = errors.New("transcode: no usable source format")
+
+// pickSource chooses the cheapest inputs that still satisfy needs: the
+// smallest video rendition at least needs.height tall (cheap codecs first),
+// and the lowest audio bitrate at or above the output's. Separate adaptive
+// streams become two inputs; a progressive format is used when it is no
+// larger than the chosen video-only one.
+func pickSource(formats []SourceFormat, needs sourceNeeds) (Source, error) {
+	var muxed, videoOnly, audioOnly []SourceFormat
+	for _, f := range formats {
+		if f.URL == "" {
+			continue
+		}
+		switch v, a := f.HasVideo(), f.HasAudio(); {
+		case v && a:
+			muxed = append(muxed, f)
+		case v:
+			videoOnly = append(videoOnly, f)
+		case a:
+			audioOnly = append(audioOnly, f)
+		}
+	}
+
+	if !needs.video {
+		if f, ok := pickAudio(audioOnly, needs.audioBitrate); ok {
+			return Source{URL: f.URL}, nil
+		}
+		if f, ok := pickVideo(muxed, 0); ok {
+			return Source{URL: f.URL}, nil
+		}
+		return Source{}, errNoSource
+	}
+
+	bestMuxed, hasMuxed := pickVideo(muxed, needs.height)
+	video, hasVideo := pickVideo(videoOnly, needs.height)
+	audio, hasAudio := pickAudio(audioOnly, needs.audioBitrate)
+	split := Source{URL: video.URL}
+	if needs.audio {
+		split.AudioURL = audio.URL
+	}
+	splitOK := hasVideo && (hasAudio || !needs.audio)
+	muxedAdequate := hasMuxed && bestMuxed.shortSide() >= needs.height
+	switch {
+	case splitOK && video.shortSide() >= needs.height &&
+		(!muxedAdequate || video.shortSide() < bestMuxed.shortSide()):
+		return split, nil
+	case hasMuxed && (muxedAdequate || !splitOK || bestMuxed.shortSide() >= video.shortSide()):
+		return Source{URL: bestMuxed.URL}, nil
+	case splitOK:
+		return split, nil
+	case hasVideo:
+		// Only silent renditions are left; encode without sound rather than fail.
+		return Source{URL: video.URL}, nil
+	}
+	return Source{}, errNoSource
+}
+
+// pickVideo returns the smallest format at least height tall, or the tallest
+// one when none is. Among equal sizes cheaper codecs and bitrates win.
+func pickVideo(formats []SourceFormat, height int) (SourceFormat, bool) {
+	if len(formats) == 0 {
+		return SourceFormat{}, false
+	}
+	sorted := append([]SourceFormat(nil), formats...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.shortSide() != b.shortSide() {
+			return a.shortSide() < b.shortSide()
+		}
+		if a.decodeCost() != b.decodeCost() {
+			return a.decodeCost() < b.decodeCost()
+		}
+		return a.Bitrate < b.Bitrate
+	})
+	for _, f := range sorted {
+		if f.shortSide() >= height {
+			return f, true
+		}
+	}
+	// Nothing is big enough: take the tallest, cheapest to decode.
+	tallest := sorted[len(sorted)-1].shortSide()
+	for _, f := range sorted {
+		if f.shortSide() == tallest {
+			return f, true
+		}
+	}
+	return sorted[len(sorted)-1], true
+}
+
+// pickAudio returns the lowest bitrate at or above bitrate, or the highest one.
+func pickAudio(formats []SourceFormat, bitrate float64) (SourceFormat, bool) {
+	if len(formats) == 0 {
+		return SourceFormat{}, false
+	}
+	sorted := append([]SourceFormat(nil), formats...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Bitrate < sorted[j].Bitrate })
+	for _, f := range sorted {
+		if float64(f.Bitrate) >= bitrate {
+			return f, true
+		}
+	}
+	return sorted[len(sorted)-1], true
+}
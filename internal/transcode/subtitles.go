@@ -0,0 +1,299 @@
+package transcode
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	subtitleFetchTimeout = 10 * time.Second
+	subtitleMaxBytes     = 4 << 20
+)
+
+// SubtitleMode selects how a caption track ends up in the output.
+type SubtitleMode string
+
+const (
+	// SubtitlesBurn renders the captions into the picture.
+	SubtitlesBurn SubtitleMode = "burn"
+	// SubtitlesSoft muxes a 3GPP timed text (tx3g) track; only MP4/3GP
+	// outputs can carry it, everything else falls back to burning in.
+	SubtitlesSoft SubtitleMode = "soft"
+)
+
+// SubtitleRequest names the caption track to add to an encode.
+type SubtitleRequest struct {
+	Lang string
+	// Translate asks YouTube to machine-translate the track (tlang).
+	Translate string
+	Mode      SubtitleMode
+}
+
+func (r SubtitleRequest) key() string {
+	return r.Lang + ">" + r.Translate + ":" + string(r.Mode)
+}
+
+// SubtitlesFromQuery reads ?subs=<lang>&subs_mode=burn|soft&tlang=<lang>.
+// The mode defaults to burn since few handsets render tx3g.
+func SubtitlesFromQuery(values url.Values) (SubtitleRequest, bool) {
+	lang := strings.TrimSpace(values.Get("subs"))
+	if lang == "" || !validLanguage(lang) {
+		return SubtitleRequest{}, false
+	}
+	req := SubtitleRequest{Lang: lang, Mode: SubtitlesBurn}
+	if tlang := strings.TrimSpace(values.Get("tlang")); validLanguage(tlang) {
+		req.Translate = tlang
+	}
+	if SubtitleMode(strings.ToLower(values.Get("subs_mode"))) == SubtitlesSoft {
+		req.Mode = SubtitlesSoft
+	}
+	return req, true
+}
+
+func validLanguage(code string) bool {
+	if code == "" || len(code) > 16 {
+		return false
+	}
+	for _, r := range code {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+type subtitlesKey struct{}
+
+// WithSubtitles tags ctx so Stream adds the requested caption track.
+func WithSubtitles(ctx context.Context, req SubtitleRequest) context.Context {
+	return context.WithValue(ctx, subtitlesKey{}, req)
+}
+
+func subtitlesFromContext(ctx context.Context) (SubtitleRequest, bool) {
+	req, ok := ctx.Value(subtitlesKey{}).(SubtitleRequest)
+	return req, ok
+}
+
+// CaptionResolver returns the timedtext URL of a video's caption track in
+// lang, optionally translated to tlang.
+type CaptionResolver interface {
+	ResolveCaptions(ctx context.Context, videoID, lang, tlang string) (string, error)
+}
+
+// CaptionResolverFunc adapts a function to CaptionResolver.
+type CaptionResolverFunc func(ctx context.Context, videoID, lang, tlang string) (string, error)
+
+// ResolveCaptions implements CaptionResolver.
+func (fn CaptionResolverFunc) ResolveCaptions(ctx context.Context, videoID, lang, tlang string) (string, error) {
+	return fn(ctx, videoID, lang, tlang)
+}
+
+// WithCaptionResolver enables ?subs= on HTTP and RTSP transcodes.
+func (s *Service) WithCaptionResolver(res CaptionResolver) *Service {
+	s.captions = res
+	return s
+}
+
+// attachSubtitles fetches the caption track for req into a temporary SRT file
+// and records it on input. Cue times are shifted by the input seek so they
+// line up with the re-based output timestamps; a post-seek (pipe) input keeps
+// the original timeline. The returned cleanup removes the file.
+func (s *Service) attachSubtitles(ctx context.Context, input *ffmpegInput, videoID string, req SubtitleRequest) (func(), error) {
+	if s.captions == nil {
+		return nil, errors.New("subtitles: caption resolver not configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, subtitleFetchTimeout)
+	defer cancel()
+	trackURL, err := s.captions.ResolveCaptions(ctx, videoID, req.Lang, req.Translate)
+	if err != nil {
+		return nil, fmt.Errorf("subtitles: %w", err)
+	}
+	cues, err := s.fetchCues(ctx, trackURL)
+	if err != nil {
+		return nil, fmt.Errorf("subtitles: %w", err)
+	}
+	shift := input.start
+	if input.postSeek {
+		shift = 0
+	}
+
+	f, err := os.CreateTemp("", "ytm-subs-*.srt")
+	if err != nil {
+		return nil, fmt.Errorf("subtitles: %w", err)
+	}
+	cleanup := func() { _ = os.Remove(f.Name()) }
+	werr := writeSRT(f, cues, shift)
+	if cerr := f.Close(); werr == nil {
+		werr = cerr
+	}
+	if werr != nil {
+		cleanup()
+		return nil, fmt.Errorf("subtitles: %w", werr)
+	}
+	input.subtitles = f.Name()
+	input.subtitleMode = req.Mode
+	return cleanup, nil
+}
+
+// subtitleCue is one caption line in seconds.
+type subtitleCue struct {
+	start float64
+	end   float64
+	text  string
+}
+
+func (s *Service) fetchCues(ctx context.Context, trackURL string) ([]subtitleCue, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, trackURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("Referer", defaultReferer)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("caption track: upstream status %s", resp.Status)
+	}
+	cues, err := parseTimedText(io.LimitReader(resp.Body, subtitleMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	if len(cues) == 0 {
+		return nil, errors.New("caption track is empty")
+	}
+	return cues, nil
+}
+
+// parseTimedText reads YouTube timedtext XML: srv3 (<p t="ms" d="ms">, text
+// possibly split into <s> segments) or srv1 (<text start="s" dur="s">).
+// Overlapping cues, as produced by rolling auto-captions, are cut at the next
+// cue's start so only one line is on screen at a time.
+func parseTimedText(r io.Reader) ([]subtitleCue, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	var (
+		cues []subtitleCue
+		cur  *subtitleCue
+		text strings.Builder
+	)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				start, dur := attrFloat(t, "t")/1000, attrFloat(t, "d")/1000
+				cur = &subtitleCue{start: start, end: start + dur}
+				text.Reset()
+			case "text":
+				start, dur := attrFloat(t, "start"), attrFloat(t, "dur")
+				cur = &subtitleCue{start: start, end: start + dur}
+				text.Reset()
+			case "br":
+				if cur != nil {
+					text.WriteString("\n")
+				}
+			}
+		case xml.CharData:
+			if cur != nil {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if cur == nil || (t.Name.Local != "p" && t.Name.Local != "text") {
+				continue
+			}
+			// srv1 bodies are HTML-escaped a second time.
+			cur.text = strings.TrimSpace(html.UnescapeString(text.String()))
+			if cur.text != "" && cur.end > cur.start {
+				cues = append(cues, *cur)
+			}
+			cur = nil
+		}
+	}
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].start < cues[j].start })
+	for i := 0; i+1 < len(cues); i++ {
+		if next := cues[i+1].start; cues[i].end > next && next > cues[i].start {
+			cues[i].end = next
+		}
+	}
+	return cues, nil
+}
+
+func attrFloat(el xml.StartElement, name string) float64 {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			v, _ := strconv.ParseFloat(a.Value, 64)
+			return v
+		}
+	}
+	return 0
+}
+
+// writeSRT writes cues moved back by shift seconds, dropping those that end
+// before the new zero.
+func writeSRT(w io.Writer, cues []subtitleCue, shift float64) error {
+	bw := bufio.NewWriter(w)
+	n := 0
+	for _, cue := range cues {
+		start, end := cue.start-shift, cue.end-shift
+		if end <= 0 {
+			continue
+		}
+		n++
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n\n", n, srtTime(max(start, 0)), srtTime(end), cue.text)
+	}
+	return bw.Flush()
+}
+
+func srtTime(secs float64) string {
+	ms := int64(secs*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// carriesTimedText reports whether the HTTP container can hold a tx3g track.
+func (p ProfileSpec) carriesTimedText() bool {
+	switch p.Container {
+	case "mp4", "3gp", "3g2", "mov":
+		return true
+	}
+	return false
+}
+
+// subtitleOverlay returns the filter that burns path into the picture. libass
+// sizes fonts against a 288-line canvas, so small outputs get a larger size
+// and an opaque box to stay legible at 176x144 and below.
+func subtitleOverlay(path string, height int) string {
+	size := 20
+	switch {
+	case height > 0 && height <= 96:
+		size = 34
+	case height > 0 && height <= 144:
+		size = 28
+	case height > 0 && height <= 240:
+		size = 24
+	}
+	style := fmt.Sprintf("FontSize=%d,Bold=1,BorderStyle=3,Outline=1,Shadow=0,MarginV=4", size)
+	// path comes from os.CreateTemp and never contains quotes.
+	return fmt.Sprintf("subtitles=filename='%s':force_style='%s'", path, style)
+}
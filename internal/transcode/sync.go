@@ -0,0 +1,459 @@
+package transcode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syncRulesFile = "sync-rules.json"
+	// syncCheckEvery is how often the scheduler wakes to prune and to see
+	// whether the nightly run is due.
+	syncCheckEvery = 10 * time.Minute
+	// syncFeedDepth is how many recent uploads a rule without KeepLast looks at.
+	syncFeedDepth = 10
+	// syncSeenLimit caps the remembered video ids per rule.
+	syncSeenLimit   = 200
+	defaultSyncHour = 3
+)
+
+// FeedResolver lists a channel's recent uploads, newest first.
+type FeedResolver interface {
+	ChannelUploads(ctx context.Context, channelID string) ([]FeedEntry, error)
+}
+
+// FeedResolverFunc is an adapter to allow the use of regular functions as feed resolvers.
+type FeedResolverFunc func(ctx context.Context, channelID string) ([]FeedEntry, error)
+
+// ChannelUploads implements FeedResolver.
+func (fn FeedResolverFunc) ChannelUploads(ctx context.Context, channelID string) ([]FeedEntry, error) {
+	return fn(ctx, channelID)
+}
+
+// FeedEntry is one upload in a channel feed.
+type FeedEntry struct {
+	VideoID string
+	Title   string
+	Channel string
+	// Duration is the length in seconds, 0 for live or unknown.
+	Duration float64
+}
+
+// WithFeedResolver injects the channel feed source used by subscription sync.
+func (s *Service) WithFeedResolver(res FeedResolver) *Service {
+	s.feeds = res
+	return s
+}
+
+// SyncRule auto-downloads new uploads of one channel.
+type SyncRule struct {
+	ChannelID string  `json:"channel_id"`
+	Channel   string  `json:"channel,omitempty"`
+	Profile   Profile `json:"profile"`
+	// MaxDuration skips longer uploads, in seconds; 0 means no limit.
+	MaxDuration float64 `json:"max_duration,omitempty"`
+	// KeepLast keeps this many synced videos of the channel; 0 keeps all.
+	KeepLast int `json:"keep_last,omitempty"`
+	// KeepDays drops synced videos older than this; 0 keeps them forever.
+	KeepDays int `json:"keep_days,omitempty"`
+	// Seen lists the uploads already queued, newest first, so pruned videos
+	// are not fetched again.
+	Seen []string `json:"seen,omitempty"`
+}
+
+// SyncConfig schedules subscription sync.
+type SyncConfig struct {
+	// Hour is the local hour the nightly run starts (0-23).
+	Hour int
+	// QuotaBytes bounds the space synced videos may take; 0 is unlimited.
+	QuotaBytes int64
+}
+
+// SyncStatus reports the scheduler state.
+type SyncStatus struct {
+	LastRun   time.Time
+	NextRun   time.Time
+	LastError string
+	Queued    int
+	// Bytes is the space synced videos take; QuotaBytes the configured limit.
+	Bytes      int64
+	QuotaBytes int64
+}
+
+// Syncer evaluates the sync rules against the channel feeds once a night,
+// queues new uploads in the download library and prunes synced videos by
+// the rules' retention and the storage quota.
+type Syncer struct {
+	svc       *Service
+	downloads *DownloadManager
+	path      string
+	cfg       SyncConfig
+
+	mu     sync.Mutex
+	rules  map[string]*SyncRule
+	status SyncStatus
+	// running guards against overlapping runs.
+	running bool
+	now     chan struct{}
+	done    chan struct{}
+}
+
+// EnableSync starts the subscription sync scheduler. Downloads and a feed
+// resolver must be configured first.
+func (s *Service) EnableSync(cfg SyncConfig) error {
+	if s.downloads == nil {
+		return errors.New("sync: downloads not enabled")
+	}
+	if s.feeds == nil {
+		return errors.New("sync: feed resolver not configured")
+	}
+	if cfg.Hour < 0 || cfg.Hour > 23 {
+		cfg.Hour = defaultSyncHour
+	}
+	y := &Syncer{
+		svc:       s,
+		downloads: s.downloads,
+		path:      filepath.Join(s.downloads.dir, syncRulesFile),
+		cfg:       cfg,
+		rules:     make(map[string]*SyncRule),
+		now:       make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	if err := y.load(); err != nil {
+		return err
+	}
+	y.status.QuotaBytes = cfg.QuotaBytes
+	y.status.NextRun = nextRun(time.Now(), cfg.Hour)
+	go y.loop()
+	s.sync = y
+	return nil
+}
+
+// Sync returns the subscription sync scheduler, nil when disabled.
+func (s *Service) Sync() *Syncer {
+	return s.sync
+}
+
+func (y *Syncer) load() error {
+	raw, err := os.ReadFile(y.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("sync rules: %w", err)
+	}
+	var rules []*SyncRule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return fmt.Errorf("sync rules: %w", err)
+	}
+	for _, r := range rules {
+		y.rules[r.ChannelID] = r
+	}
+	return nil
+}
+
+// saveLocked writes the rules; the caller holds y.mu.
+func (y *Syncer) saveLocked() {
+	rules := make([]*SyncRule, 0, len(y.rules))
+	for _, r := range y.rules {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ChannelID < rules[j].ChannelID })
+	raw, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := y.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		log.Printf("[sync] %v", err)
+		return
+	}
+	if err := os.Rename(tmp, y.path); err != nil {
+		log.Printf("[sync] %v", err)
+	}
+}
+
+// Close stops the scheduler.
+func (y *Syncer) Close() {
+	if y != nil {
+		safeClose(y.done)
+	}
+}
+
+// Rules returns the sync rules ordered by channel.
+func (y *Syncer) Rules() []SyncRule {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	out := make([]SyncRule, 0, len(y.rules))
+	for _, r := range y.rules {
+		rule := *r
+		rule.Seen = nil
+		out = append(out, rule)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Channel+out[i].ChannelID) < strings.ToLower(out[j].Channel+out[j].ChannelID)
+	})
+	return out
+}
+
+// SetRule adds or replaces the rule for rule.ChannelID, keeping what it has
+// already synced.
+func (y *Syncer) SetRule(rule SyncRule) error {
+	rule.ChannelID = strings.TrimSpace(rule.ChannelID)
+	if !validVideoID(rule.ChannelID) {
+		return fmt.Errorf("invalid channel id %q", rule.ChannelID)
+	}
+	if spec, ok := y.svc.profiles.Lookup(rule.Profile); !ok || !spec.HTTP {
+		return fmt.Errorf("unknown profile %q", rule.Profile)
+	}
+	if rule.MaxDuration < 0 || rule.KeepLast < 0 || rule.KeepDays < 0 {
+		return errors.New("limits must not be negative")
+	}
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	if old, ok := y.rules[rule.ChannelID]; ok {
+		rule.Seen = old.Seen
+		if rule.Channel == "" {
+			rule.Channel = old.Channel
+		}
+	}
+	y.rules[rule.ChannelID] = &rule
+	y.saveLocked()
+	return nil
+}
+
+// RemoveRule stops syncing channelID; videos already synced stay until
+// deleted from the library.
+func (y *Syncer) RemoveRule(channelID string) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	if _, ok := y.rules[channelID]; ok {
+		delete(y.rules, channelID)
+		y.saveLocked()
+	}
+}
+
+// Status reports the last and next run and the storage used.
+func (y *Syncer) Status() SyncStatus {
+	y.mu.Lock()
+	st := y.status
+	y.mu.Unlock()
+	st.Bytes = 0
+	for _, d := range y.downloads.List() {
+		if d.Auto {
+			st.Bytes += d.Bytes
+		}
+	}
+	return st
+}
+
+// Offline lists the synced videos that finished downloading, newest first.
+func (y *Syncer) Offline() []Download {
+	var out []Download
+	for _, d := range y.downloads.List() {
+		if d.Auto && d.Status == DownloadDone {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// RunNow starts a sync run without waiting for the night.
+func (y *Syncer) RunNow() {
+	select {
+	case y.now <- struct{}{}:
+	default:
+	}
+}
+
+func (y *Syncer) loop() {
+	ticker := time.NewTicker(syncCheckEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-y.done:
+			return
+		case <-y.now:
+			y.run()
+		case <-ticker.C:
+			y.mu.Lock()
+			due := !time.Now().Before(y.status.NextRun)
+			y.mu.Unlock()
+			if due {
+				y.run()
+			} else {
+				y.prune()
+			}
+		}
+	}
+}
+
+// run evaluates every rule once.
+func (y *Syncer) run() {
+	y.mu.Lock()
+	if y.running {
+		y.mu.Unlock()
+		return
+	}
+	y.running = true
+	rules := make([]SyncRule, 0, len(y.rules))
+	for _, r := range y.rules {
+		rules = append(rules, *r)
+	}
+	y.mu.Unlock()
+
+	y.prune()
+	var errs []string
+	queued := 0
+	for _, rule := range rules {
+		n, err := y.syncChannel(rule)
+		queued += n
+		if err != nil {
+			log.Printf("[sync] %s: %v", rule.ChannelID, err)
+			errs = append(errs, rule.ChannelID+": "+err.Error())
+		}
+	}
+	y.prune()
+
+	now := time.Now()
+	y.mu.Lock()
+	y.running = false
+	y.status.LastRun = now
+	y.status.NextRun = nextRun(now, y.cfg.Hour)
+	y.status.LastError = strings.Join(errs, "; ")
+	y.status.Queued = queued
+	y.mu.Unlock()
+	log.Printf("[sync] queued %d videos from %d channels", queued, len(rules))
+}
+
+// syncChannel queues the rule's unseen uploads that fit its limits.
+func (y *Syncer) syncChannel(rule SyncRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	entries, err := y.svc.feeds.ChannelUploads(ctx, rule.ChannelID)
+	if err != nil {
+		return 0, err
+	}
+	depth := syncFeedDepth
+	if rule.KeepLast > 0 {
+		depth = rule.KeepLast
+	}
+	seen := make(map[string]bool, len(rule.Seen))
+	for _, id := range rule.Seen {
+		seen[id] = true
+	}
+
+	var added []string
+	for _, e := range entries[:min(depth, len(entries))] {
+		if seen[e.VideoID] || e.Duration <= 0 {
+			continue
+		}
+		if rule.MaxDuration > 0 && e.Duration > rule.MaxDuration {
+			continue
+		}
+		_, err := y.downloads.Add(DownloadRequest{
+			VideoID:   e.VideoID,
+			Title:     e.Title,
+			Profile:   rule.Profile,
+			Duration:  e.Duration,
+			ChannelID: rule.ChannelID,
+			Auto:      true,
+		})
+		if err != nil {
+			return len(added), err
+		}
+		added = append(added, e.VideoID)
+	}
+
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	r, ok := y.rules[rule.ChannelID]
+	if !ok {
+		return len(added), nil
+	}
+	if name := channelName(entries); name != "" {
+		r.Channel = name
+	}
+	r.Seen = append(added, r.Seen...)
+	if len(r.Seen) > syncSeenLimit {
+		r.Seen = r.Seen[:syncSeenLimit]
+	}
+	y.saveLocked()
+	return len(added), nil
+}
+
+func channelName(entries []FeedEntry) string {
+	for _, e := range entries {
+		if e.Channel != "" {
+			return e.Channel
+		}
+	}
+	return ""
+}
+
+// prune deletes synced videos beyond their rule's retention, then the
+// oldest ones until the quota holds.
+func (y *Syncer) prune() {
+	y.mu.Lock()
+	rules := make(map[string]SyncRule, len(y.rules))
+	for id, r := range y.rules {
+		rules[id] = *r
+	}
+	y.mu.Unlock()
+
+	// List is newest first.
+	var kept []Download
+	perChannel := make(map[string]int)
+	now := time.Now()
+	for _, d := range y.downloads.List() {
+		if !d.Auto {
+			continue
+		}
+		rule, ok := rules[d.ChannelID]
+		perChannel[d.ChannelID]++
+		switch {
+		case ok && rule.KeepLast > 0 && perChannel[d.ChannelID] > rule.KeepLast:
+			y.drop(d, "keep last")
+		case ok && rule.KeepDays > 0 && now.Sub(d.Created) > time.Duration(rule.KeepDays)*24*time.Hour:
+			y.drop(d, "keep days")
+		default:
+			kept = append(kept, d)
+		}
+	}
+
+	if y.cfg.QuotaBytes <= 0 {
+		return
+	}
+	var used int64
+	for _, d := range kept {
+		used += d.Bytes
+	}
+	for i := len(kept) - 1; i >= 0 && used > y.cfg.QuotaBytes; i-- {
+		used -= kept[i].Bytes
+		y.drop(kept[i], "quota")
+	}
+}
+
+func (y *Syncer) drop(d Download, reason string) {
+	if err := y.downloads.Delete(d.ID); err == nil {
+		log.Printf("[sync] pruned %s (%s)", d.ID, reason)
+	}
+}
+
+// nextRun is the next time the local clock shows hour:00 after now.
+func nextRun(now time.Time, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,138 @@
+package transcode
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// maxWorkerSpecBytes bounds the job description a worker accepts.
+const maxWorkerSpecBytes = 1 << 20
+
+// WorkerHandler serves this Service as a transcode worker for a
+// RemoteBackend: POST /transcode runs a TranscodeSpec and streams the output,
+// GET /health reports the load. Jobs name a profile of this worker's own
+// registry and may only read http(s) sources. Both require the shared token. The
+// scheduler limits how many jobs the worker takes; a worker without a free
+// slot answers 503 at once instead of queueing, so the dispatching node can
+// try the next worker or run the job itself.
+func (s *Service) WorkerHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(workerHealthPath, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(WorkerHealth{Active: s.jobs.stats().Active, Capacity: s.scheduler.Capacity()})
+	})
+	mux.HandleFunc(workerTranscodePath, s.serveWorkerJob)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(workerTokenHeader)), []byte(token)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Service) serveWorkerJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	var spec TranscodeSpec
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWorkerSpecBytes)).Decode(&spec); err != nil {
+		http.Error(w, "bad job: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	profile, ok := s.profiles.Lookup(spec.Profile)
+	if !ok || !profile.HTTP {
+		http.Error(w, fmt.Sprintf("unknown profile %q", spec.Profile), http.StatusBadRequest)
+		return
+	}
+	if err := spec.Source.validRemote(); err != nil || spec.Start < 0 {
+		http.Error(w, fmt.Sprintf("bad job for profile %q", spec.Profile), http.StatusBadRequest)
+		return
+	}
+
+	ctx := WithClient(r.Context(), r.RemoteAddr)
+	format := profile.Format()
+	w.Header().Set("Trailer", workerErrorTrailer)
+	out := &lazyWriter{w: w, header: func(h http.Header) {
+		h.Set("Content-Type", format.ContentType)
+	}}
+	err := s.runWorkerJob(ctx, spec, profile, out)
+	switch {
+	case err == nil:
+		if !out.started {
+			w.WriteHeader(http.StatusOK)
+		}
+		return
+	case out.started:
+		log.Printf("[worker] id=%s profile=%s: %v", spec.VideoID, spec.Profile, err)
+		w.Header().Set(workerErrorTrailer, err.Error())
+		return
+	}
+	var busy *BusyError
+	if errors.As(err, &busy) {
+		w.Header().Set("Retry-After", busy.RetryAfterSeconds())
+		http.Error(w, "worker busy", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
+
+// runWorkerJob transcodes spec with profile into out on this machine's ffmpeg.
+func (s *Service) runWorkerJob(ctx context.Context, spec TranscodeSpec, profile ProfileSpec, out *lazyWriter) error {
+	release, err := s.scheduler.TryAcquire(profile.Name, clientFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	input, cleanup, err := s.buildInput(spec.Source, spec.Start)
+	if err != nil {
+		return err
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	args := httpArgs(profile, input, s.retroFilter)
+	proc, err := LocalBackend{Command: s.command}.Start(ctx, Command{Args: args, Stdin: input.pipe, Stdout: out})
+	if err != nil {
+		return err
+	}
+	if stdin := proc.Stdin(); stdin != nil {
+		s.startInputPump(ctx, stdin, input.srcURL)
+	}
+	job := s.jobs.add(JobHTTP, spec.VideoID, profile.Name, clientFromContext(ctx), spec.Start)
+	go logFFmpeg(proc.Stderr(), "[ffmpeg worker]", job)
+
+	err = proc.Wait()
+	stopped := ctx.Err() != nil
+	s.jobs.done(job, err != nil && !stopped)
+	if err != nil && !stopped {
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	return nil
+}
+
+// validRemote accepts only http(s) URLs, so a job cannot point ffmpeg at
+// the worker's files or at protocols such as concat: or subfile:.
+func (src Source) validRemote() error {
+	urls := []string{src.URL}
+	if src.AudioURL != "" {
+		urls = append(urls, src.AudioURL)
+	}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("source %q is not an http(s) URL", raw)
+		}
+	}
+	return nil
+}
@@ -26,6 +26,8 @@ type PageData struct {
 	IsSubscribed   bool
 	SubscribeURL   string
 	UnsubscribeURL string
+	// PodcastURL is the channel's audio RSS feed.
+	PodcastURL string
 }
 
 // Tab describes a row of items.
@@ -47,7 +49,7 @@ func Render(data PageData) string {
 	b.WriteString(`<main class="page channel-page">`)
 	b.WriteString(`<div class="box">`)
 	if data.AvatarURL != "" {
-		fmt.Fprintf(&b, `<img src="%s" width="64" height="64" style="border-radius:50%%" alt=""> `, ui.EscapeAttr(ui.ProxiedImage(data.AvatarURL)))
+		fmt.Fprintf(&b, `<img src="%s" width="64" height="64" style="border-radius:50%%" alt=""> `, ui.EscapeAttr(ui.ProxiedImageSized(data.AvatarURL, 64, 64)))
 	}
 	fmt.Fprintf(&b, `<b>%s</b>`, ui.Escape(data.Title))
 	if data.Subscribers != "" {
@@ -63,6 +65,9 @@ func Render(data PageData) string {
 	} else if data.SubscribeURL != "" {
 		fmt.Fprintf(&b, `<p><a class="btn" href="%s">Subscribe</a></p>`, ui.EscapeAttr(data.SubscribeURL))
 	}
+	if data.PodcastURL != "" {
+		fmt.Fprintf(&b, `<p><a href="%s">Podcast feed (RSS)</a></p>`, ui.EscapeAttr(data.PodcastURL))
+	}
 	b.WriteString(`</div>`)
 
 	selected := data.SelectedTab
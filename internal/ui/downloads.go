@@ -0,0 +1,93 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DownloadsPageData feeds RenderDownloadsPage.
+type DownloadsPageData struct {
+	Theme       string
+	CurrentPath string
+	Entries     []DownloadEntry
+	// Refresh reloads the page every that many seconds while jobs run.
+	Refresh int
+	// Disabled explains why the library is unavailable.
+	Disabled string
+}
+
+// DownloadEntry is one row of the downloads page.
+type DownloadEntry struct {
+	Title    string
+	WatchURL string
+	Format   string
+	Status   string
+	// Progress is a short human readable state, e.g. "42% (3.1 MB)".
+	Progress string
+	Error    string
+	FileURL  string
+	// RetryURL and DeleteURL are posted to, not followed.
+	RetryURL  string
+	DeleteURL string
+}
+
+// RenderDownloadsPage lists the download library with progress and actions.
+func RenderDownloadsPage(data DownloadsPageData) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html><html><head><meta charset="utf-8">
+<title>Downloads - YouTube Mini</title>
+<link rel="stylesheet" href="/style.css">`)
+	if data.Refresh > 0 {
+		fmt.Fprintf(&b, `<meta http-equiv="refresh" content="%d">`, data.Refresh)
+	}
+	fmt.Fprintf(&b, `</head><body class="%s">`, ThemeBodyClass(data.Theme))
+	b.WriteString(RenderHeader("downloads", data.Theme, data.CurrentPath, ""))
+	b.WriteString(`<main class="page">`)
+	b.WriteString(`<div class="box"><b>Downloads</b> | <a href="/offline">Offline</a></div>`)
+
+	switch {
+	case data.Disabled != "":
+		fmt.Fprintf(&b, `<p class="empty">%s</p>`, Escape(data.Disabled))
+	case len(data.Entries) == 0:
+		b.WriteString(`<p class="empty">Nothing downloaded yet. Use the Download links on a video page.</p>`)
+	}
+
+	for _, e := range data.Entries {
+		b.WriteString(`<div class="box">`)
+		fmt.Fprintf(&b, `<a href="%s"><b>%s</b></a><br>`, EscapeAttr(e.WatchURL), Escape(e.Title))
+		fmt.Fprintf(&b, `%s &#183; %s`, Escape(e.Format), Escape(e.Status))
+		if e.Progress != "" {
+			fmt.Fprintf(&b, ` &#183; %s`, Escape(e.Progress))
+		}
+		if e.Error != "" {
+			fmt.Fprintf(&b, `<br><small>%s</small>`, Escape(e.Error))
+		}
+		actions := make([]string, 0, 3)
+		if e.FileURL != "" {
+			actions = append(actions, fmt.Sprintf(`<a href="%s">Save file</a>`, EscapeAttr(e.FileURL)))
+		}
+		if e.RetryURL != "" {
+			actions = append(actions, postButton(e.RetryURL, "Retry", "link-button"))
+		}
+		if e.DeleteURL != "" {
+			actions = append(actions, postButton(e.DeleteURL, "Delete", "link-button"))
+		}
+		if len(actions) > 0 {
+			b.WriteString(`<br>` + strings.Join(actions, " | "))
+		}
+		b.WriteString(`</div>`)
+	}
+
+	b.WriteString(`<hr><div class="footer-link"><a href="/features">Feature roadmap</a></div>`)
+	b.WriteString(`</main>`)
+	AppendSuggestionScript(&b)
+	b.WriteString(`</body></html>`)
+	return b.String()
+}
+
+// postButton renders a one-button form that posts to action, for links
+// that change state.
+func postButton(action, label, class string) string {
+	return fmt.Sprintf(`<form class="inline-form" action="%s" method="post"><input class="%s" type="submit" value="%s"></form>`,
+		EscapeAttr(action), EscapeAttr(class), EscapeAttr(label))
+}
@@ -17,6 +17,8 @@ type FeedItemsOptions struct {
 	ShowQueue      bool
 	ShowWatchLater bool
 	ShowSubscribe  bool
+	// Links replaces the watch page link of the cards with these video ids.
+	Links map[string]string
 }
 
 type feedAction struct {
@@ -38,6 +40,9 @@ func RenderFeedItems(opts FeedItemsOptions) string {
 	sb := &strings.Builder{}
 	for _, it := range opts.Items {
 		watchURL := "/watch?v=" + url.QueryEscape(it.ID)
+		if link, ok := opts.Links[it.ID]; ok {
+			watchURL = link
+		}
 		channelURL := ""
 		if it.ChannelID != "" {
 			channelURL = "/channel?id=" + url.QueryEscape(it.ChannelID)
@@ -79,7 +84,7 @@ func RenderFeedItems(opts FeedItemsOptions) string {
 
 		sb.WriteString(`<article class="feed-card">`)
 		fmt.Fprintf(sb, `<a class="feed-thumb" href="%s">`, EscapeAttr(watchURL))
-		fmt.Fprintf(sb, `<img src="%s" width="168" height="94" alt="">`, EscapeAttr(ProxiedImage(it.Thumbnail)))
+		fmt.Fprintf(sb, `<img src="%s" width="168" height="94" alt="">`, EscapeAttr(ProxiedImageSized(it.Thumbnail, 168, 94)))
 		if it.Duration != "" {
 			fmt.Fprintf(sb, `<span class="badge">%s</span>`, Escape(it.Duration))
 		}
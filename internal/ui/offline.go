@@ -0,0 +1,117 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"youtube-mini/internal/youtube"
+)
+
+// OfflinePageData feeds RenderOfflinePage.
+type OfflinePageData struct {
+	Theme       string
+	CurrentPath string
+	// Items are the synced videos; Links points each at its local file.
+	Items []youtube.FeedItem
+	Links map[string]string
+	// Status summarises the last and next sync and the storage used.
+	Status     string
+	SyncNowURL string
+	Rules      []SyncRuleRow
+	// Channels and Profiles fill the new rule form.
+	Channels []SyncOption
+	Profiles []SyncOption
+	// Disabled explains why sync is unavailable.
+	Disabled string
+}
+
+// SyncRuleRow is one auto-download rule.
+type SyncRuleRow struct {
+	Channel    string
+	ChannelURL string
+	// Summary reads like "Retro, up to 20 min, keep last 5".
+	Summary   string
+	DeleteURL string
+}
+
+// SyncOption is one choice of a select box.
+type SyncOption struct {
+	Value string
+	Label string
+}
+
+// RenderOfflinePage lists the videos synced for offline viewing and the
+// rules that fetch them.
+func RenderOfflinePage(data OfflinePageData) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<!DOCTYPE html><html><head><meta charset="utf-8">
+<title>Offline - YouTube Mini</title>
+<link rel="stylesheet" href="/style.css">
+</head><body class="%s">`, ThemeBodyClass(data.Theme))
+	b.WriteString(RenderHeader("offline", data.Theme, data.CurrentPath, ""))
+	b.WriteString(`<main class="page">`)
+	b.WriteString(`<div class="box"><b>Offline</b>`)
+	if data.Status != "" {
+		fmt.Fprintf(&b, `<br><small>%s</small>`, Escape(data.Status))
+	}
+	if data.SyncNowURL != "" {
+		fmt.Fprintf(&b, `<br>%s | <a href="/downloads">Downloads</a>`, postButton(data.SyncNowURL, "Sync now", "link-button"))
+	}
+	b.WriteString(`</div>`)
+
+	if data.Disabled != "" {
+		fmt.Fprintf(&b, `<p class="empty">%s</p>`, Escape(data.Disabled))
+	} else {
+		items := RenderFeedItems(FeedItemsOptions{
+			Items:       data.Items,
+			CurrentPath: data.CurrentPath,
+			Links:       data.Links,
+		})
+		if items == "" {
+			b.WriteString(`<p class="empty">Nothing synced yet. Add a rule below and new uploads are fetched overnight.</p>`)
+		} else {
+			b.WriteString(items)
+		}
+		renderSyncRules(&b, data)
+	}
+
+	b.WriteString(`<hr><div class="footer-link"><a href="/features">Feature roadmap</a></div>`)
+	b.WriteString(`</main>`)
+	AppendSuggestionScript(&b)
+	b.WriteString(`</body></html>`)
+	return b.String()
+}
+
+func renderSyncRules(b *strings.Builder, data OfflinePageData) {
+	b.WriteString(`<div class="box"><b>Auto-download rules</b>`)
+	if len(data.Rules) == 0 {
+		b.WriteString(`<br><small>No channels are synced.</small>`)
+	}
+	for _, rule := range data.Rules {
+		fmt.Fprintf(b, `<br><a href="%s">%s</a> &#183; %s &#183; %s`,
+			EscapeAttr(rule.ChannelURL), Escape(rule.Channel), Escape(rule.Summary), postButton(rule.DeleteURL, "Remove", "link-button"))
+	}
+	b.WriteString(`</div>`)
+
+	if len(data.Channels) == 0 {
+		b.WriteString(`<p class="empty">Subscribe to channels to sync their uploads.</p>`)
+		return
+	}
+	b.WriteString(`<form class="box" action="/offline/rules/save" method="post">`)
+	b.WriteString(`<b>Sync a channel</b><br>`)
+	writeSelect(b, "Channel", "channel", data.Channels)
+	writeSelect(b, "Profile", "profile", data.Profiles)
+	b.WriteString(`<label>Max length (min) <input type="text" name="max" size="4"></label><br>`)
+	b.WriteString(`<label>Keep last <input type="text" name="keep" size="4" value="5"></label><br>`)
+	b.WriteString(`<label>Keep days <input type="text" name="days" size="4"></label><br>`)
+	b.WriteString(`<button type="submit">Save rule</button>`)
+	b.WriteString(`</form>`)
+}
+
+func writeSelect(b *strings.Builder, label, name string, options []SyncOption) {
+	fmt.Fprintf(b, `<label>%s <select name="%s">`, Escape(label), EscapeAttr(name))
+	for _, opt := range options {
+		fmt.Fprintf(b, `<option value="%s">%s</option>`, EscapeAttr(opt.Value), Escape(opt.Label))
+	}
+	b.WriteString(`</select></label><br>`)
+}
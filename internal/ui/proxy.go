@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"fmt"
 	"net/url"
 	"strings"
 )
@@ -19,3 +20,13 @@ func ProxiedImage(raw string) string {
 	}
 	return raw
 }
+
+// ProxiedImageSized is ProxiedImage with the proxy scaling the picture to
+// width x height, so small thumbnails do not cost a full-size download.
+func ProxiedImageSized(raw string, width, height int) string {
+	proxied := ProxiedImage(raw)
+	if !strings.HasPrefix(proxied, "/proxy?") || strings.Contains(proxied, "&w=") {
+		return proxied
+	}
+	return proxied + fmt.Sprintf("&w=%d&h=%d", width, height)
+}
@@ -0,0 +1,78 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SlideshowPageData feeds RenderSlideshowPage.
+type SlideshowPageData struct {
+	Title    string
+	FrameURL string
+	Width    int
+	Page     int
+	Pages    int
+	// Seconds is the offset of the shown frame into the video.
+	Seconds    int
+	PrevURL    string
+	NextURL    string
+	AutoURL    string
+	StopURL    string
+	Refresh    int
+	WatchURL   string
+	AudioLinks []Link
+}
+
+// RenderSlideshowPage renders one frame of a paged slideshow as XHTML Mobile
+// Profile markup, so WAP browsers without a media player can step through it.
+func RenderSlideshowPage(data SlideshowPageData) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE html PUBLIC "-//WAPFORUM//DTD XHTML Mobile 1.0//EN" "http://www.wapforum.org/DTD/xhtml-mobile10.dtd">` + "\n")
+	b.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml"><head>`)
+	fmt.Fprintf(&b, `<title>%s - YouTube Mini</title>`, Escape(data.Title))
+	if data.Refresh > 0 && data.NextURL != "" {
+		fmt.Fprintf(&b, `<meta http-equiv="refresh" content="%d;url=%s"/>`, data.Refresh, EscapeAttr(data.NextURL))
+	}
+	b.WriteString(`</head><body>`)
+
+	stamp := "0:00"
+	if data.Seconds > 0 {
+		stamp = formatVideoDuration(strconv.Itoa(data.Seconds))
+	}
+	fmt.Fprintf(&b, `<p><b>%s</b><br/>%d / %d &#183; %s</p>`, Escape(data.Title), data.Page, data.Pages, Escape(stamp))
+	fmt.Fprintf(&b, `<p><img src="%s" width="%d" alt="Frame %d"/></p>`, EscapeAttr(data.FrameURL), data.Width, data.Page)
+
+	nav := make([]string, 0, 4)
+	if data.PrevURL != "" {
+		nav = append(nav, fmt.Sprintf(`<a href="%s" accesskey="4">&lt; Prev</a>`, EscapeAttr(data.PrevURL)))
+	}
+	if data.NextURL != "" {
+		nav = append(nav, fmt.Sprintf(`<a href="%s" accesskey="6">Next &gt;</a>`, EscapeAttr(data.NextURL)))
+	}
+	if data.Refresh > 0 && data.StopURL != "" {
+		nav = append(nav, fmt.Sprintf(`<a href="%s">Stop</a>`, EscapeAttr(data.StopURL)))
+	} else if data.AutoURL != "" {
+		nav = append(nav, fmt.Sprintf(`<a href="%s">Auto</a>`, EscapeAttr(data.AutoURL)))
+	}
+	if len(nav) > 0 {
+		b.WriteString(`<p>` + strings.Join(nav, " | ") + `</p>`)
+	}
+
+	if len(data.AudioLinks) > 0 {
+		b.WriteString(`<p>Audio from here: `)
+		for i, link := range data.AudioLinks {
+			if i > 0 {
+				b.WriteString(" | ")
+			}
+			fmt.Fprintf(&b, `<a href="%s">%s</a>`, EscapeAttr(link.URL), Escape(link.Label))
+		}
+		b.WriteString(`</p>`)
+	}
+	if data.WatchURL != "" {
+		fmt.Fprintf(&b, `<p><a href="%s">Back to video</a></p>`, EscapeAttr(data.WatchURL))
+	}
+	b.WriteString(`</body></html>`)
+	return b.String()
+}
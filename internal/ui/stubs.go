@@ -1,6 +1,9 @@
 package ui
 
-import "fmt"
+import (
+	"fmt"
+	"net/url"
+)
 
 // RenderChannel placeholder content for channel view.
 func RenderChannel(id string) string {
@@ -22,8 +25,9 @@ func RenderPlaylist(id string) string {
 </head><body><div class="head"><a href="/">Back</a> Playlists</div>
 <div class="box"><h3>Playlist %s</h3>
 <p>The playlist view is under construction. Upcoming: ordered videos, autoplay and Watch Later shortcuts.</p>
+<p><a href="/feed/podcast?list=%s">Podcast feed (RSS)</a></p>
 </div>
-<hr><center><a href="/">Home</a></center></body></html>`, Escape(id), Escape(id))
+<hr><center><a href="/">Home</a></center></body></html>`, Escape(id), Escape(id), EscapeAttr(url.QueryEscape(id)))
 }
 
 // RenderSubscriptions placeholder content for subscriptions feed.
@@ -32,6 +32,10 @@ body.theme-dark .tabs a{background:#202020;color:#aaa;}
 body.theme-dark .tabs a.on{background:#ff4e45;}
 .page{width:94%;margin:0 auto;padding:16px 0 48px;}
 .footer-link{text-align:center;margin:24px 0;font-weight:bold;}
+.inline-form{display:inline;margin:0;}
+.link-button{background:none;border:0;padding:0;margin:0;color:#c00;font:inherit;cursor:pointer;}
+body.theme-dark .link-button{color:#ff4e45;}
+.link-button:hover{text-decoration:underline;}
 .footer-link a{color:#c00;}
 body.theme-dark .footer-link a{color:#ff4e45;}
 .box{background:#fff;margin:12px 0;padding:14px;-webkit-border-radius:8px;border-radius:8px;-webkit-box-shadow:0 1px 2px rgba(0,0,0,0.08);box-shadow:0 1px 2px rgba(0,0,0,0.08);}
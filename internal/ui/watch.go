@@ -17,6 +17,12 @@ type WatchPageData struct {
 	StreamURL         string
 	AudioURL          string
 	TranscodeLinks    []Link
+	AudioLinks        []Link
+	SubtitleLinks     []Link
+	RemuxLinks        []Link
+	DownloadLinks     []Link
+	DeviceLink        Link
+	Jumps             []JumpEntry
 	Captions          []youtube.CaptionTrack
 	AutoplayEnabled   bool
 	AutoplayToggleURL string
@@ -38,6 +44,15 @@ type Link struct {
 	URL   string
 }
 
+// JumpEntry is one storyboard thumbnail in the watch page's "Jump to" grid.
+type JumpEntry struct {
+	Seconds  int
+	URL      string
+	ThumbURL string
+	Width    int
+	Height   int
+}
+
 // RelatedEntry wraps a feed item with queue action.
 type RelatedEntry struct {
 	Item          youtube.FeedItem
@@ -243,6 +258,15 @@ func RenderWatch(data WatchPageData) string {
  border:1px solid var(--ym-divider);
  color:var(--ym-muted);
 }
+input.ym-chip{
+ background:none;
+ font-family:inherit;
+ cursor:pointer;
+}
+.inline-form{
+ display:inline;
+ margin:0;
+}
 .ym-meta-panel{
  padding:18px;
  font-size:14px;
@@ -510,8 +534,11 @@ func RenderWatch(data WatchPageData) string {
 		b.WriteString(`</div>`)
 	}
 
-	if watchLaterLabel != "" || data.AudioURL != "" || len(liteActions) > 0 {
+	if watchLaterLabel != "" || data.AudioURL != "" || len(liteActions) > 0 || data.DeviceLink.URL != "" {
 		b.WriteString(`<div class="ym-action-row">`)
+		if data.DeviceLink.URL != "" {
+			fmt.Fprintf(&b, `<a class="ym-button" id="ym-device-link" href="%s">Play on this phone (%s)</a>`, EscapeAttr(data.DeviceLink.URL), Escape(data.DeviceLink.Label))
+		}
 		if watchLaterLabel != "" {
 			fmt.Fprintf(&b, `<a class="ym-button ym-button-ghost" id="ym-watchlater-link" href="%s">%s</a>`, Escape(data.WatchLaterURL), Escape(watchLaterLabel))
 		}
@@ -547,9 +574,52 @@ func RenderWatch(data WatchPageData) string {
 		b.WriteString(`</div>`)
 	}
 
+	if len(data.AudioLinks) > 0 {
+		b.WriteString(`<div class="ym-quick-links">`)
+		b.WriteString(`<span class="ym-quick-links__label">Audio formats</span>`)
+		for _, link := range data.AudioLinks {
+			if link.URL == "" {
+				continue
+			}
+			fmt.Fprintf(&b, `<a class="ym-chip" href="%s">%s</a>`, Escape(link.URL), Escape(link.Label))
+		}
+		b.WriteString(`</div>`)
+	}
+
+	if len(data.RemuxLinks) > 0 {
+		b.WriteString(`<div class="ym-quick-links">`)
+		b.WriteString(`<span class="ym-quick-links__label">MP4 qualities</span>`)
+		for _, link := range data.RemuxLinks {
+			fmt.Fprintf(&b, `<a class="ym-chip" href="%s">%s</a>`, Escape(link.URL), Escape(link.Label))
+		}
+		b.WriteString(`</div>`)
+	}
+
+	if len(data.DownloadLinks) > 0 {
+		b.WriteString(`<div class="ym-quick-links">`)
+		b.WriteString(`<span class="ym-quick-links__label">Download</span>`)
+		for _, link := range data.DownloadLinks {
+			b.WriteString(postButton(link.URL, link.Label, "ym-chip"))
+		}
+		b.WriteString(`</div>`)
+	}
+
+	if len(data.SubtitleLinks) > 0 {
+		b.WriteString(`<div class="ym-quick-links">`)
+		b.WriteString(`<span class="ym-quick-links__label">With subtitles</span>`)
+		for _, link := range data.SubtitleLinks {
+			fmt.Fprintf(&b, `<a class="ym-chip" href="%s">%s</a>`, Escape(link.URL), Escape(link.Label))
+		}
+		b.WriteString(`</div>`)
+	}
+
 	b.WriteString(`</div>`)
 	b.WriteString(`</div><hr>`)
 
+	if len(data.Jumps) > 0 {
+		b.WriteString(writeJumpGrid(data.Jumps))
+	}
+
 	if data.AutoplayToggleURL != "" {
 		if data.AutoplayEnabled {
 			message := "Autoplay is ON"
@@ -578,7 +648,7 @@ func RenderWatch(data WatchPageData) string {
 <small><a href="%s">remove</a></small></td>
 </tr></table>
 </div>`,
-				Escape(item.ID), EscapeAttr(ProxiedImage(item.Thumbnail)),
+				Escape(item.ID), EscapeAttr(ProxiedImageSized(item.Thumbnail, 96, 54)),
 				Escape(item.ID), Escape(item.Title),
 				Escape(item.Meta), Escape(entry.RemoveURL))
 		}
@@ -611,7 +681,7 @@ func RenderWatch(data WatchPageData) string {
 </td>
 </tr></table>
 </div>`,
-				Escape(item.ID), EscapeAttr(ProxiedImage(item.Thumbnail)), Escape(item.Duration),
+				Escape(item.ID), EscapeAttr(ProxiedImageSized(item.Thumbnail, 96, 54)), Escape(item.Duration),
 				Escape(item.ID), Escape(item.Title),
 				Escape(channelID), Escape(item.Channel),
 				Escape(item.Meta), Escape(entry.AddToQueueURL))
@@ -917,6 +987,30 @@ func formatBytes(raw string) string {
 	return fmt.Sprintf("%.1f %s", value, units[idx])
 }
 
+// writeJumpGrid lays the storyboard frames out in a plain table, four per row,
+// so browsers without JS can still pick a start position.
+func writeJumpGrid(jumps []JumpEntry) string {
+	const perRow = 4
+	var b strings.Builder
+	b.WriteString(`<div class="box"><b>Jump to</b><br><table cellspacing="0" cellpadding="2">`)
+	for i, jump := range jumps {
+		if i%perRow == 0 {
+			b.WriteString(`<tr valign="top">`)
+		}
+		label := "0:00"
+		if jump.Seconds > 0 {
+			label = formatVideoDuration(strconv.Itoa(jump.Seconds))
+		}
+		fmt.Fprintf(&b, `<td align="center"><a href="%s"><img src="%s" width="%d" height="%d" alt="%s"><br><small>%s</small></a></td>`,
+			EscapeAttr(jump.URL), EscapeAttr(jump.ThumbURL), jump.Width, jump.Height, EscapeAttr(label), Escape(label))
+		if i%perRow == perRow-1 || i == len(jumps)-1 {
+			b.WriteString(`</tr>`)
+		}
+	}
+	b.WriteString(`</table></div>`)
+	return b.String()
+}
+
 func formatVideoDuration(length string) string {
 	length = strings.TrimSpace(length)
 	if length == "" {
@@ -0,0 +1,102 @@
+package youtube
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// videoOnly reports whether f is an adaptive rendition without sound; those
+// list a single codec, progressive ones list video and audio.
+func (f Format) videoOnly() bool {
+	mime := strings.ToLower(f.Mime)
+	_, codecs, _ := strings.Cut(mime, ";")
+	return strings.HasPrefix(mime, "video/") && !strings.Contains(codecs, ",")
+}
+
+// container is the mime type without parameters, e.g. "video/mp4".
+func (f Format) container() string {
+	mime, _, _ := strings.Cut(strings.ToLower(f.Mime), ";")
+	return strings.TrimSpace(mime)
+}
+
+// codecRank orders video codecs by how widely they play: H.264 first.
+func (f Format) codecRank() int {
+	mime := strings.ToLower(f.Mime)
+	switch {
+	case strings.Contains(mime, "avc1"):
+		return 0
+	case strings.Contains(mime, "vp9"), strings.Contains(mime, "vp09"):
+		return 1
+	}
+	return 2
+}
+
+// RemuxQualities lists the adaptive video-only formats that can be remuxed
+// with an audio track, one per quality label (H.264 preferred), smallest
+// first.
+func (v Video) RemuxQualities() []Format {
+	if len(v.Audio) == 0 {
+		return nil
+	}
+	best := make(map[string]Format)
+	for _, f := range v.Formats {
+		if f.URL == "" || f.Quality == "" || !f.videoOnly() {
+			continue
+		}
+		if cur, ok := best[f.Quality]; !ok || f.codecRank() < cur.codecRank() {
+			best[f.Quality] = f
+		}
+	}
+	out := make([]Format, 0, len(best))
+	for _, f := range best {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Height != out[j].Height {
+			return out[i].Height < out[j].Height
+		}
+		return out[i].Quality < out[j].Quality
+	})
+	return out
+}
+
+// RemuxPair returns the adaptive video format with itag and the audio track
+// that goes best with it: the highest bitrate in the same container family
+// (AAC for MP4, Opus for WebM), or the highest bitrate overall.
+func (v Video) RemuxPair(itag string) (video, audio Format, ok bool) {
+	for _, f := range v.Formats {
+		if f.Itag == itag && f.URL != "" && f.videoOnly() {
+			video, ok = f, true
+			break
+		}
+	}
+	if !ok {
+		return Format{}, Format{}, false
+	}
+	family := strings.TrimPrefix(video.container(), "video/")
+	var bestAny, bestFamily Format
+	for _, a := range v.Audio {
+		if a.URL == "" {
+			continue
+		}
+		if bitrateOf(a) > bitrateOf(bestAny) || bestAny.URL == "" {
+			bestAny = a
+		}
+		if strings.TrimPrefix(a.container(), "audio/") == family && (bitrateOf(a) > bitrateOf(bestFamily) || bestFamily.URL == "") {
+			bestFamily = a
+		}
+	}
+	switch {
+	case bestFamily.URL != "":
+		return video, bestFamily, true
+	case bestAny.URL != "":
+		return video, bestAny, true
+	}
+	return Format{}, Format{}, false
+}
+
+func bitrateOf(f Format) int {
+	n, _ := strconv.Atoi(f.Bitrate)
+	return n
+}
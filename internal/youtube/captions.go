@@ -0,0 +1,64 @@
+package youtube
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Caption returns the track for lang. Exact codes win over regional variants
+// ("en" also matches "en-GB") and manual tracks over auto-generated ones.
+func (v Video) Caption(lang string) (CaptionTrack, bool) {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return CaptionTrack{}, false
+	}
+	best, bestScore := CaptionTrack{}, 0
+	for _, track := range v.Captions {
+		code := strings.ToLower(track.Code)
+		score := 0
+		switch {
+		case code == lang:
+			score = 4
+		case strings.HasPrefix(code, lang+"-"):
+			score = 2
+		default:
+			continue
+		}
+		if track.Kind != "asr" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = track, score
+		}
+	}
+	return best, bestScore > 0
+}
+
+// CaptionURL returns the timedtext URL (srv3 XML) for lang, translated to
+// tlang when set. A language without its own track is machine-translated
+// from the first translatable track.
+func (v Video) CaptionURL(lang, tlang string) (string, bool) {
+	track, ok := v.Caption(lang)
+	if !ok {
+		for _, t := range v.Captions {
+			if t.Translatable {
+				track, ok, tlang = t, true, lang
+				break
+			}
+		}
+	}
+	if !ok {
+		return "", false
+	}
+	u, err := url.Parse(track.URL)
+	if err != nil {
+		return "", false
+	}
+	q := u.Query()
+	q.Set("fmt", "srv3")
+	if tlang = strings.TrimSpace(tlang); tlang != "" && !strings.EqualFold(tlang, track.Code) {
+		q.Set("tlang", tlang)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), true
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -163,6 +164,7 @@ func (c *Client) GetVideo(ctx context.Context, id string) (Video, error) {
 			LengthSeconds string `json:"lengthSeconds"`
 			ViewCount     string `json:"viewCount"`
 			ChannelID     string `json:"channelId"`
+			Description   string `json:"shortDescription"`
 		} `json:"videoDetails"`
 		StreamingData map[string]any `json:"streamingData"`
 	}
@@ -182,6 +184,9 @@ func (c *Client) GetVideo(ctx context.Context, id string) (Video, error) {
 		stream = videoFormats[0].URL
 	}
 	hlsManifest := fmt.Sprint(decoded.StreamingData["hlsManifestUrl"])
+	lengthSeconds, _ := strconv.Atoi(decoded.VideoDetails.LengthSeconds)
+	spec, _ := dig(generic, "storyboards", "playerStoryboardSpecRenderer", "spec").(string)
+	published, _ := dig(generic, "microformat", "playerMicroformatRenderer", "publishDate").(string)
 
 	video := Video{
 		ID:            id,
@@ -190,12 +195,15 @@ func (c *Client) GetVideo(ctx context.Context, id string) (Video, error) {
 		LengthSeconds: decoded.VideoDetails.LengthSeconds,
 		ViewCount:     decoded.VideoDetails.ViewCount,
 		ChannelID:     decoded.VideoDetails.ChannelID,
+		Description:   decoded.VideoDetails.Description,
+		Published:     published,
 		Formats:       videoFormats,
 		Audio:         audioFormats,
 		Captions:      captions,
 		Stream:        stream,
 		ThumbURL:      fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", id),
 		HLSManifest:   hlsManifest,
+		Storyboards:   parseStoryboardSpec(spec, lengthSeconds),
 	}
 	c.videoCache.Set(id, video, videoCacheTTL)
 	return video, nil
@@ -285,9 +293,18 @@ func buildFormat(raw any) Format {
 		Quality:       fmt.Sprint(data["qualityLabel"]),
 		Bitrate:       fmt.Sprint(data["bitrate"]),
 		ContentLength: fmt.Sprint(data["contentLength"]),
+		Width:         intField(data["width"]),
+		Height:        intField(data["height"]),
 	}
 }
 
+func intField(v any) int {
+	if f, ok := v.(float64); ok {
+		return int(f)
+	}
+	return 0
+}
+
 func extractCaptions(obj map[string]any) []CaptionTrack {
 	tracks := []CaptionTrack{}
 	if obj == nil {
@@ -311,10 +328,14 @@ func extractCaptions(obj map[string]any) []CaptionTrack {
 			label = fmt.Sprint(m["languageCode"])
 		}
 		kind := fmt.Sprint(m["kind"])
+		code, _ := m["languageCode"].(string)
+		translatable, _ := m["isTranslatable"].(bool)
 		tracks = append(tracks, CaptionTrack{
-			Language: label,
-			URL:      url,
-			Kind:     kind,
+			Language:     label,
+			Code:         code,
+			URL:          url,
+			Kind:         kind,
+			Translatable: translatable,
 		})
 	}
 	return tracks
@@ -0,0 +1,77 @@
+package youtube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Playlist returns a playlist's metadata and its videos in playlist order.
+func (c *Client) Playlist(ctx context.Context, listID string) (PlaylistInfo, []FeedItem, error) {
+	listID = strings.TrimSpace(listID)
+	if listID == "" {
+		return PlaylistInfo{}, nil, fmt.Errorf("playlist id required")
+	}
+
+	body := map[string]any{
+		"context": map[string]any{
+			"client": map[string]any{
+				"hl":            "en",
+				"gl":            "US",
+				"clientName":    "MWEB",
+				"clientVersion": "2.20251021.01.00",
+			},
+		},
+		"browseId": "VL" + listID,
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return PlaylistInfo{}, nil, err
+	}
+	url := fmt.Sprintf("https://www.youtube.com/youtubei/v1/browse?key=%s", c.apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return PlaylistInfo{}, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.searchAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return PlaylistInfo{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return PlaylistInfo{}, nil, fmt.Errorf("playlist browse failed: %s (%s)", resp.Status, string(bodyBytes))
+	}
+
+	var decoded map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return PlaylistInfo{}, nil, err
+	}
+
+	header := dig(decoded, "header", "playlistHeaderRenderer")
+	info := PlaylistInfo{
+		ID:           listID,
+		Title:        cleanText(dig(decoded, "metadata", "playlistMetadataRenderer", "title")),
+		Author:       cleanText(dig(header, "ownerText", "runs", 0, "text")),
+		Description:  cleanText(dig(decoded, "metadata", "playlistMetadataRenderer", "description")),
+		ThumbnailURL: cleanText(dig(decoded, "microformat", "microformatDataRenderer", "thumbnail", "thumbnails", 0, "url")),
+	}
+	if info.Title == "" {
+		info.Title = cleanText(dig(header, "title", "simpleText"))
+	}
+	if info.Title == "" {
+		info.Title = cleanText(dig(header, "title", "runs", 0, "text"))
+	}
+
+	var renderers []map[string]any
+	collectVideoRenderers(decoded, &renderers)
+	return info, convertRenderers(renderers), nil
+}
@@ -0,0 +1,108 @@
+package youtube
+
+import (
+	"image"
+	"strconv"
+	"strings"
+)
+
+// parseStoryboardSpec decodes playerStoryboardSpecRenderer.spec:
+//
+//	<url template>|<w>#<h>#<count>#<cols>#<rows>#<interval ms>#<name>#<sigh>|...
+//
+// Each "|" section after the template is one level, smallest first.
+func parseStoryboardSpec(spec string, lengthSeconds int) []Storyboard {
+	parts := strings.Split(spec, "|")
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "http") {
+		return nil
+	}
+	template := parts[0]
+	boards := make([]Storyboard, 0, len(parts)-1)
+	for level, raw := range parts[1:] {
+		fields := strings.Split(raw, "#")
+		if len(fields) < 8 {
+			continue
+		}
+		nums := make([]int, 6)
+		valid := true
+		for i := range nums {
+			n, err := strconv.Atoi(fields[i])
+			if err != nil || n < 0 {
+				valid = false
+				break
+			}
+			nums[i] = n
+		}
+		if !valid || nums[0] == 0 || nums[1] == 0 || nums[2] == 0 || nums[3] == 0 || nums[4] == 0 {
+			continue
+		}
+		boards = append(boards, Storyboard{
+			Level:       level,
+			Width:       nums[0],
+			Height:      nums[1],
+			Count:       nums[2],
+			Columns:     nums[3],
+			Rows:        nums[4],
+			IntervalMs:  nums[5],
+			urlTemplate: strings.ReplaceAll(template, "$L", strconv.Itoa(level)),
+			name:        fields[6],
+			sigh:        fields[7],
+			duration:    lengthSeconds,
+		})
+	}
+	return boards
+}
+
+// StoryboardFor returns the smallest level at least width pixels wide, or the
+// largest level when none is that wide.
+func (v Video) StoryboardFor(width int) (Storyboard, bool) {
+	if len(v.Storyboards) == 0 {
+		return Storyboard{}, false
+	}
+	for _, sb := range v.Storyboards {
+		if sb.Width >= width {
+			return sb, true
+		}
+	}
+	return v.Storyboards[len(v.Storyboards)-1], true
+}
+
+// FrameIndex maps a position in seconds to a frame of this level.
+func (sb Storyboard) FrameIndex(seconds float64) int {
+	if seconds < 0 {
+		seconds = 0
+	}
+	var idx int
+	switch {
+	case sb.IntervalMs > 0:
+		idx = int(seconds * 1000 / float64(sb.IntervalMs))
+	case sb.duration > 0:
+		idx = int(seconds * float64(sb.Count) / float64(sb.duration))
+	}
+	return max(0, min(idx, sb.Count-1))
+}
+
+// Frame returns the sprite sheet URL holding frame idx and the frame's
+// rectangle inside that sheet.
+func (sb Storyboard) Frame(idx int) (string, image.Rectangle) {
+	perSheet := sb.Columns * sb.Rows
+	idx = max(0, min(idx, sb.Count-1))
+	sheet, cell := idx/perSheet, idx%perSheet
+	x := (cell % sb.Columns) * sb.Width
+	y := (cell / sb.Columns) * sb.Height
+	return sb.SheetURL(sheet), image.Rect(x, y, x+sb.Width, y+sb.Height)
+}
+
+// SheetURL returns the URL of sprite sheet n.
+func (sb Storyboard) SheetURL(n int) string {
+	name := strings.ReplaceAll(sb.name, "$M", strconv.Itoa(n))
+	u := strings.ReplaceAll(sb.urlTemplate, "$N", name)
+	if sb.sigh == "" {
+		return u
+	}
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+	return u + sep + "sigh=" + sb.sigh
+}
@@ -18,6 +18,8 @@ type Format struct {
 	URL     string
 	Quality string
 	Bitrate string
+	Width   int
+	Height  int
 	// ContentLength conveys the total byte size returned by the API (string to avoid int parsing issues in templates).
 	ContentLength string
 }
@@ -25,8 +27,11 @@ type Format struct {
 // CaptionTrack describes an available subtitle track.
 type CaptionTrack struct {
 	Language string
-	URL      string
-	Kind     string
+	// Code is the BCP-47 language code, e.g. "en" or "pt-BR".
+	Code         string
+	URL          string
+	Kind         string
+	Translatable bool
 }
 
 // Video is the aggregate metadata needed for the watch page.
@@ -37,13 +42,35 @@ type Video struct {
 	LengthSeconds string
 	ViewCount     string
 	ChannelID     string
+	Description   string
 	Formats       []Format
 	Audio         []Format
 	Captions      []CaptionTrack
 	Stream        string
 	ThumbURL      string
+	// Published is the upload date (YYYY-MM-DD), empty when not reported.
+	Published string
 	// HLSManifest exposes the adaptive playlist for clients with HLS support.
 	HLSManifest string
+	// Storyboards lists the seek-preview sprite sheet levels, smallest first.
+	Storyboards []Storyboard
+}
+
+// Storyboard is one resolution level of the seek-preview sprite sheets.
+type Storyboard struct {
+	Level   int
+	Width   int
+	Height  int
+	Count   int
+	Columns int
+	Rows    int
+	// IntervalMs is the time between frames; zero spreads Count over the video.
+	IntervalMs int
+	// urlTemplate already has $L substituted; $N still stands for the sheet name.
+	urlTemplate string
+	name        string
+	sigh        string
+	duration    int
 }
 
 // FeedItem represents a lightweight video card for feeds like home or trending.
@@ -66,6 +93,15 @@ type ChannelInfo struct {
 	Description string
 }
 
+// PlaylistInfo holds metadata about a playlist.
+type PlaylistInfo struct {
+	ID           string
+	Title        string
+	Author       string
+	Description  string
+	ThumbnailURL string
+}
+
 // ChannelSections groups channel content buckets.
 type ChannelSections struct {
 	Latest  []FeedItem